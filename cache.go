@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Supported HTTP cache backends
+const (
+	httpCacheMemory = "memory" // Kept for the lifetime of the process, e.g. across daemon runs
+	httpCacheDisk   = "disk"   // One file per response in -http-cache-dir, kept across runs
+)
+
+// Cache limits. Responses of heads that moved on are never requested again,
+// so without them the cache would only grow.
+const (
+	httpCacheMaxEntries = 10000              // Responses kept by the memory cache, least recently used ones are evicted
+	httpCacheMaxAge     = 7 * 24 * time.Hour // Disk cache entries not used for this long are removed when the cache is opened
+)
+
+// cachedResponse is a GitHub API response that can be revalidated with a
+// conditional request
+type cachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// httpCacheStore stores cached responses by key. Failing to read or write an
+// entry is a cache miss, never an error of the request.
+type httpCacheStore interface {
+	Get(key string) (*cachedResponse, bool)
+	Set(key string, response *cachedResponse)
+}
+
+// openHTTPCacheStore opens the cache store of the given backend
+func openHTTPCacheStore(backend, dir string) (httpCacheStore, error) {
+	switch backend {
+	case httpCacheMemory:
+		return newMemoryCacheStore(httpCacheMaxEntries), nil
+	case httpCacheDisk:
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create HTTP cache directory: %v", err)
+		}
+		store := &diskCacheStore{dir: dir}
+		store.prune(httpCacheMaxAge)
+		return store, nil
+	default:
+		return nil, fmt.Errorf("invalid HTTP cache %q: must be %q or %q", backend, httpCacheMemory, httpCacheDisk)
+	}
+}
+
+// defaultHTTPCacheDir returns the per-user cache directory of the tool
+func defaultHTTPCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pr-status-checker", "http")
+}
+
+// memoryCacheStore keeps up to maxEntries responses, evicting the least
+// recently used one when full
+type memoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List               // Entries, most recently used first
+	entries    map[string]*list.Element // Elements of order by key
+}
+
+// memoryCacheEntry is the value of the elements of memoryCacheStore.order
+type memoryCacheEntry struct {
+	key      string
+	response *cachedResponse
+}
+
+func newMemoryCacheStore(maxEntries int) *memoryCacheStore {
+	return &memoryCacheStore{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (s *memoryCacheStore) Get(key string) (*cachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).response, true
+}
+
+func (s *memoryCacheStore) Set(key string, response *cachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*memoryCacheEntry).response = response
+		s.order.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&memoryCacheEntry{key: key, response: response})
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// diskCacheStore keeps one JSON file per response, named after the hash of
+// its key. The modification time of a file is when it was last used.
+type diskCacheStore struct {
+	dir string
+}
+
+func (s *diskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *diskCacheStore) Get(key string) (*cachedResponse, bool) {
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var response cachedResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, false
+	}
+	// Entries still in use survive pruning
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return &response, true
+}
+
+func (s *diskCacheStore) Set(key string, response *cachedResponse) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	// Concurrent runs may write the same entry, so replace it atomically
+	_ = writeFileAtomic(s.path(key), data)
+}
+
+// prune removes the entries not used for maxAge. Entries that cannot be
+// removed are left for the next time.
+func (s *diskCacheStore) prune(maxAge time.Duration) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		_ = os.Remove(filepath.Join(s.dir, entry.Name()))
+	}
+}
+
+// httpCacheStats counts the cacheable requests of a run
+type httpCacheStats struct {
+	requests int64 // GET requests sent
+	hits     int64 // Answered with 304 Not Modified and served from the cache
+}
+
+// cachingTransport revalidates GitHub API responses with conditional
+// requests. GET responses with an ETag or Last-Modified header are stored,
+// and repeated requests send If-None-Match or If-Modified-Since. A 304 Not
+// Modified answer, which does not count against the rate limit, is replaced
+// by the stored response. Since every request is revalidated, cached data is
+// never stale.
+type cachingTransport struct {
+	store httpCacheStore
+	scope string // Hash of the token, so that users sharing a cache don't share responses
+	base  http.RoundTripper
+
+	requests atomic.Int64
+	hits     atomic.Int64
+}
+
+func newCachingTransport(store httpCacheStore, token string, base http.RoundTripper) *cachingTransport {
+	sum := sha256.Sum256([]byte(token))
+	return &cachingTransport{store: store, scope: hex.EncodeToString(sum[:8]), base: base}
+}
+
+// stats returns the counts since the last call and resets them
+func (t *cachingTransport) stats() httpCacheStats {
+	return httpCacheStats{requests: t.requests.Swap(0), hits: t.hits.Swap(0)}
+}
+
+func (t *cachingTransport) key(req *http.Request) string {
+	return t.scope + " " + req.Header.Get("Accept") + " " + req.URL.String()
+}
+
+// cacheable reports whether the request may be answered from the cache
+func cacheable(req *http.Request) bool {
+	return req.Method == http.MethodGet &&
+		req.Header.Get("If-None-Match") == "" &&
+		req.Header.Get("If-Modified-Since") == "" &&
+		req.Header.Get("Range") == ""
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !cacheable(req) {
+		return base.RoundTrip(req)
+	}
+	t.requests.Add(1)
+
+	key := t.key(req)
+	cached, ok := t.store.Get(key)
+	if ok {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if ok && resp.StatusCode == http.StatusNotModified {
+		t.hits.Add(1)
+		return cachedHTTPResponse(req, resp, cached), nil
+	}
+	if resp.StatusCode != http.StatusOK || !storable(resp) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	t.store.Set(key, &cachedResponse{Header: resp.Header.Clone(), Body: body})
+	return resp, nil
+}
+
+// storable reports whether a response can be revalidated later
+func storable(resp *http.Response) bool {
+	if strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	return resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// cachedHTTPResponse turns a 304 Not Modified answer into the stored
+// response. Headers of the 304, such as the current rate limit, take
+// precedence over the stored ones.
+func cachedHTTPResponse(req *http.Request, notModified *http.Response, cached *cachedResponse) *http.Response {
+	_ = notModified.Body.Close()
+	header := cached.Header.Clone()
+	for name, values := range notModified.Header {
+		header[name] = values
+	}
+	header.Set("Content-Length", strconv.Itoa(len(cached.Body)))
+	header.Set("X-From-Cache", "1")
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         notModified.Proto,
+		ProtoMajor:    notModified.ProtoMajor,
+		ProtoMinor:    notModified.ProtoMinor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       req,
+	}
+}
+
+// printCacheStats prints how many requests of the run the HTTP cache
+// answered
+func (p *PRProcessor) printCacheStats(w io.Writer) {
+	if p.httpCache == nil {
+		return
+	}
+	stats := p.httpCache.stats()
+	if stats.requests == 0 {
+		return
+	}
+	fmt.Fprintf(w, "HTTP cache: %d of %d GET requests answered from the cache (%d%%), not counted against the rate limit\n",
+		stats.hits, stats.requests, stats.hits*100/stats.requests)
+}
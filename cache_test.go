@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// newConditionalServer serves a PR list with an ETag and a PR with a
+// Last-Modified date, answering matching conditional requests with 304 Not
+// Modified
+func newConditionalServer(t *testing.T) *recordingTransport {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		if r.Header.Get("If-None-Match") == `"list-v1"` {
+			w.Header().Set("X-RateLimit-Remaining", "4998")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"list-v1"`)
+		writeJSON(t, w, []*github.PullRequest{{Number: github.Ptr(1), Title: github.Ptr("Cached PR")}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		writeJSON(t, w, &github.PullRequest{Number: github.Ptr(1), Title: github.Ptr("Cached PR")})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"review"`)
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	return &recordingTransport{base: &handlerTransport{handler: mux}}
+}
+
+func TestCachingTransport(t *testing.T) {
+	for _, backend := range []string{httpCacheMemory, httpCacheDisk} {
+		t.Run(backend, func(t *testing.T) {
+			store, err := openHTTPCacheStore(backend, t.TempDir())
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			server := newConditionalServer(t)
+			cache := newCachingTransport(store, "token", server)
+			client := github.NewClient(&http.Client{Transport: cache})
+			ctx := context.Background()
+
+			for i := 0; i < 3; i++ {
+				prs, resp, err := client.PullRequests.List(ctx, testOwner, testRepo, nil)
+				if err != nil {
+					t.Fatalf("Request %d: expected no error, got %v", i, err)
+				}
+				if len(prs) != 1 || prs[0].GetTitle() != "Cached PR" {
+					t.Errorf("Request %d: expected the cached PR list, got %v", i, prs)
+				}
+				if i > 0 && (resp.Header.Get("X-From-Cache") != "1" || resp.Rate.Remaining != 4998) {
+					t.Errorf("Request %d: expected a cached response with the current rate limit, got %v", i, resp.Header)
+				}
+				pr, _, err := client.PullRequests.Get(ctx, testOwner, testRepo, 1)
+				if err != nil || pr.GetTitle() != "Cached PR" {
+					t.Errorf("Request %d: expected the cached PR, got %v (%v)", i, pr, err)
+				}
+			}
+			if _, _, err := client.PullRequests.CreateReview(ctx, testOwner, testRepo, 1, &github.PullRequestReviewRequest{}); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if stats := cache.stats(); stats.requests != 6 || stats.hits != 4 {
+				t.Errorf("Expected 4 hits of 6 requests, got %+v", stats)
+			}
+			if stats := cache.stats(); stats.requests != 0 {
+				t.Errorf("Expected the stats to be reset, got %+v", stats)
+			}
+			if calls := server.find("GET", "/repos/test-owner/test-repo/pulls"); len(calls) != 3 {
+				t.Errorf("Expected every request to be revalidated, got %d requests", len(calls))
+			}
+
+			// Another token does not see the cached responses
+			other := github.NewClient(&http.Client{Transport: newCachingTransport(store, "other", server)})
+			if _, resp, err := other.PullRequests.List(ctx, testOwner, testRepo, nil); err != nil || resp.Header.Get("X-From-Cache") != "" {
+				t.Errorf("Expected a fresh response for another token, got %v (%v)", resp.Header, err)
+			}
+		})
+	}
+}
+
+func TestDiskCacheStore_Persists(t *testing.T) {
+	dir := t.TempDir()
+	server := newConditionalServer(t)
+	for run := 0; run < 2; run++ {
+		store, err := openHTTPCacheStore(httpCacheDisk, dir)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		cache := newCachingTransport(store, "token", server)
+		client := github.NewClient(&http.Client{Transport: cache})
+		if _, _, err := client.PullRequests.List(context.Background(), testOwner, testRepo, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if stats := cache.stats(); stats.hits != int64(run) {
+			t.Errorf("Run %d: expected %d hits, got %+v", run, run, stats)
+		}
+	}
+}
+
+func TestPrintSummary_CacheStats(t *testing.T) {
+	store, err := openHTTPCacheStore(httpCacheMemory, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cache := newCachingTransport(store, "token", newConditionalServer(t))
+	processor := &PRProcessor{
+		client:    github.NewClient(&http.Client{Transport: cache}),
+		cfg:       &config{owner: testOwner, repo: testRepo},
+		ctx:       context.Background(),
+		httpCache: cache,
+	}
+	for i := 0; i < 4; i++ {
+		if _, _, err := processor.client.PullRequests.List(processor.ctx, testOwner, testRepo, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	processor.printSummary(&out)
+	if !strings.Contains(out.String(), "HTTP cache: 3 of 4 GET requests answered from the cache (75%)") {
+		t.Errorf("Expected cache stats in the summary, got:\n%s", out.String())
+	}
+}
+
+func TestMemoryCacheStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	store := newMemoryCacheStore(2)
+	store.Set("a", &cachedResponse{Body: []byte("a")})
+	store.Set("b", &cachedResponse{Body: []byte("b")})
+	// Using a makes b the least recently used entry
+	if _, ok := store.Get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	store.Set("c", &cachedResponse{Body: []byte("c")})
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := store.Get(key); ok != expected {
+			t.Errorf("Expected %s cached: %v, got %v", key, expected, ok)
+		}
+	}
+	if len(store.entries) != 2 || store.order.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d (%d in order)", len(store.entries), store.order.Len())
+	}
+}
+
+func TestDiskCacheStore_PrunesUnusedEntries(t *testing.T) {
+	dir := t.TempDir()
+	store := &diskCacheStore{dir: dir}
+	for _, key := range []string{"old", "old-but-used", "recent"} {
+		store.Set(key, &cachedResponse{Body: []byte(key)})
+	}
+	old := time.Now().Add(-httpCacheMaxAge - time.Hour)
+	for _, key := range []string{"old", "old-but-used"} {
+		if err := os.Chtimes(store.path(key), old, old); err != nil {
+			t.Fatalf("Failed to age entry: %v", err)
+		}
+	}
+	if _, ok := store.Get("old-but-used"); !ok {
+		t.Fatal("Expected old-but-used to be cached")
+	}
+	// Not a cache entry, left alone
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chtimes(other, old, old); err != nil {
+		t.Fatalf("Failed to age file: %v", err)
+	}
+
+	reopened, err := openHTTPCacheStore(httpCacheDisk, dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for key, expected := range map[string]bool{"old": false, "old-but-used": true, "recent": true} {
+		if _, ok := reopened.Get(key); ok != expected {
+			t.Errorf("Expected %s cached: %v, got %v", key, expected, ok)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected other files to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Defaults for waiting on pending checks
+const (
+	defaultChecksWaitInterval = 30 * time.Second
+	defaultChecksWaitTimeout  = 30 * time.Minute
+)
+
+// listCheckRuns returns the latest check runs reported for the given commit
+func (p *PRProcessor) listCheckRuns(sha string) ([]*github.CheckRun, error) {
+	opts := &github.ListCheckRunsOptions{
+		Filter:      github.Ptr("latest"),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	var runs []*github.CheckRun
+	for {
+		result, resp, err := p.client.Checks.ListCheckRunsForRef(p.ctx, p.cfg.owner, p.cfg.repo, sha, opts)
+		var errResp *github.ErrorResponse
+		if errors.As(err, &errResp) && errResp.Response.StatusCode == http.StatusForbidden {
+			// Merging without seeing the check runs could merge failing PRs
+			return nil, fmt.Errorf("error getting check runs, the token needs the Checks read permission: %v", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting check runs: %v", err)
+		}
+		runs = append(runs, result.CheckRuns...)
+		if resp == nil || resp.NextPage == 0 {
+			return runs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// checkRunState maps a check run onto the states used for commit statuses:
+// "pending", "failure" or "success"
+func checkRunState(run *github.CheckRun) string {
+	if run.GetStatus() != "completed" {
+		return "pending"
+	}
+	switch run.GetConclusion() {
+	case "failure", "timed_out", "cancelled", "action_required", "startup_failure":
+		return "failure"
+	default:
+		// success, neutral, skipped and stale don't block merging
+		return "success"
+	}
+}
+
+// waitForChecks polls the checks of the PR head until none are pending, one
+// fails or the per-PR deadline passes. If the head changes while waiting the
+// checks of the new head are awaited instead. It returns the PR as last seen
+// together with its failed and pending checks. A PR closed or merged while
+// waiting is skipped, and returned as no longer open.
+func (p *PRProcessor) waitForChecks(pr *github.PullRequest, pendingStatuses []string) (*github.PullRequest, []string, []string, error) {
+	interval := p.cfg.checksWaitInterval
+	if interval <= 0 {
+		interval = defaultChecksWaitInterval
+	}
+	timeout := p.cfg.checksWaitTimeout
+	if timeout <= 0 {
+		timeout = defaultChecksWaitTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	fmt.Printf("PR #%d: Waiting up to %v for pending checks: %s\n", pr.GetNumber(), timeout, strings.Join(pendingStatuses, ", "))
+	for {
+		wait := interval
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		if err := sleepContext(p.ctx, wait); err != nil {
+			return pr, nil, pendingStatuses, fmt.Errorf("PR #%d: waiting for checks cancelled: %w", pr.GetNumber(), err)
+		}
+
+		current, _, err := p.client.PullRequests.Get(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber())
+		if err != nil {
+			return pr, nil, pendingStatuses, fmt.Errorf("error getting PR: %v", err)
+		}
+		if current.GetState() != "" && current.GetState() != "open" {
+			reason := "closed while waiting for checks"
+			if current.GetMerged() {
+				reason = "merged while waiting for checks"
+			}
+			fmt.Printf("PR #%d: No longer open, %s\n", pr.GetNumber(), reason)
+			p.report(pr).skip(skipNotOpen, reason, false)
+			return current, nil, nil, nil
+		}
+		if current.GetHead().GetSHA() != pr.GetHead().GetSHA() {
+			fmt.Printf("PR #%d: Head changed to %s while waiting, waiting for its checks\n", pr.GetNumber(), current.GetHead().GetSHA())
+		}
+		pr = current
+
+		failedStatuses, pending, err := p.checkStatusChecks(pr)
+		if err != nil {
+			return pr, nil, pendingStatuses, err
+		}
+		pendingStatuses = pending
+		if len(failedStatuses) > 0 || len(pendingStatuses) == 0 {
+			fmt.Printf("PR #%d: Checks settled\n", pr.GetNumber())
+			return pr, failedStatuses, pendingStatuses, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			fmt.Printf("PR #%d: Checks still pending after %v\n", pr.GetNumber(), timeout)
+			return pr, failedStatuses, pendingStatuses, nil
+		}
+	}
+}
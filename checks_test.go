@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// handlerTransport serves requests with an http.Handler instead of a network
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (h *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	h.handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("Failed to encode response: %v", err)
+	}
+}
+
+func TestCheckRunState(t *testing.T) {
+	testCases := []struct {
+		status     string
+		conclusion string
+		expected   string
+	}{
+		{status: "queued", expected: "pending"},
+		{status: "in_progress", expected: "pending"},
+		{status: "completed", conclusion: "success", expected: "success"},
+		{status: "completed", conclusion: "neutral", expected: "success"},
+		{status: "completed", conclusion: "skipped", expected: "success"},
+		{status: "completed", conclusion: "failure", expected: "failure"},
+		{status: "completed", conclusion: "timed_out", expected: "failure"},
+		{status: "completed", conclusion: "cancelled", expected: "failure"},
+		{status: "completed", conclusion: "action_required", expected: "failure"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.status+"/"+tc.conclusion, func(t *testing.T) {
+			run := &github.CheckRun{Status: github.Ptr(tc.status)}
+			if tc.conclusion != "" {
+				run.Conclusion = github.Ptr(tc.conclusion)
+			}
+			if state := checkRunState(run); state != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, state)
+			}
+		})
+	}
+}
+
+func TestCheckStatusChecks_IncludesCheckRuns(t *testing.T) {
+	mockResp := &mockTransport{
+		responses: map[string]interface{}{
+			"/repos/test-owner/test-repo/commits/test-sha/status": &github.CombinedStatus{
+				Statuses: []*github.RepoStatus{
+					{State: github.Ptr("success"), Context: github.Ptr("legacy-ci")},
+				},
+			},
+			"/repos/test-owner/test-repo/commits/test-sha/check-runs": &github.ListCheckRunsResults{
+				Total: github.Ptr(3),
+				CheckRuns: []*github.CheckRun{
+					{Name: github.Ptr("build"), Status: github.Ptr("completed"), Conclusion: github.Ptr("success")},
+					{Name: github.Ptr("lint"), Status: github.Ptr("completed"), Conclusion: github.Ptr("failure")},
+					{Name: github.Ptr("test"), Status: github.Ptr("in_progress")},
+				},
+			},
+		},
+	}
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: mockResp}),
+		cfg:    &config{owner: testOwner, repo: testRepo},
+		ctx:    context.Background(),
+	}
+
+	pr := &github.PullRequest{Number: github.Ptr(1), Head: &github.PullRequestBranch{SHA: github.Ptr("test-sha")}}
+	failed, pending, err := processor.checkStatusChecks(pr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(failed) != 1 || failed[0] != "lint" {
+		t.Errorf("Expected lint to fail, got %v", failed)
+	}
+	if len(pending) != 1 || pending[0] != "test" {
+		t.Errorf("Expected test to be pending, got %v", pending)
+	}
+}
+
+// newChecksWaitServer serves a PR whose single check stays pending for the
+// given number of polls and then completes with the given conclusion. The
+// head moves to a new SHA after the first poll when moveHead is set, and the
+// PR is renamed to movedTitle with it if given.
+func newChecksWaitServer(t *testing.T, pendingPolls int, conclusion string, moveHead bool, movedTitle string) (*handlerTransport, *bool) {
+	var mu sync.Mutex
+	polls := 0
+	merged := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		polls++
+		sha, title := "test-sha", "Test PR"
+		if moveHead && polls > 1 {
+			sha = "new-sha"
+			if movedTitle != "" {
+				title = movedTitle
+			}
+		}
+		mu.Unlock()
+		writeJSON(t, w, &github.PullRequest{
+			Number: github.Ptr(1),
+			Title:  github.Ptr(title),
+			State:  github.Ptr("open"),
+			Head:   &github.PullRequestBranch{SHA: github.Ptr(sha)},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		done := polls > pendingPolls && (!moveHead || r.PathValue("sha") == "new-sha")
+		mu.Unlock()
+		run := &github.CheckRun{Name: github.Ptr("test"), Status: github.Ptr("in_progress")}
+		if done {
+			run.Status = github.Ptr("completed")
+			run.Conclusion = github.Ptr(conclusion)
+		}
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(1), CheckRuns: []*github.CheckRun{run}})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr[int64](123)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		merged = true
+		mu.Unlock()
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/{basehead}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(0)})
+	})
+
+	return &handlerTransport{handler: mux}, &merged
+}
+
+func TestProcessSinglePR_WaitForChecks(t *testing.T) {
+	testCases := []struct {
+		name           string
+		waitForChecks  bool
+		pendingPolls   int
+		conclusion     string
+		moveHead       bool
+		movedTitle     string
+		timeout        time.Duration
+		expectedMerged bool
+	}{
+		{
+			name:           "checks succeed while waiting",
+			waitForChecks:  true,
+			pendingPolls:   2,
+			conclusion:     "success",
+			timeout:        time.Second,
+			expectedMerged: true,
+		},
+		{
+			name:           "head moves while waiting",
+			waitForChecks:  true,
+			pendingPolls:   1,
+			conclusion:     "success",
+			moveHead:       true,
+			timeout:        time.Second,
+			expectedMerged: true,
+		},
+		{
+			name:           "policy skips the new head",
+			waitForChecks:  true,
+			pendingPolls:   1,
+			conclusion:     "success",
+			moveHead:       true,
+			movedTitle:     "WIP: Test PR",
+			timeout:        time.Second,
+			expectedMerged: false,
+		},
+		{
+			name:           "checks fail while waiting",
+			waitForChecks:  true,
+			pendingPolls:   1,
+			conclusion:     "failure",
+			timeout:        time.Second,
+			expectedMerged: false,
+		},
+		{
+			name:           "deadline passes",
+			waitForChecks:  true,
+			pendingPolls:   1000,
+			conclusion:     "success",
+			timeout:        20 * time.Millisecond,
+			expectedMerged: false,
+		},
+		{
+			name:           "waiting disabled",
+			waitForChecks:  false,
+			pendingPolls:   0,
+			conclusion:     "success",
+			timeout:        time.Second,
+			expectedMerged: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport, merged := newChecksWaitServer(t, tc.pendingPolls, tc.conclusion, tc.moveHead, tc.movedTitle)
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg: &config{
+					owner:              testOwner,
+					repo:               testRepo,
+					skipPattern:        "^WIP:",
+					approve:            true,
+					autoRebase:         true,
+					waitForChecks:      tc.waitForChecks,
+					checksWaitInterval: time.Millisecond,
+					checksWaitTimeout:  tc.timeout,
+				},
+				ctx: context.Background(),
+			}
+
+			pr := &github.PullRequest{
+				Number: github.Ptr(1),
+				Title:  github.Ptr("Test PR"),
+				Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+				Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha")},
+			}
+			if err := processor.processSinglePR(pr); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if *merged != tc.expectedMerged {
+				t.Errorf("Expected merged to be %v, got %v", tc.expectedMerged, *merged)
+			}
+			if r := processor.report(pr); tc.movedTitle != "" && r.skipCode != skipTitlePattern {
+				t.Errorf("Expected the new head to be skipped by the policy, got %s (%s)", r.outcome, r.reason)
+			}
+		})
+	}
+}
+
+func TestListCheckRuns_Forbidden(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+	})
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: &handlerTransport{handler: mux}}),
+		cfg:    &config{owner: testOwner, repo: testRepo},
+		ctx:    context.Background(),
+	}
+	if _, err := processor.listCheckRuns("test-sha"); err == nil || !strings.Contains(err.Error(), "Checks read permission") {
+		t.Errorf("Expected an error naming the missing permission, got %v", err)
+	}
+}
+
+func TestProcessSinglePR_ClosedWhileWaitingForChecks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequest{
+			Number: github.Ptr(1),
+			State:  github.Ptr("closed"),
+			Merged: github.Ptr(true),
+			Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		run := &github.CheckRun{Name: github.Ptr("test"), Status: github.Ptr("in_progress")}
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(1), CheckRuns: []*github.CheckRun{run}})
+	})
+	transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg: &config{
+			owner: testOwner, repo: testRepo, approve: true, autoRebase: true,
+			waitForChecks: true, checksWaitInterval: time.Millisecond, checksWaitTimeout: time.Second,
+		},
+		ctx: context.Background(),
+	}
+	pr := &github.PullRequest{
+		Number: github.Ptr(1),
+		Title:  github.Ptr("Test PR"),
+		State:  github.Ptr("open"),
+		Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+		Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha")},
+	}
+	if err := processor.processSinglePR(pr); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if r := processor.report(pr); r.outcome != outcomeSkipped || r.skipCode != skipNotOpen || r.reason != "merged while waiting for checks" {
+		t.Errorf("Expected the PR to be skipped as no longer open, got %s (%s)", r.outcome, r.reason)
+	}
+	for _, req := range transport.requests {
+		if req.method != http.MethodGet {
+			t.Errorf("Expected a closed PR not to be changed, got %s %s", req.method, req.path)
+		}
+	}
+}
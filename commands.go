@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Subcommands
+const (
+	commandRun     = "run"     // Process all open PRs, the default
+	commandStatus  = "status"  // Read-only table of the open PRs and their checks
+	commandExplain = "explain" // Why a PR would be merged, skipped or blocked
+	commandApprove = "approve" // Approve a single PR
+	commandMerge   = "merge"   // Merge a single PR
+	commandUpdate  = "update"  // Update the branch of a single PR
+	commandRerun   = "rerun"   // Re-run the failed jobs of a single PR
+)
+
+var commands = []struct {
+	name    string
+	args    string
+	summary string
+}{
+	{commandRun, "", "Process all open PRs (default)"},
+	{commandStatus, "", "Show the open PRs and the state of their checks without changing anything"},
+	{commandExplain, "<pr>", "Show what a run would do with a PR and why, without changing anything"},
+	{commandApprove, "<pr>", "Approve a PR if the policy allows it and its checks pass"},
+	{commandMerge, "<pr>", "Merge a PR if the policy allows it and its checks pass"},
+	{commandUpdate, "<pr>", "Update the branch of a PR if the policy allows it and it is behind"},
+	{commandRerun, "<pr>", "Re-run the failed GitHub Actions jobs of a PR within the -rerun-failed-jobs budget"},
+}
+
+// splitCommand returns the subcommand and its arguments. Without a
+// subcommand "run" is assumed, so that invocations with flags only keep
+// working.
+func splitCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commandRun, args, nil
+	}
+	for _, c := range commands {
+		if args[0] == c.name {
+			return c.name, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown command %q", args[0])
+}
+
+// commandUsage prints the subcommands followed by the flags
+func commandUsage(w io.Writer, program string, flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(w, "Usage: %s [command] [flags] [<pr>]\n\nCommands:\n", program)
+		for _, c := range commands {
+			fmt.Fprintf(w, "  %-18s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+		}
+		fmt.Fprintf(w, "\nFlags:\n")
+		flags.SetOutput(w)
+		flags.PrintDefaults()
+	}
+}
+
+// commandPRNumber validates the positional arguments of a subcommand and
+// returns the PR number the command acts on, or 0 for commands without one
+func commandPRNumber(command string, args []string) (int, error) {
+	switch command {
+	case commandRun, commandStatus:
+		if len(args) > 0 {
+			return 0, fmt.Errorf("%s takes no arguments, got %q", command, strings.Join(args, " "))
+		}
+		return 0, nil
+	}
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%s takes exactly one PR number", command)
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid PR number %q", args[0])
+	}
+	return number, nil
+}
+
+// explainReport is the result of explain printed with -json: the PR data
+// sent to webhooks, including the decision trace, and the planned decision
+type explainReport struct {
+	notifyPR
+	Decision string `json:"decision"`
+}
+
+// writeExplainJSON prints what a run would do with a PR as JSON
+func writeExplainJSON(w io.Writer, r *prReport) error {
+	report := explainReport{notifyPR: r.notifyView(), Decision: plannedDecision(r)}
+	report.Reason = plannedReason(r)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// What a run would do with a PR, by the outcome of a dry run
+var plannedDecisions = map[prOutcome]string{
+	outcomeSkipped:  "skip",
+	outcomeMerged:   "merge",
+	outcomeApproved: "approve",
+	outcomeBlocked:  "blocked",
+	outcomePending:  "wait",
+	outcomeUpdated:  "update branch",
+	outcomeConflict: "conflict",
+	outcomeRetried:  "re-run jobs",
+	outcomeError:    "error",
+	outcomeClosed:   "close",
+}
+
+const dryRunSuffix = " (dry run)"
+
+func plannedDecision(r *prReport) string {
+	if decision, ok := plannedDecisions[r.outcome]; ok {
+		return decision
+	}
+	return string(r.outcome)
+}
+
+func plannedReason(r *prReport) string {
+	return strings.TrimSuffix(r.reason, dryRunSuffix)
+}
+
+// planPR works out what a run would do with the PR by processing it in
+// dry-run mode, without waiting for checks or re-running jobs
+func (p *PRProcessor) planPR(pr *github.PullRequest) *prReport {
+	cfg := *p.cfg
+	cfg.dryRun = true
+	cfg.waitForChecks = false
+	cfg.rerunFailedJobs = 0
+	planner := p.withContext(p.ctx)
+	planner.cfg = &cfg
+	planner.reports = &prReports{}
+	planner.merging = true
+
+	if pr.GetDraft() {
+		planner.report(pr).skip(skipDraft, "draft", false)
+	} else if err := planner.processSinglePR(pr); err != nil {
+		planner.report(pr).decide(outcomeError, err.Error())
+	}
+	return planner.report(pr)
+}
+
+// reportDetails lists everything a decision was based on, one indented line
+// per fact
+func reportDetails(pr *github.PullRequest, r *prReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  Author: %s, %s <- %s\n", pr.GetUser().GetLogin(), pr.GetBase().GetRef(), pr.GetHead().GetRef())
+	if r.policyRule != "" {
+		fmt.Fprintf(&b, "  Policy: %s (%s)\n", r.policyRule, r.policyAction)
+	}
+	if r.skipCode != "" {
+		fmt.Fprintf(&b, "  Skip rule: %s\n", r.skipCode)
+	}
+	if d := r.dependency; d != nil {
+		fmt.Fprintf(&b, "  Dependency update: %s (%s)\n", d, d.updateType)
+	}
+	if len(r.failedChecks) > 0 {
+		fmt.Fprintf(&b, "  Failing checks: %s\n", strings.Join(r.failedChecks, ", "))
+	}
+	if len(r.pendingChecks) > 0 {
+		fmt.Fprintf(&b, "  Pending checks: %s\n", strings.Join(r.pendingChecks, ", "))
+	}
+	if r.behindBy > 0 {
+		fmt.Fprintf(&b, "  Behind %s by %d commits\n", pr.GetBase().GetRef(), r.behindBy)
+	}
+	if len(r.conflictFiles) > 0 {
+		fmt.Fprintf(&b, "  Conflicting files: %s\n", strings.Join(r.conflictFiles, ", "))
+	}
+	return b.String()
+}
+
+// printStatus writes a table of the open PRs and the state of their checks
+func (p *PRProcessor) printStatus(w io.Writer) error {
+	prs, _, err := p.client.PullRequests.List(p.ctx, p.cfg.owner, p.cfg.repo, &github.PullRequestListOptions{
+		State: "open",
+	})
+	if err != nil {
+		return fmt.Errorf("error getting pull requests: %w", err)
+	}
+
+	fmt.Fprintf(w, "%s/%s: %d open PRs\n", p.cfg.owner, p.cfg.repo, len(prs))
+	if freeze := p.freeze.active(time.Now()); freeze != nil {
+		fmt.Fprintf(w, "Merge freeze active: %s\n", freeze)
+	}
+	if len(prs) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PR\tAUTHOR\tBASE\tCHECKS\tTITLE")
+	for _, pr := range prs {
+		failedStatuses, pendingStatuses, total, err := p.checkCommit(pr.GetHead().GetSHA())
+		if err != nil {
+			return fmt.Errorf("PR #%d: %w", pr.GetNumber(), err)
+		}
+		title := pr.GetTitle()
+		if pr.GetDraft() {
+			title = "[draft] " + title
+		}
+		fmt.Fprintf(table, "#%d\t%s\t%s\t%s\t%s\n", pr.GetNumber(), pr.GetUser().GetLogin(), pr.GetBase().GetRef(),
+			checksSummary(failedStatuses, pendingStatuses, total), title)
+	}
+	return table.Flush()
+}
+
+// checksSummary describes the checks of a commit in a few words
+func checksSummary(failedStatuses, pendingStatuses []string, total int) string {
+	switch {
+	case len(failedStatuses) > 0:
+		return "failing: " + strings.Join(failedStatuses, ", ")
+	case len(pendingStatuses) > 0:
+		return "pending: " + strings.Join(pendingStatuses, ", ")
+	case total == 0:
+		return "none"
+	default:
+		return fmt.Sprintf("passing (%d)", total)
+	}
+}
+
+// runPRCommand runs a subcommand acting on a single PR. Actions are only
+// taken if a run would take them too: the policy, including merge freezes,
+// must not skip the PR, and approvals and merges need passing checks.
+func (p *PRProcessor) runPRCommand(w io.Writer, command string, number int) error {
+	p.reports = &prReports{}
+	p.merging = true
+	if p.activeFreeze = p.freeze.active(time.Now()); p.activeFreeze != nil {
+		fmt.Printf("Merge freeze active: %s\n", p.activeFreeze)
+	}
+
+	pr, _, err := p.client.PullRequests.Get(p.ctx, p.cfg.owner, p.cfg.repo, number)
+	if err != nil {
+		return fmt.Errorf("error getting PR #%d: %w", number, err)
+	}
+	if pr.GetState() != "" && pr.GetState() != "open" {
+		return fmt.Errorf("PR #%d is %s", number, pr.GetState())
+	}
+
+	if command == commandExplain {
+		r := p.planPR(pr)
+		if p.cfg.jsonOutput {
+			return writeExplainJSON(w, r)
+		}
+		fmt.Fprintf(w, "\nPR #%d: %s\n", number, pr.GetTitle())
+		fmt.Fprintf(w, "  Decision: %s (%s)\n", plannedDecision(r), plannedReason(r))
+		fmt.Fprint(w, reportDetails(pr, r))
+		if len(r.trace) > 0 {
+			fmt.Fprintf(w, "  Trace:\n%s", formatTrace(r.trace))
+		}
+		return nil
+	}
+
+	if pr.GetDraft() {
+		return fmt.Errorf("PR #%d is a draft", number)
+	}
+	locked, err := p.lockPR(pr)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("PR #%d: %s", number, p.report(pr).reason)
+	}
+	defer p.releaseLocks()
+
+	if _, err := p.loadState(pr); err != nil {
+		return err
+	}
+	skip, err := p.shouldSkipPR(pr)
+	if err != nil {
+		return err
+	}
+	r := p.report(pr)
+	if skip {
+		return fmt.Errorf("PR #%d: skipped due to %s", number, r.reason)
+	}
+
+	switch command {
+	case commandApprove:
+		failedStatuses, pendingStatuses, err := p.checkStatusChecks(pr)
+		if err != nil {
+			return err
+		}
+		if len(failedStatuses) > 0 || len(pendingStatuses) > 0 {
+			r.failedChecks, r.pendingChecks = failedStatuses, pendingStatuses
+			r.decide(outcomeBlocked, "status checks not passed")
+			break
+		}
+		if err := p.approvePR(pr, true); err != nil {
+			return err
+		}
+		r.decide(outcomeApproved, "approved on request")
+	case commandMerge:
+		if err := p.handleSuccessfulPR(pr); err != nil {
+			return err
+		}
+	case commandUpdate:
+		p.updateOnly = true
+		if err := p.tryRebasePR(pr); err != nil {
+			return err
+		}
+		if r.outcome == "" {
+			fmt.Fprintf(w, "PR #%d: Branch is up to date with %s\n", number, pr.GetBase().GetRef())
+			return nil
+		}
+	case commandRerun:
+		// The budget of a run applies, so that failing jobs are not re-run
+		// without end
+		if p.cfg.rerunFailedJobs <= 0 {
+			return fmt.Errorf("PR #%d: re-running failed jobs is disabled, see -rerun-failed-jobs", number)
+		}
+		retried, err := p.rerunFailedJobs(pr)
+		if err != nil {
+			return err
+		}
+		if !retried {
+			return fmt.Errorf("PR #%d: no jobs re-run, %s", number, r.trace[len(r.trace)-1].Result)
+		}
+	}
+	p.finishPR(pr)
+
+	fmt.Fprintf(w, "PR #%d: %s (%s)\n", number, r.outcome, r.reason)
+	if r.outcome == outcomeBlocked || r.outcome == outcomePending {
+		fmt.Fprint(w, reportDetails(pr, r))
+		return fmt.Errorf("PR #%d: %s", number, r.reason)
+	}
+	return nil
+}
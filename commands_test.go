@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestSplitCommand(t *testing.T) {
+	testCases := []struct {
+		args            []string
+		expectedCommand string
+		expectedArgs    []string
+		expectError     bool
+	}{
+		{nil, commandRun, nil, false},
+		{[]string{"-owner", "o"}, commandRun, []string{"-owner", "o"}, false},
+		{[]string{"run", "-dry-run"}, commandRun, []string{"-dry-run"}, false},
+		{[]string{"merge", "-dry-run", "12"}, commandMerge, []string{"-dry-run", "12"}, false},
+		{[]string{"deploy"}, "", nil, true},
+	}
+	for _, tc := range testCases {
+		command, args, err := splitCommand(tc.args)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%v: expected error %v, got %v", tc.args, tc.expectError, err)
+			continue
+		}
+		if command != tc.expectedCommand || strings.Join(args, " ") != strings.Join(tc.expectedArgs, " ") {
+			t.Errorf("%v: expected %s %v, got %s %v", tc.args, tc.expectedCommand, tc.expectedArgs, command, args)
+		}
+	}
+}
+
+func TestCommandPRNumber(t *testing.T) {
+	testCases := []struct {
+		command     string
+		args        []string
+		expected    int
+		expectError bool
+	}{
+		{commandRun, nil, 0, false},
+		{commandStatus, []string{"12"}, 0, true},
+		{commandMerge, []string{"12"}, 12, false},
+		{commandExplain, []string{"#7"}, 7, false},
+		{commandApprove, nil, 0, true},
+		{commandUpdate, []string{"1", "2"}, 0, true},
+		{commandRerun, []string{"3"}, 3, false},
+		{commandMerge, []string{"twelve"}, 0, true},
+	}
+	for _, tc := range testCases {
+		number, err := commandPRNumber(tc.command, tc.args)
+		if (err != nil) != tc.expectError || number != tc.expected {
+			t.Errorf("%s %v: expected %d (error %v), got %d (%v)", tc.command, tc.args, tc.expected, tc.expectError, number, err)
+		}
+	}
+}
+
+// newCommandTestServer serves a green PR #1, a PR #2 with a failing check, a
+// PR #3 with a pending check and a draft PR #4
+func newCommandTestServer(t *testing.T) *recordingTransport {
+	states := map[string]string{"sha-1": "success", "sha-2": "failure", "sha-3": "pending", "sha-4": "success"}
+	var prs []*github.PullRequest
+	for number := 1; number <= 4; number++ {
+		prs = append(prs, &github.PullRequest{
+			Number: github.Ptr(number),
+			State:  github.Ptr("open"),
+			Title:  github.Ptr("PR " + strconv.Itoa(number)),
+			Draft:  github.Ptr(number == 4),
+			User:   &github.User{Login: github.Ptr("octocat")},
+			Head:   &github.PullRequestBranch{SHA: github.Ptr("sha-" + strconv.Itoa(number)), Ref: github.Ptr("feature")},
+			Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, prs)
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/{number}", func(w http.ResponseWriter, r *http.Request) {
+		number, _ := strconv.Atoi(r.PathValue("number"))
+		writeJSON(t, w, prs[number-1])
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr(states[r.PathValue("sha")]), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/{spec}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(0)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/{number}/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/{number}/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	return &recordingTransport{base: &handlerTransport{handler: mux}}
+}
+
+func TestPrintStatus(t *testing.T) {
+	transport := newCommandTestServer(t)
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg:    &config{owner: testOwner, repo: testRepo},
+		ctx:    context.Background(),
+	}
+
+	var out bytes.Buffer
+	if err := processor.printStatus(&out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, expected := range []string{"4 open PRs", "passing (1)", "failing: test-check", "pending: test-check", "[draft] PR 4"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected status to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+	for _, req := range transport.requests {
+		if req.method != http.MethodGet {
+			t.Errorf("Expected status to be read-only, got %s %s", req.method, req.path)
+		}
+	}
+}
+
+func TestRunPRCommand(t *testing.T) {
+	testCases := []struct {
+		name           string
+		command        string
+		number         int
+		skipPattern    string
+		expectError    string
+		expectedOutput string
+		expectedCalls  []string // "METHOD path" of the changes made
+	}{
+		{
+			name:           "merge green PR",
+			command:        commandMerge,
+			number:         1,
+			expectedOutput: "PR #1: merged",
+			expectedCalls:  []string{"POST /repos/test-owner/test-repo/pulls/1/reviews", "PUT /repos/test-owner/test-repo/pulls/1/merge"},
+		},
+		{
+			name:           "merge refuses failing checks",
+			command:        commandMerge,
+			number:         2,
+			expectError:    reasonChecksFailed,
+			expectedOutput: "Failing checks: test-check",
+		},
+		{
+			name:           "approve refuses pending checks",
+			command:        commandApprove,
+			number:         3,
+			expectError:    "status checks not passed",
+			expectedOutput: "Pending checks: test-check",
+		},
+		{
+			name:        "merge refuses PRs skipped by the policy",
+			command:     commandMerge,
+			number:      1,
+			skipPattern: "^PR",
+			expectError: "skipped due to title matches skip pattern",
+		},
+		{
+			name:        "merge refuses drafts",
+			command:     commandMerge,
+			number:      4,
+			expectError: "is a draft",
+		},
+		{
+			name:           "update up-to-date branch",
+			command:        commandUpdate,
+			number:         1,
+			expectedOutput: "Branch is up to date with main",
+		},
+		{
+			name:           "explain changes nothing",
+			command:        commandExplain,
+			number:         1,
+			expectedOutput: "Decision: merge (all status checks passed)",
+		},
+		{
+			name:           "explain prints the decision trace",
+			command:        commandExplain,
+			number:         2,
+			expectedOutput: "  Trace:\n    1. [policy] default: no rule matched, merge\n    2. [checks] status-checks: failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := newCommandTestServer(t)
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg:    &config{owner: testOwner, repo: testRepo, approve: true, autoRebase: true, skipPattern: tc.skipPattern},
+				ctx:    context.Background(),
+			}
+
+			var out bytes.Buffer
+			err := processor.runPRCommand(&out, tc.command, tc.number)
+			if tc.expectError == "" && err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if tc.expectError != "" && (err == nil || !strings.Contains(err.Error(), tc.expectError)) {
+				t.Fatalf("Expected error containing %q, got %v", tc.expectError, err)
+			}
+			if !strings.Contains(out.String(), tc.expectedOutput) {
+				t.Errorf("Expected output containing %q, got:\n%s", tc.expectedOutput, out.String())
+			}
+
+			var changes []string
+			for _, req := range transport.requests {
+				if req.method != http.MethodGet {
+					changes = append(changes, req.method+" "+req.path)
+				}
+			}
+			if strings.Join(changes, ", ") != strings.Join(tc.expectedCalls, ", ") {
+				t.Errorf("Expected changes %v, got %v", tc.expectedCalls, changes)
+			}
+		})
+	}
+}
+
+func TestRunPRCommand_UpdateDoesNotMerge(t *testing.T) {
+	var mu sync.Mutex
+	head := "old-sha"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		writeJSON(t, w, &github.PullRequest{
+			Number: github.Ptr(1),
+			State:  github.Ptr("open"),
+			Title:  github.Ptr("PR 1"),
+			User:   &github.User{Login: github.Ptr("octocat")},
+			Head:   &github.PullRequestBranch{SHA: github.Ptr(head), Ref: github.Ptr("feature")},
+			Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/{spec}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(2)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/update-branch", func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		head = "new-sha"
+		mu.Unlock()
+		writeJSON(t, w, &github.PullRequestBranchUpdateResponse{Message: github.Ptr("Updating pull request branch.")})
+	})
+	// No checks are reported for the new head, which counts as passing
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg: &config{
+			owner: testOwner, repo: testRepo, approve: true, autoRebase: true,
+			updateStrategy: updateStrategyMerge, updateWaitInterval: time.Millisecond, updateWaitTimeout: time.Second,
+		},
+		ctx: context.Background(),
+	}
+	var out bytes.Buffer
+	if err := processor.runPRCommand(&out, commandUpdate, 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "PR #1: updated") {
+		t.Errorf("Expected the branch to be updated, got:\n%s", out.String())
+	}
+	if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge"); len(calls) != 0 {
+		t.Errorf("Expected update not to merge the PR, got %d merges", len(calls))
+	}
+	if calls := transport.find("POST", "/repos/test-owner/test-repo/pulls/1/reviews"); len(calls) != 0 {
+		t.Errorf("Expected update not to approve the PR, got %d reviews", len(calls))
+	}
+}
+
+func TestRunPRCommand_ExplainJSON(t *testing.T) {
+	transport := newCommandTestServer(t)
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg:    &config{owner: testOwner, repo: testRepo, approve: true, autoRebase: true, jsonOutput: true},
+		ctx:    context.Background(),
+	}
+
+	var out bytes.Buffer
+	if err := processor.runPRCommand(&out, commandExplain, 2); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var report struct {
+		Number       int            `json:"number"`
+		Decision     string         `json:"decision"`
+		Reason       string         `json:"reason"`
+		FailedChecks []string       `json:"failed_checks"`
+		Trace        []decisionStep `json:"trace"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Expected JSON, got %v:\n%s", err, out.String())
+	}
+	if report.Number != 2 || report.Decision != "blocked" || report.Reason != reasonChecksFailed || len(report.FailedChecks) != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(report.Trace) < 2 || report.Trace[0].String() != "[policy] default: no rule matched, merge" || report.Trace[1].Stage != stageChecks {
+		t.Errorf("Expected the decision trace in the report, got %v", report.Trace)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// commentMarker returns the hidden HTML marker identifying the bot comment of
+// the given kind. Data is appended to the marker so that state can be stored
+// in the comment without being rendered.
+func commentMarker(kind string) string {
+	return "<!-- pr-status-checker:" + kind
+}
+
+// findMarkedComment returns the first comment of the authenticated user on the
+// PR that carries the marker of the given kind, or nil if there is none.
+// Comments of others are ignored, since anyone can copy a marker to tamper
+// with the state stored in it. If the user is unknown, as with installation
+// tokens, only comments of -bot-login count as own; without it, the comment
+// cannot be told apart from copies and an error is returned.
+func (p *PRProcessor) findMarkedComment(pr *github.PullRequest, kind string) (*github.IssueComment, error) {
+	if p.commentLogin() == "" {
+		return nil, errors.New("cannot recognize own comments, the authenticated user is unknown: set -bot-login to the login of the app")
+	}
+	marker := commentMarker(kind)
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := p.client.Issues.ListComments(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing comments: %v", err)
+		}
+		for _, comment := range comments {
+			if p.ownComment(comment) && strings.Contains(comment.GetBody(), marker) {
+				return comment, nil
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// defaultActionsBotLogin is the login GITHUB_TOKEN comments as in GitHub Actions
+const defaultActionsBotLogin = "github-actions[bot]"
+
+// commentLogin returns the login the tool comments as, or "" if it is unknown
+func (p *PRProcessor) commentLogin() string {
+	if p.currentUser != "" {
+		return p.currentUser
+	}
+	return p.cfg.botLogin
+}
+
+// ownComment reports whether the comment was written by the authenticated user
+func (p *PRProcessor) ownComment(comment *github.IssueComment) bool {
+	login := p.commentLogin()
+	return login != "" && strings.EqualFold(comment.GetUser().GetLogin(), login)
+}
+
+// upsertMarkedComment edits the existing marked comment or creates a new one
+// if existing is nil. The body must contain the marker.
+func (p *PRProcessor) upsertMarkedComment(pr *github.PullRequest, existing *github.IssueComment, body string) error {
+	if existing != nil {
+		if existing.GetBody() == body || p.dryRun(pr, "edit comment %d", existing.GetID()) {
+			return nil
+		}
+		_, _, err := p.client.Issues.EditComment(p.ctx, p.cfg.owner, p.cfg.repo, existing.GetID(), &github.IssueComment{Body: github.Ptr(body)})
+		if err != nil {
+			return fmt.Errorf("error editing comment: %v", err)
+		}
+		return nil
+	}
+
+	if p.dryRun(pr, "comment") {
+		return nil
+	}
+	_, _, err := p.client.Issues.CreateComment(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), &github.IssueComment{Body: github.Ptr(body)})
+	if err != nil {
+		return fmt.Errorf("error creating comment: %v", err)
+	}
+	return nil
+}
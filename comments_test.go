@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// commentAuthor is the user the fake comment endpoints post as, an app like
+// with the installation token of GitHub Actions
+var commentAuthor = &github.User{Login: github.Ptr("github-actions[bot]"), Type: github.Ptr("Bot")}
+
+// commentStore fakes the issue comment endpoints of PR #1. New comments are
+// posted as author, or commentAuthor if nil.
+type commentStore struct {
+	mu       sync.Mutex
+	author   *github.User
+	comments []*github.IssueComment
+	edits    int
+}
+
+func (c *commentStore) register(t *testing.T, mux *http.ServeMux) {
+	mux.HandleFunc("GET /repos/test-owner/test-repo/issues/1/comments", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, c.list())
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			t.Errorf("Failed to decode comment: %v", err)
+		}
+		c.mu.Lock()
+		comment.ID = github.Ptr(int64(len(c.comments) + 100))
+		comment.User = c.author
+		if comment.User == nil {
+			comment.User = commentAuthor
+		}
+		c.comments = append(c.comments, &comment)
+		c.mu.Unlock()
+		writeJSON(t, w, &comment)
+	})
+	mux.HandleFunc("PATCH /repos/test-owner/test-repo/issues/comments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			t.Errorf("Failed to decode comment: %v", err)
+		}
+		c.mu.Lock()
+		c.edits++
+		for _, existing := range c.comments {
+			if strconv.FormatInt(existing.GetID(), 10) == r.PathValue("id") {
+				existing.Body = comment.Body
+			}
+		}
+		c.mu.Unlock()
+		writeJSON(t, w, &comment)
+	})
+}
+
+// list returns a snapshot of the stored comments
+func (c *commentStore) list() []*github.IssueComment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	comments := make([]*github.IssueComment, len(c.comments))
+	for i, comment := range c.comments {
+		copied := *comment
+		comments[i] = &copied
+	}
+	return comments
+}
+
+func TestFindMarkedComment(t *testing.T) {
+	marker := commentMarker(statusCommentKind) + " -->"
+	comments := &commentStore{comments: []*github.IssueComment{
+		{ID: github.Ptr(int64(1)), Body: github.Ptr(marker), User: &github.User{Login: github.Ptr("mallory"), Type: github.Ptr("User")}},
+		{ID: github.Ptr(int64(2)), Body: github.Ptr(marker), User: &github.User{Login: github.Ptr("other-app[bot]"), Type: github.Ptr("Bot")}},
+		{ID: github.Ptr(int64(3)), Body: github.Ptr(marker), User: &github.User{Login: github.Ptr("Checker"), Type: github.Ptr("User")}},
+	}}
+	mux := http.NewServeMux()
+	comments.register(t, mux)
+
+	testCases := []struct {
+		name        string
+		currentUser string
+		botLogin    string
+		expectedID  int64
+		expectErr   bool
+	}{
+		{name: "authenticated user", currentUser: "checker", expectedID: 3},
+		{name: "authenticated user takes precedence", currentUser: "checker", botLogin: "other-app[bot]", expectedID: 3},
+		{name: "unknown user matches the bot login", botLogin: "other-app[bot]", expectedID: 2},
+		{name: "unknown user ignores other apps", botLogin: "my-app[bot]"},
+		{name: "unknown user without bot login", expectErr: true},
+		{name: "no own comment", currentUser: "someone-else"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			processor := &PRProcessor{
+				client:      github.NewClient(&http.Client{Transport: &handlerTransport{handler: mux}}),
+				cfg:         &config{owner: testOwner, repo: testRepo, botLogin: tc.botLogin},
+				ctx:         context.Background(),
+				currentUser: tc.currentUser,
+			}
+			comment, err := processor.findMarkedComment(&github.PullRequest{Number: github.Ptr(1)}, statusCommentKind)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error for an unknown user, got comment %d", comment.GetID())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if comment.GetID() != tc.expectedID {
+				t.Errorf("Expected comment %d, got %d", tc.expectedID, comment.GetID())
+			}
+		})
+	}
+}
+
+func TestLoadConfig_BotLogin(t *testing.T) {
+	testCases := []struct {
+		name          string
+		args          []string
+		botLoginEnv   string
+		actions       string
+		expectedLogin string
+	}{
+		{name: "not set"},
+		{name: "flag", args: []string{"-bot-login", "my-app[bot]"}, actions: "true", expectedLogin: "my-app[bot]"},
+		{name: "environment", botLoginEnv: "my-app[bot]", expectedLogin: "my-app[bot]"},
+		{name: "GitHub Actions default", actions: "true", expectedLogin: defaultActionsBotLogin},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clearTokenEnv(t)
+			t.Setenv("GITHUB_BOT_LOGIN", tc.botLoginEnv)
+			t.Setenv("GITHUB_ACTIONS", tc.actions)
+
+			args := append([]string{"-token", testToken, "-owner", testOwner, "-repo", testRepo}, tc.args...)
+			cfg, err := loadConfigWithFlags(flag.NewFlagSet("test", flag.ContinueOnError), args)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if cfg.botLogin != tc.expectedLogin {
+				t.Errorf("Expected bot login %q, got %q", tc.expectedLogin, cfg.botLogin)
+			}
+		})
+	}
+}
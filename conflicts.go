@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// conflictCommentKind identifies the comment listing the conflicting files
+const conflictCommentKind = "conflict"
+
+// handlesConflicts reports whether conflicting PRs are labeled or commented on
+func (p *PRProcessor) handlesConflicts() bool {
+	return p.cfg.conflictLabel != "" || p.cfg.conflictComment
+}
+
+// detectConflict asks GitHub whether the PR can be merged into its base
+// branch. The list endpoint doesn't include mergeability, so the PR is fetched
+// again. GitHub computes mergeability in the background; until it is known
+// the PR is not considered conflicting.
+func (p *PRProcessor) detectConflict(pr *github.PullRequest) (bool, error) {
+	full, _, err := p.client.PullRequests.Get(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber())
+	if err != nil {
+		return false, fmt.Errorf("error getting PR mergeability: %v", err)
+	}
+	p.report(pr).mergeable = full.Mergeable
+	return full.GetMergeableState() == "dirty" || (full.Mergeable != nil && !full.GetMergeable()), nil
+}
+
+// likelyConflictingFiles returns the files changed both by the PR and on the
+// base branch since the PR branched off. GitHub doesn't report which files
+// conflict, but these are the only candidates.
+func (p *PRProcessor) likelyConflictingFiles(pr *github.PullRequest) ([]string, error) {
+	baseRef := pr.GetBase().GetRef()
+	headSide, _, err := p.client.Repositories.CompareCommits(p.ctx, p.cfg.owner, p.cfg.repo, baseRef, pr.GetHead().GetSHA(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing commits: %v", err)
+	}
+	baseSide, _, err := p.client.Repositories.CompareCommits(p.ctx, p.cfg.owner, p.cfg.repo, headSide.GetMergeBaseCommit().GetSHA(), baseRef, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing commits: %v", err)
+	}
+
+	changedOnBase := make(map[string]bool)
+	for _, file := range baseSide.Files {
+		changedOnBase[file.GetFilename()] = true
+	}
+	var files []string
+	for _, file := range headSide.Files {
+		if changedOnBase[file.GetFilename()] {
+			files = append(files, file.GetFilename())
+		}
+	}
+	return files, nil
+}
+
+func hasLabel(pr *github.PullRequest, name string) bool {
+	for _, label := range pr.Labels {
+		if strings.EqualFold(label.GetName(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictCommentBody renders the comment telling the author how to resolve
+// the conflicts
+func conflictCommentBody(r *prReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s -->\n", commentMarker(conflictCommentKind))
+	b.WriteString("### Merge conflicts\n\n")
+	fmt.Fprintf(&b, "@%s this PR has conflicts with `%s` that can't be resolved automatically. "+
+		"Please rebase the branch onto `%s` or merge `%s` into it and resolve them.\n", r.author, r.baseRef, r.baseRef, r.baseRef)
+	if len(r.conflictFiles) > 0 {
+		if r.conflictFilesLikely {
+			b.WriteString("\nFiles changed on both sides, likely conflicting:\n\n")
+		} else {
+			b.WriteString("\nConflicting files:\n\n")
+		}
+		for _, file := range r.conflictFiles {
+			fmt.Fprintf(&b, "- `%s`\n", file)
+		}
+	}
+	return b.String()
+}
+
+// syncConflictLabel labels and comments on conflicting PRs, and removes the
+// label once GitHub reports the PR as mergeable again
+func (p *PRProcessor) syncConflictLabel(pr *github.PullRequest) error {
+	r := p.report(pr)
+	if r.unchanged {
+		// Labeled when the conflict was first detected
+		return nil
+	}
+
+	if r.outcome == outcomeConflict {
+		if p.cfg.conflictLabel != "" && !hasLabel(pr, p.cfg.conflictLabel) && !p.dryRun(pr, "add label %s", p.cfg.conflictLabel) {
+			fmt.Printf("PR #%d: Adding label %s\n", pr.GetNumber(), p.cfg.conflictLabel)
+			if _, _, err := p.client.Issues.AddLabelsToIssue(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), []string{p.cfg.conflictLabel}); err != nil {
+				return fmt.Errorf("error adding label: %v", err)
+			}
+		}
+		if !p.cfg.conflictComment {
+			return nil
+		}
+		if r.conflictFiles == nil {
+			files, err := p.likelyConflictingFiles(pr)
+			if err != nil {
+				return err
+			}
+			r.conflictFiles, r.conflictFilesLikely = files, true
+		}
+		existing, err := p.findMarkedComment(pr, conflictCommentKind)
+		if err != nil {
+			return err
+		}
+		return p.upsertMarkedComment(pr, existing, conflictCommentBody(r))
+	}
+
+	if r.mergeable == nil || !*r.mergeable || p.cfg.conflictLabel == "" || !hasLabel(pr, p.cfg.conflictLabel) {
+		return nil
+	}
+	if p.dryRun(pr, "remove label %s", p.cfg.conflictLabel) {
+		return nil
+	}
+	fmt.Printf("PR #%d: Conflicts resolved, removing label %s\n", pr.GetNumber(), p.cfg.conflictLabel)
+	if _, err := p.client.Issues.RemoveLabelForIssue(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), p.cfg.conflictLabel); err != nil {
+		return fmt.Errorf("error removing label: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// newConflictTestServer serves a single open PR with the given labels and
+// mergeability, passing checks and a comment store
+func newConflictTestServer(t *testing.T, labels []string, mergeable *bool, mergeableState string) (*recordingTransport, *[]string) {
+	var comments []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		pr := &github.PullRequest{
+			Number: github.Ptr(1),
+			Title:  github.Ptr("Test PR"),
+			User:   &github.User{Login: github.Ptr("octocat")},
+			Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+			Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+		}
+		for _, label := range labels {
+			pr.Labels = append(pr.Labels, &github.Label{Name: github.Ptr(label)})
+		}
+		writeJSON(t, w, []*github.PullRequest{pr})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequest{Number: github.Ptr(1), Mergeable: mergeable, MergeableState: github.Ptr(mergeableState)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/main...test-sha", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CommitsComparison{
+			MergeBaseCommit: &github.RepositoryCommit{SHA: github.Ptr("merge-base")},
+			Files:           []*github.CommitFile{{Filename: github.Ptr("go.mod")}, {Filename: github.Ptr("main.go")}},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/merge-base...main", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CommitsComparison{
+			Files: []*github.CommitFile{{Filename: github.Ptr("main.go")}, {Filename: github.Ptr("README.md")}},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/issues/1/labels", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.Label{{Name: github.Ptr("needs-rebase")}})
+	})
+	mux.HandleFunc("DELETE /repos/test-owner/test-repo/issues/1/labels/needs-rebase", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/issues/1/comments", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.IssueComment{})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			t.Errorf("Failed to decode comment: %v", err)
+		}
+		comments = append(comments, comment.GetBody())
+		writeJSON(t, w, &comment)
+	})
+	return &recordingTransport{base: &handlerTransport{handler: mux}}, &comments
+}
+
+func TestProcessPullRequests_Conflicts(t *testing.T) {
+	testCases := []struct {
+		name            string
+		labels          []string
+		mergeable       *bool
+		mergeableState  string
+		expectedOutcome prOutcome
+		expectLabel     bool
+		expectUnlabel   bool
+		expectComment   bool
+	}{
+		{
+			name:            "conflicting PR is labeled and commented on",
+			mergeable:       github.Ptr(false),
+			mergeableState:  "dirty",
+			expectedOutcome: outcomeConflict,
+			expectLabel:     true,
+			expectComment:   true,
+		},
+		{
+			name:            "already labeled conflicting PR is not labeled again",
+			labels:          []string{"needs-rebase"},
+			mergeable:       github.Ptr(false),
+			mergeableState:  "dirty",
+			expectedOutcome: outcomeConflict,
+			expectComment:   true,
+		},
+		{
+			name:            "label is removed once the PR is mergeable",
+			labels:          []string{"needs-rebase"},
+			mergeable:       github.Ptr(true),
+			mergeableState:  "clean",
+			expectedOutcome: outcomeMerged,
+			expectUnlabel:   true,
+		},
+		{
+			name:            "label is kept while mergeability is unknown",
+			labels:          []string{"needs-rebase"},
+			mergeableState:  "unknown",
+			expectedOutcome: outcomeMerged,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport, comments := newConflictTestServer(t, tc.labels, tc.mergeable, tc.mergeableState)
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg:    &config{owner: testOwner, repo: testRepo, botLogin: commentAuthor.GetLogin(), conflictLabel: "needs-rebase", conflictComment: true},
+				ctx:    context.Background(),
+			}
+			if err := processor.ProcessPullRequests(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if r := processor.sortedReports()[0]; r.outcome != tc.expectedOutcome {
+				t.Errorf("Expected %s, got %s (%s)", tc.expectedOutcome, r.outcome, r.reason)
+			}
+			if got := len(transport.find("POST", "/repos/test-owner/test-repo/issues/1/labels")) > 0; got != tc.expectLabel {
+				t.Errorf("Expected label added %v, got %v", tc.expectLabel, got)
+			}
+			if got := len(transport.find("DELETE", "/repos/test-owner/test-repo/issues/1/labels/needs-rebase")) > 0; got != tc.expectUnlabel {
+				t.Errorf("Expected label removed %v, got %v", tc.expectUnlabel, got)
+			}
+			if got := len(*comments) > 0; got != tc.expectComment {
+				t.Fatalf("Expected comment %v, got %v", tc.expectComment, *comments)
+			}
+			if tc.expectComment {
+				body := (*comments)[0]
+				if !strings.Contains(body, "likely conflicting") || !strings.Contains(body, "- `main.go`") || strings.Contains(body, "go.mod") {
+					t.Errorf("Expected comment to list main.go as likely conflicting, got:\n%s", body)
+				}
+			}
+			if tc.expectedOutcome == outcomeConflict {
+				if calls := transport.find("GET", "/repos/test-owner/test-repo/commits/test-sha/status"); len(calls) != 0 {
+					t.Errorf("Expected checks not to be fetched for a conflicting PR, got %d calls", len(calls))
+				}
+			}
+		})
+	}
+}
+
+func TestConflictCommentBody_RebaseFiles(t *testing.T) {
+	r := &prReport{author: "octocat", baseRef: "main", conflictFiles: []string{"a.go", "b.go"}}
+	body := conflictCommentBody(r)
+	if !strings.Contains(body, "Conflicting files:") || !strings.Contains(body, "- `b.go`") || !strings.Contains(body, "@octocat") {
+		t.Errorf("Expected conflicting files and author mention, got:\n%s", body)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// errBranchDeleted is returned by keepHeadBranch when the head branch no
+// longer exists
+var errBranchDeleted = errors.New("branch already deleted")
+
+// deleteHeadBranch deletes the head branch of a merged PR unless it lives in
+// a fork, is protected, or is the base of other open PRs. The result is
+// recorded in the report; failures are logged rather than failing the PR.
+func (p *PRProcessor) deleteHeadBranch(pr *github.PullRequest) {
+	r := p.report(pr)
+	ref := pr.GetHead().GetRef()
+
+	keep, err := p.keepHeadBranch(pr)
+	if errors.Is(err, errBranchDeleted) {
+		fmt.Printf("PR #%d: Branch %s was already deleted\n", pr.GetNumber(), ref)
+		r.branchDeletion = "already deleted"
+		return
+	}
+	if err != nil {
+		fmt.Printf("PR #%d: Not deleting branch %s: %v\n", pr.GetNumber(), ref, err)
+		r.branchDeletion = "not deleted: " + err.Error()
+		return
+	}
+	if keep != "" {
+		fmt.Printf("PR #%d: Keeping branch %s: %s\n", pr.GetNumber(), ref, keep)
+		r.branchDeletion = "kept, " + keep
+		return
+	}
+
+	if p.dryRun(pr, "delete branch %s", ref) {
+		r.branchDeletion = "would be deleted (dry run)"
+		return
+	}
+	_, err = p.client.Git.DeleteRef(p.ctx, p.cfg.owner, p.cfg.repo, "heads/"+ref)
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response.StatusCode == http.StatusUnprocessableEntity {
+		// Repositories with automatic branch deletion beat us to it
+		fmt.Printf("PR #%d: Branch %s was already deleted\n", pr.GetNumber(), ref)
+		r.branchDeletion = "already deleted"
+		return
+	}
+	if err != nil {
+		fmt.Printf("PR #%d: Error deleting branch %s: %v\n", pr.GetNumber(), ref, err)
+		r.branchDeletion = "not deleted: " + err.Error()
+		return
+	}
+	fmt.Printf("PR #%d: Deleted branch %s\n", pr.GetNumber(), ref)
+	r.branchDeleted = true
+	r.branchDeletion = "deleted " + ref
+}
+
+// keepHeadBranch returns why the head branch of the PR must not be deleted,
+// or an empty string if it can be
+func (p *PRProcessor) keepHeadBranch(pr *github.PullRequest) (string, error) {
+	// The head repository is nil once a fork has been deleted
+	headRepo := pr.GetHead().GetRepo()
+	if headRepo == nil || headRepo.GetFullName() != pr.GetBase().GetRepo().GetFullName() {
+		return "head is in a fork", nil
+	}
+
+	ref := pr.GetHead().GetRef()
+	branch, resp, err := p.client.Repositories.GetBranch(p.ctx, p.cfg.owner, p.cfg.repo, ref, 0)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		// Deleted by GitHub or someone else before the PR was processed
+		return "", errBranchDeleted
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting branch: %v", err)
+	}
+	if branch.GetProtected() {
+		return "branch is protected", nil
+	}
+
+	stacked, _, err := p.client.PullRequests.List(p.ctx, p.cfg.owner, p.cfg.repo, &github.PullRequestListOptions{
+		State:       "open",
+		Base:        ref,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing PRs based on the branch: %v", err)
+	}
+	if len(stacked) > 0 {
+		return fmt.Sprintf("branch is the base of PR #%d", stacked[0].GetNumber()), nil
+	}
+	return "", nil
+}
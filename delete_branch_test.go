@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestDeleteHeadBranch(t *testing.T) {
+	testCases := []struct {
+		name             string
+		headRepo         string
+		protected        bool
+		stacked          bool
+		missing          bool
+		deleteStatus     int
+		expectDelete     bool
+		expectedDeletion string
+	}{
+		{
+			name:             "same repository branch is deleted",
+			headRepo:         "test-owner/test-repo",
+			deleteStatus:     http.StatusNoContent,
+			expectDelete:     true,
+			expectedDeletion: "deleted feature",
+		},
+		{
+			name:             "fork is kept",
+			headRepo:         "someone/test-repo",
+			expectedDeletion: "kept, head is in a fork",
+		},
+		{
+			name:             "protected branch is kept",
+			headRepo:         "test-owner/test-repo",
+			protected:        true,
+			expectedDeletion: "kept, branch is protected",
+		},
+		{
+			name:             "base of another PR is kept",
+			headRepo:         "test-owner/test-repo",
+			stacked:          true,
+			expectedDeletion: "kept, branch is the base of PR #2",
+		},
+		{
+			name:             "branch deleted by GitHub",
+			headRepo:         "test-owner/test-repo",
+			deleteStatus:     http.StatusUnprocessableEntity,
+			expectDelete:     true,
+			expectedDeletion: "already deleted",
+		},
+		{
+			name:             "branch missing before deletion",
+			headRepo:         "test-owner/test-repo",
+			missing:          true,
+			expectedDeletion: "already deleted",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /repos/test-owner/test-repo/branches/feature", func(w http.ResponseWriter, _ *http.Request) {
+				if tc.missing {
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"message": "Branch not found"}`))
+					return
+				}
+				writeJSON(t, w, &github.Branch{Name: github.Ptr("feature"), Protected: github.Ptr(tc.protected)})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+				if base := r.URL.Query().Get("base"); base != "feature" {
+					t.Errorf("Expected PRs to be listed by base feature, got %q", base)
+				}
+				var prs []*github.PullRequest
+				if tc.stacked {
+					prs = append(prs, &github.PullRequest{Number: github.Ptr(2)})
+				}
+				writeJSON(t, w, prs)
+			})
+			mux.HandleFunc("DELETE /repos/test-owner/test-repo/git/refs/heads/feature", func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.deleteStatus)
+				if tc.deleteStatus != http.StatusNoContent {
+					_, _ = w.Write([]byte(`{"message": "Reference does not exist"}`))
+				}
+			})
+			transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg:    &config{owner: testOwner, repo: testRepo},
+				ctx:    context.Background(),
+			}
+			pr := &github.PullRequest{
+				Number: github.Ptr(1),
+				Head: &github.PullRequestBranch{
+					Ref:  github.Ptr("feature"),
+					Repo: &github.Repository{FullName: github.Ptr(tc.headRepo)},
+				},
+				Base: &github.PullRequestBranch{
+					Ref:  github.Ptr("main"),
+					Repo: &github.Repository{FullName: github.Ptr("test-owner/test-repo")},
+				},
+			}
+			processor.deleteHeadBranch(pr)
+
+			r := processor.report(pr)
+			if r.branchDeletion != tc.expectedDeletion {
+				t.Errorf("Expected %q, got %q", tc.expectedDeletion, r.branchDeletion)
+			}
+			if got := len(transport.find("DELETE", "/repos/test-owner/test-repo/git/refs/heads/feature")) > 0; got != tc.expectDelete {
+				t.Errorf("Expected delete %v, got %v", tc.expectDelete, got)
+			}
+			if r.branchDeleted != (tc.expectedDeletion == "deleted feature") {
+				t.Errorf("Expected branchDeleted only when this run deleted the branch, got %v", r.branchDeleted)
+			}
+		})
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	processor := &PRProcessor{cfg: &config{owner: testOwner, repo: testRepo}}
+	merged := &github.PullRequest{Number: github.Ptr(1), Title: github.Ptr("Add feature"), Head: &github.PullRequestBranch{Ref: github.Ptr("feature")}}
+	r := processor.report(merged)
+	r.decide(outcomeMerged, "all status checks passed")
+	r.branchDeleted, r.branchDeletion = true, "deleted feature"
+	processor.report(&github.PullRequest{Number: github.Ptr(2), Title: github.Ptr("WIP")}).skip(skipTitlePattern, "title matches skip pattern", true)
+
+	var out bytes.Buffer
+	processor.printSummary(&out)
+	for _, expected := range []string{
+		"Summary: 2 PRs (1 merged, 1 skipped)",
+		"#1 Add feature: merged (all status checks passed), branch deleted feature",
+		"#2 WIP: skipped (title matches skip pattern)",
+		"Deleted branches: feature",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Dependency update bots
+const (
+	botRenovate   = "renovate"
+	botDependabot = "dependabot"
+)
+
+// Semantic version update types
+const (
+	updateMajor   = "major"
+	updateMinor   = "minor"
+	updatePatch   = "patch"
+	updateUnknown = "unknown" // Versions could not be compared
+)
+
+// dependencyUpdateTypes lists the update types in the order their rules are
+// evaluated
+var dependencyUpdateTypes = []string{updateMajor, updateMinor, updatePatch, updateUnknown}
+
+// dependencyUpdate describes the update proposed by a Renovate or Dependabot PR
+type dependencyUpdate struct {
+	bot        string
+	pkg        string
+	from       string // Empty if the PR doesn't say
+	to         string
+	updateType string
+}
+
+func (d *dependencyUpdate) String() string {
+	return fmt.Sprintf("%s %s (%s)", d.pkg, d.versions(), d.updateType)
+}
+
+// versions describes the version change, e.g. "from 1.2.3 to 1.3.0"
+func (d *dependencyUpdate) versions() string {
+	if d.from == "" {
+		return "to " + d.to
+	}
+	return "from " + d.from + " to " + d.to
+}
+
+var (
+	// Bump github.com/foo/bar from 1.2.3 to 1.3.0 [in /dir], optionally
+	// prefixed with a conventional commit type such as "chore(deps): "
+	dependabotTitlePattern = regexp.MustCompile(`(?i)^(?:[\w()!-]+:\s*)?bump\s+(\S+)\s+from\s+(\S+)\s+to\s+(\S+)`)
+	// Update module github.com/foo/bar to v1.3.0, Update Rust crate foo to v2,
+	// Update actions/checkout action to v4, Update golang Docker tag to v1.24
+	renovateTitlePattern = regexp.MustCompile(`(?i)^(?:[\w()!-]+:\s*)?update\s+(?:[\w ]*?(?:module|dependency|package|crate|image|chart|plugin)\s+)?(\S+)(?:\s+(?:docker tag|action|digest))?\s+to\s+(\S+)`)
+	// Renovate lists changes in a table like | foo | `1.2.3` -> `1.3.0` |
+	renovateChangePattern = regexp.MustCompile("`([^`]+)`\\s*(?:->|→)\\s*`([^`]+)`")
+	// Dependabot bodies start with Bumps [foo](https://...) from 1.2.3 to 1.3.0.
+	dependabotBodyPattern = regexp.MustCompile(`Bumps \[([^\]]+)\]\([^)]*\) from (\S+) to (\S+?)\.?(?:\s|$)`)
+	// Dependabot metadata, as read by dependabot/fetch-metadata, when the
+	// commit message is copied into the body
+	dependabotUpdateTypePattern = regexp.MustCompile(`update-type:\s*version-update:semver-(major|minor|patch)`)
+)
+
+// dependencyBot returns which dependency update bot authored the PR, if any.
+// Only the GitHub App accounts count, since anyone can sign up as a user
+// named like a bot.
+func dependencyBot(pr *github.PullRequest) string {
+	if pr.GetUser().GetType() != "Bot" {
+		return ""
+	}
+	switch pr.GetUser().GetLogin() {
+	case "renovate[bot]":
+		return botRenovate
+	case "dependabot[bot]":
+		return botDependabot
+	default:
+		return ""
+	}
+}
+
+// parseDependencyUpdate extracts the package and versions from the title and
+// body of a Renovate or Dependabot PR. It returns nil for other PRs and for
+// grouped updates of several packages.
+func parseDependencyUpdate(pr *github.PullRequest) *dependencyUpdate {
+	bot := dependencyBot(pr)
+	if bot == "" {
+		return nil
+	}
+
+	d := &dependencyUpdate{bot: bot}
+	if m := dependabotTitlePattern.FindStringSubmatch(pr.GetTitle()); m != nil {
+		d.pkg, d.from, d.to = m[1], m[2], m[3]
+	} else if m := dependabotBodyPattern.FindStringSubmatch(pr.GetBody()); m != nil {
+		// Custom commit message prefixes can make the title unrecognizable
+		d.pkg, d.from, d.to = m[1], m[2], m[3]
+	} else if m := renovateTitlePattern.FindStringSubmatch(pr.GetTitle()); m != nil {
+		d.pkg, d.to = m[1], m[2]
+		if c := renovateChangePattern.FindStringSubmatch(pr.GetBody()); c != nil {
+			d.from = c[1]
+		}
+	} else {
+		return nil
+	}
+	d.updateType = semverUpdateType(d.from, d.to)
+	if m := dependabotUpdateTypePattern.FindStringSubmatch(pr.GetBody()); m != nil {
+		d.updateType = m[1]
+	}
+	return d
+}
+
+// parseVersion returns the numeric major, minor and patch components of a
+// version such as v1.2.3, 1.2 or 1.2.3-rc.1. Missing components are -1.
+func parseVersion(version string) ([3]int, bool) {
+	parts := [3]int{-1, -1, -1}
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	for i, field := range strings.SplitN(version, ".", 3) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// semverUpdateType classifies an update by the most significant version
+// component that changed
+func semverUpdateType(from, to string) string {
+	toParts, ok := parseVersion(to)
+	if !ok {
+		return updateUnknown
+	}
+	if from == "" {
+		// Renovate titles major updates with the new major version only
+		if toParts[1] == -1 {
+			return updateMajor
+		}
+		return updateUnknown
+	}
+	fromParts, ok := parseVersion(from)
+	if !ok {
+		return updateUnknown
+	}
+	switch {
+	case fromParts[0] != toParts[0]:
+		return updateMajor
+	case fromParts[1] != toParts[1]:
+		return updateMinor
+	default:
+		return updatePatch
+	}
+}
+
+// parseDependencyPolicy parses a comma separated list of update type=action
+// pairs, e.g. "patch=merge,minor=merge,major=approve"
+func parseDependencyPolicy(spec string) (map[string]policyAction, error) {
+	actions := make(map[string]policyAction)
+	if spec == "" {
+		return actions, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		updateType, action, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q: expected type=action", entry)
+		}
+		valid := false
+		for _, t := range dependencyUpdateTypes {
+			valid = valid || t == updateType
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid update type %q: must be one of %s", updateType, strings.Join(dependencyUpdateTypes, ", "))
+		}
+		a, err := parsePolicyAction(action)
+		if err != nil {
+			return nil, err
+		}
+		actions[updateType] = a
+	}
+	return actions, nil
+}
+
+// dependencyRules expresses the dependency policy as policy rules
+func (p *PRProcessor) dependencyRules() []*policyRule {
+	var rules []*policyRule
+	for _, updateType := range dependencyUpdateTypes {
+		action, ok := p.cfg.dependencyPolicy[updateType]
+		if !ok {
+			continue
+		}
+		rule := &policyRule{
+			name:    "dependency-" + updateType,
+			action:  action,
+			reason:  updateType + " dependency update",
+			inScope: true,
+			inputs:  []string{"dependency_update_type"},
+			match: func(f *prFacts) (bool, error) {
+				return f.dependency != nil && f.dependency.updateType == updateType, nil
+			},
+		}
+		rule.skipCode = "policy:" + rule.name
+		rules = append(rules, rule)
+	}
+	return rules
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestParseDependencyUpdate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		author   string
+		userType string
+		title    string
+		body     string
+		expected *dependencyUpdate
+	}{
+		{
+			name:     "dependabot title",
+			author:   "dependabot[bot]",
+			userType: "Bot",
+			title:    "Bump golang.org/x/net from 0.33.0 to 0.34.0",
+			expected: &dependencyUpdate{bot: botDependabot, pkg: "golang.org/x/net", from: "0.33.0", to: "0.34.0", updateType: updateMinor},
+		},
+		{
+			name:     "dependabot title with prefix and directory",
+			author:   "dependabot[bot]",
+			userType: "Bot",
+			title:    "chore(deps): bump github.com/foo/bar from 1.2.3 to 1.2.4 in /tools",
+			expected: &dependencyUpdate{bot: botDependabot, pkg: "github.com/foo/bar", from: "1.2.3", to: "1.2.4", updateType: updatePatch},
+		},
+		{
+			name:     "dependabot body",
+			author:   "dependabot[bot]",
+			userType: "Bot",
+			title:    "deps: upgrade foo",
+			body:     "Bumps [foo](https://github.com/foo/foo) from 1.9.0 to 2.0.0.\n\nRelease notes...",
+			expected: &dependencyUpdate{bot: botDependabot, pkg: "foo", from: "1.9.0", to: "2.0.0", updateType: updateMajor},
+		},
+		{
+			name:     "dependabot metadata overrides computed type",
+			author:   "dependabot[bot]",
+			userType: "Bot",
+			title:    "Bump foo from 1.2.3 to 1.2.4",
+			body:     "updated-dependencies:\n- dependency-name: foo\n  update-type: version-update:semver-minor\n",
+			expected: &dependencyUpdate{bot: botDependabot, pkg: "foo", from: "1.2.3", to: "1.2.4", updateType: updateMinor},
+		},
+		{
+			name:     "renovate with versions in body",
+			author:   "renovate[bot]",
+			userType: "Bot",
+			title:    "fix(deps): update module github.com/google/go-github/v71 to v71.1.0",
+			body:     "| Package | Change |\n|---|---|\n| [github.com/google/go-github/v71](https://github.com/google/go-github) | `v71.0.0` -> `v71.1.0` |",
+			expected: &dependencyUpdate{bot: botRenovate, pkg: "github.com/google/go-github/v71", from: "v71.0.0", to: "v71.1.0", updateType: updateMinor},
+		},
+		{
+			name:     "renovate major without body",
+			author:   "renovate[bot]",
+			userType: "Bot",
+			title:    "Update actions/checkout action to v5",
+			expected: &dependencyUpdate{bot: botRenovate, pkg: "actions/checkout", to: "v5", updateType: updateMajor},
+		},
+		{
+			name:     "renovate digest",
+			author:   "renovate[bot]",
+			userType: "Bot",
+			title:    "Update golang.org/x/tools digest to 1a2b3c4",
+			expected: &dependencyUpdate{bot: botRenovate, pkg: "golang.org/x/tools", to: "1a2b3c4", updateType: updateUnknown},
+		},
+		{
+			name:     "human PR",
+			author:   "octocat",
+			userType: "User",
+			title:    "Bump foo from 1.2.3 to 1.2.4",
+		},
+		{
+			name:     "user named like a bot",
+			author:   "renovate-bot",
+			userType: "User",
+			title:    "Update module github.com/foo/bar to v2.0.0",
+		},
+		{
+			name:     "other bot",
+			author:   "dependabot-preview[bot]",
+			userType: "Bot",
+			title:    "Bump foo from 1.2.3 to 1.2.4",
+		},
+		{
+			name:     "grouped update",
+			author:   "dependabot[bot]",
+			userType: "Bot",
+			title:    "Bump the go group with 3 updates",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseDependencyUpdate(&github.PullRequest{
+				Title: github.Ptr(tc.title),
+				Body:  github.Ptr(tc.body),
+				User:  &github.User{Login: github.Ptr(tc.author), Type: github.Ptr(tc.userType)},
+			})
+			if tc.expected == nil {
+				if got != nil {
+					t.Errorf("Expected no dependency update, got %+v", got)
+				}
+				return
+			}
+			if got == nil || *got != *tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseDependencyPolicy_Errors(t *testing.T) {
+	for _, spec := range []string{"patch", "huge=merge", "patch=close"} {
+		if _, err := parseDependencyPolicy(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestProcessPullRequests_DependencyPolicy(t *testing.T) {
+	actions, err := parseDependencyPolicy("patch=merge,minor=merge,major=approve")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	testCases := []struct {
+		name            string
+		title           string
+		expectedOutcome prOutcome
+		expectMerge     bool
+	}{
+		{
+			name:            "minor update is merged",
+			title:           "Update module github.com/foo/bar to v1.3.0",
+			expectedOutcome: outcomeMerged,
+			expectMerge:     true,
+		},
+		{
+			name:            "major update is only approved",
+			title:           "Update module github.com/foo/bar to v2",
+			expectedOutcome: outcomeApproved,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := newPolicyTestServer(t, tc.title, []string{"go.mod"})
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg:    &config{owner: testOwner, repo: testRepo, dependencyPolicy: actions},
+				ctx:    context.Background(),
+			}
+			if err := processor.ProcessPullRequests(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			r := processor.sortedReports()[0]
+			if r.outcome != tc.expectedOutcome || r.dependency == nil {
+				t.Errorf("Expected %s for a dependency update, got %s (%s), dependency %v", tc.expectedOutcome, r.outcome, r.reason, r.dependency)
+			}
+			if got := len(transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge")) > 0; got != tc.expectMerge {
+				t.Errorf("Expected merge %v, got %v", tc.expectMerge, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// dryRun reports whether changes must not be made because -dry-run is set,
+// printing the action that would have been taken on the PR
+func (p *PRProcessor) dryRun(pr *github.PullRequest, format string, args ...interface{}) bool {
+	if !p.cfg.dryRun {
+		return false
+	}
+	fmt.Printf("PR #%d: Dry run, would %s\n", pr.GetNumber(), fmt.Sprintf(format, args...))
+	return true
+}
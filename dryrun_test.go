@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestProcessPullRequests_DryRun(t *testing.T) {
+	transport := newPolicyTestServer(t, "Add feature", []string{"main.go"})
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg:    &config{owner: testOwner, repo: testRepo, botLogin: commentAuthor.GetLogin(), approve: true, statusComment: true, dryRun: true},
+		ctx:    context.Background(),
+	}
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if r := processor.sortedReports()[0]; r.outcome != outcomeMerged || !strings.Contains(r.reason, "dry run") {
+		t.Errorf("Expected a dry run merge, got %s (%s)", r.outcome, r.reason)
+	}
+	for _, req := range transport.requests {
+		if req.method != http.MethodGet {
+			t.Errorf("Expected no changes in a dry run, got %s %s", req.method, req.path)
+		}
+	}
+}
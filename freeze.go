@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Layouts accepted for the from and to dates of freeze windows
+const (
+	freezeDateLayout     = "2006-01-02"
+	freezeDateTimeLayout = "2006-01-02T15:04"
+)
+
+var freezeWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// freezeWindow is a period in which merges are downgraded. A window is active
+// when all of its date range, weekdays and time of day match.
+type freezeWindow struct {
+	name   string
+	action policyAction // policySkip or policyApprove
+	loc    *time.Location
+
+	from time.Time // Zero if unbounded
+	to   time.Time // Exclusive, zero if unbounded
+
+	days map[time.Weekday]bool // Empty for every day
+
+	// Minutes after midnight; the range wraps over midnight if start >= end,
+	// in which case days refers to the day the window starts on
+	hasTime bool
+	start   int
+	end     int
+}
+
+// active reports whether the window covers now
+func (w *freezeWindow) active(now time.Time) bool {
+	t := now.In(w.loc)
+	if (!w.from.IsZero() && t.Before(w.from)) || (!w.to.IsZero() && !t.Before(w.to)) {
+		return false
+	}
+	if !w.hasTime {
+		return w.onDay(t.Weekday())
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end && w.onDay(t.Weekday())
+	}
+	if minute >= w.start {
+		return w.onDay(t.Weekday())
+	}
+	return minute < w.end && w.onDay((t.Weekday()+6)%7)
+}
+
+func (w *freezeWindow) onDay(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// String describes the window and what it does to PRs
+func (w *freezeWindow) String() string {
+	effect := "skipping all PRs"
+	if w.action == policyApprove {
+		effect = "approving without merging"
+	}
+	if !w.to.IsZero() && !w.hasTime && len(w.days) == 0 {
+		return fmt.Sprintf("%s until %s, %s", w.name, w.to.Format("2006-01-02 15:04 MST"), effect)
+	}
+	return w.name + ", " + effect
+}
+
+// freezeSchedule is the list of freeze windows loaded from the freeze file
+type freezeSchedule struct {
+	windows []*freezeWindow
+}
+
+// active returns the window in effect at now, preferring windows skipping PRs
+// over approve-only ones, or nil if merging is allowed
+func (s *freezeSchedule) active(now time.Time) *freezeWindow {
+	if s == nil {
+		return nil
+	}
+	var approveOnly *freezeWindow
+	for _, w := range s.windows {
+		if !w.active(now) {
+			continue
+		}
+		if w.action == policySkip {
+			return w
+		}
+		if approveOnly == nil {
+			approveOnly = w
+		}
+	}
+	return approveOnly
+}
+
+// freezeFile is the JSON freeze configuration file
+type freezeFile struct {
+	// TimeZone is the IANA time zone of the windows (default: UTC)
+	TimeZone string               `json:"timezone"`
+	Windows  []freezeWindowConfig `json:"windows"`
+	// Calendar is an ICS file whose events are freeze windows, relative to
+	// the freeze file
+	Calendar       string `json:"calendar"`
+	CalendarAction string `json:"calendar_action"` // Default: skip
+}
+
+type freezeWindowConfig struct {
+	Name     string   `json:"name"`
+	Action   string   `json:"action"`   // "skip" (default) or "approve"
+	TimeZone string   `json:"timezone"` // Overrides the file's time zone
+	Days     []string `json:"days"`     // "mon" to "sun"
+	Start    string   `json:"start"`    // "HH:MM"
+	End      string   `json:"end"`      // "HH:MM", "24:00" for midnight
+	From     string   `json:"from"`     // "2006-01-02" or "2006-01-02T15:04"
+	To       string   `json:"to"`       // Inclusive for dates, exclusive for times
+}
+
+func parseFreezeAction(action string) (policyAction, error) {
+	switch a := policyAction(action); a {
+	case "":
+		return policySkip, nil
+	case policySkip, policyApprove:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown action %q: must be %q or %q", action, policySkip, policyApprove)
+	}
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes after midnight
+func parseTimeOfDay(value string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q: must be HH:MM", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// parseFreezeDate parses a date or date and time in loc. Dates used as the
+// end of a window include the whole day.
+func parseFreezeDate(value string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(freezeDateTimeLayout, value, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(freezeDateLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: must be %s or %s", value, freezeDateLayout, freezeDateTimeLayout)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// loadFreezeSchedule reads the freeze file and the calendar it refers to
+func loadFreezeSchedule(path string) (*freezeSchedule, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is supplied by the user on purpose
+	if err != nil {
+		return nil, fmt.Errorf("failed to read freeze file: %v", err)
+	}
+	var file freezeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse freeze file: %v", err)
+	}
+	if file.Calendar != "" && !filepath.IsAbs(file.Calendar) {
+		file.Calendar = filepath.Join(filepath.Dir(path), file.Calendar)
+	}
+	return newFreezeSchedule(&file)
+}
+
+func newFreezeSchedule(file *freezeFile) (*freezeSchedule, error) {
+	loc, err := time.LoadLocation(file.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid freeze time zone: %v", err)
+	}
+
+	schedule := &freezeSchedule{}
+	for i, wc := range file.Windows {
+		name := wc.Name
+		if name == "" {
+			name = fmt.Sprintf("window %d", i+1)
+		}
+		w := &freezeWindow{name: name, loc: loc}
+		if wc.TimeZone != "" {
+			if w.loc, err = time.LoadLocation(wc.TimeZone); err != nil {
+				return nil, fmt.Errorf("%s: invalid time zone: %v", name, err)
+			}
+		}
+		if w.action, err = parseFreezeAction(wc.Action); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		for _, day := range wc.Days {
+			weekday, ok := freezeWeekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown day %q: must be one of mon, tue, wed, thu, fri, sat, sun", name, day)
+			}
+			if w.days == nil {
+				w.days = make(map[time.Weekday]bool)
+			}
+			w.days[weekday] = true
+		}
+
+		if wc.Start != "" || wc.End != "" {
+			w.hasTime = true
+			if w.start, err = parseTimeOfDay(defaultString(wc.Start, "00:00")); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			if w.end, err = parseTimeOfDay(defaultString(wc.End, "24:00")); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+
+		if wc.From != "" {
+			if w.from, err = parseFreezeDate(wc.From, w.loc, false); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if wc.To != "" {
+			if w.to, err = parseFreezeDate(wc.To, w.loc, true); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if !w.from.IsZero() && !w.to.IsZero() && !w.from.Before(w.to) {
+			return nil, fmt.Errorf("%s: from must be before to", name)
+		}
+		if len(w.days) == 0 && !w.hasTime && w.from.IsZero() && w.to.IsZero() {
+			return nil, fmt.Errorf("%s: window needs days, start/end or from/to", name)
+		}
+		schedule.windows = append(schedule.windows, w)
+	}
+
+	if file.Calendar != "" {
+		action, err := parseFreezeAction(file.CalendarAction)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar action: %v", err)
+		}
+		data, err := os.ReadFile(file.Calendar) // #nosec G304 -- path is supplied by the user on purpose
+		if err != nil {
+			return nil, fmt.Errorf("failed to read freeze calendar: %v", err)
+		}
+		events, err := parseICSEvents(data, loc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse freeze calendar: %v", err)
+		}
+		for _, w := range events {
+			w.action = action
+			schedule.windows = append(schedule.windows, w)
+		}
+	}
+	return schedule, nil
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// parseICSEvents returns the VEVENTs of an iCalendar file as freeze windows.
+// Floating times are read in loc. Recurrence rules are not expanded, so only
+// the first occurrence of a recurring event counts.
+func parseICSEvents(data []byte, loc *time.Location) ([]*freezeWindow, error) {
+	var windows []*freezeWindow
+	var event *freezeWindow
+	var endSet, allDay bool
+
+	for i, line := range unfoldICSLines(data) {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &freezeWindow{name: "calendar event", loc: loc}
+			endSet, allDay = false, false
+		case event == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if event.from.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no DTSTART", i+1, event.name)
+			}
+			if !endSet {
+				// Events without an end last a day if they are all-day and
+				// are instants otherwise
+				event.to = event.from
+				if allDay {
+					event.to = event.from.AddDate(0, 0, 1)
+				}
+			}
+			windows = append(windows, event)
+			event = nil
+		case name == "SUMMARY":
+			event.name = unescapeICSText(value)
+		case name == "DTSTART" || name == "DTEND":
+			t, date, err := parseICSTime(value, params, loc)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			if name == "DTSTART" {
+				event.from, allDay = t, date
+			} else {
+				event.to, endSet = t, true
+			}
+		}
+	}
+	if event != nil {
+		return nil, fmt.Errorf("unterminated VEVENT")
+	}
+	return windows, nil
+}
+
+// unfoldICSLines splits the file into logical lines, joining continuation
+// lines starting with a space or tab
+func unfoldICSLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitICSLine splits "NAME;PARAM=VALUE:value" into its parts
+func splitICSLine(line string) (string, map[string]string, string) {
+	head, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(head, ";")
+	params := make(map[string]string)
+	for _, param := range parts[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// parseICSTime parses a DATE or DATE-TIME value and reports whether it was a
+// date
+func parseICSTime(value string, params map[string]string, loc *time.Location) (time.Time, bool, error) {
+	if tzid := params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown TZID %q", tzid)
+		}
+	}
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date-time %q", value)
+		}
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date-time %q", value)
+	}
+	return t, false, nil
+}
+
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(value)
+}
+
+// freezeDecision downgrades a policy decision according to the active freeze
+// window: skip windows skip every PR and approve-only windows turn merges
+// into approvals. Decisions already skipping or approving are kept.
+func (p *PRProcessor) freezeDecision(decision *policyDecision) *policyDecision {
+	return freezeWindowDecision(p.activeFreeze, decision)
+}
+
+func freezeWindowDecision(w *freezeWindow, decision *policyDecision) *policyDecision {
+	if w == nil || decision.action == policySkip || (decision.action == policyApprove && w.action == policyApprove) {
+		return decision
+	}
+	return &policyDecision{
+		rule:     "freeze:" + w.name,
+		action:   w.action,
+		reason:   "merge freeze " + w.name,
+		skipCode: skipFreeze,
+		inScope:  true,
+	}
+}
+
+// freezeAtMerge re-checks the freeze schedule right before merging the PR,
+// since a window may have started after the run did, e.g. while waiting for
+// checks, in the merge queue or while bisecting a merge train. It returns
+// the downgraded decision, or nil if the PR may still be merged.
+func (p *PRProcessor) freezeAtMerge(pr *github.PullRequest) *policyDecision {
+	return p.applyFreezeAtMerge(pr, p.freeze.active(time.Now()))
+}
+
+// applyFreezeAtMerge downgrades the decision on the PR according to the
+// window in effect at merge time and records the downgrade
+func (p *PRProcessor) applyFreezeAtMerge(pr *github.PullRequest, w *freezeWindow) *policyDecision {
+	r := p.report(pr)
+	decision := &policyDecision{rule: r.policyRule, action: r.policyAction}
+	if decision.action == "" {
+		decision.action = policyMerge
+	}
+	frozen := freezeWindowDecision(w, decision)
+	if frozen == decision {
+		return nil
+	}
+	fmt.Printf("PR #%d: Merge freeze %s started, not merging\n", pr.GetNumber(), w.name)
+	r.record(stageMerge, frozen.rule, string(decision.action)+" downgraded to "+string(frozen.action),
+		map[string]string{"window": w.String()})
+	r.policyRule, r.policyAction = frozen.rule, frozen.action
+	return frozen
+}
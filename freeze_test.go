@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestFreezeScheduleActive(t *testing.T) {
+	schedule, err := newFreezeSchedule(&freezeFile{
+		TimeZone: "Europe/Berlin",
+		Windows: []freezeWindowConfig{
+			{Name: "Friday evening", Action: "approve", Days: []string{"fri"}, Start: "16:00"},
+			{Name: "Night", Action: "approve", Days: []string{"mon"}, Start: "22:00", End: "06:00"},
+			{Name: "Weekend", Days: []string{"sat", "sun"}},
+			{Name: "Release freeze", From: "2026-12-21", To: "2026-12-23"},
+			{Name: "Friday release", Action: "skip", From: "2026-10-16T17:00", To: "2026-10-16T18:00"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	testCases := []struct {
+		time     string // In Berlin
+		expected string // Name of the active window, empty for none
+	}{
+		{"2026-10-14 12:00", ""},
+		{"2026-10-16 15:59", ""},
+		{"2026-10-16 16:00", "Friday evening"},
+		{"2026-10-16 17:30", "Friday release"}, // Skip windows win over approve-only ones
+		{"2026-10-16 23:59", "Friday evening"},
+		{"2026-10-17 10:00", "Weekend"},
+		{"2026-10-19 23:00", "Night"},
+		{"2026-10-20 05:59", "Night"}, // The night started on Monday
+		{"2026-10-20 06:00", ""},
+		{"2026-10-20 23:00", ""},
+		{"2026-12-21 00:00", "Release freeze"},
+		{"2026-12-23 23:59", "Release freeze"}, // Dates include the whole day
+		{"2026-12-24 00:00", ""},
+	}
+	for _, tc := range testCases {
+		now, err := time.ParseInLocation("2006-01-02 15:04", tc.time, berlin)
+		if err != nil {
+			t.Fatalf("Invalid test time %q: %v", tc.time, err)
+		}
+		var name string
+		if w := schedule.active(now.UTC()); w != nil {
+			name = w.name
+		}
+		if name != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.time, tc.expected, name)
+		}
+	}
+}
+
+func TestNewFreezeScheduleErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		file   freezeFile
+		errMsg string
+	}{
+		{"unknown time zone", freezeFile{TimeZone: "Mars/Olympus"}, "invalid freeze time zone"},
+		{"unknown day", freezeFile{Windows: []freezeWindowConfig{{Days: []string{"someday"}}}}, "unknown day"},
+		{"invalid time", freezeFile{Windows: []freezeWindowConfig{{Start: "25:00"}}}, "invalid time of day"},
+		{"invalid date", freezeFile{Windows: []freezeWindowConfig{{From: "next week"}}}, "invalid date"},
+		{"reversed dates", freezeFile{Windows: []freezeWindowConfig{{From: "2026-12-24", To: "2026-12-20"}}}, "from must be before to"},
+		{"merge action", freezeFile{Windows: []freezeWindowConfig{{Days: []string{"fri"}, Action: "merge"}}}, "unknown action"},
+		{"empty window", freezeFile{Windows: []freezeWindowConfig{{Name: "always"}}}, "always: window needs"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newFreezeSchedule(&tc.file)
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestLoadFreezeScheduleCalendar(t *testing.T) {
+	dir := t.TempDir()
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"SUMMARY:Christmas",
+		"DTSTART;VALUE=DATE:20261224",
+		"DTEND;VALUE=DATE:20261227",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Release 2.0\\, final",
+		"  checks",
+		"DTSTART;TZID=America/New_York:20261103T090000",
+		"DTEND;TZID=America/New_York:20261103T120000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Day off",
+		"DTSTART:20261111",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	if err := os.WriteFile(filepath.Join(dir, "holidays.ics"), []byte(calendar), 0o600); err != nil {
+		t.Fatalf("Failed to write calendar: %v", err)
+	}
+	path := filepath.Join(dir, "freeze.json")
+	if err := os.WriteFile(path, []byte(`{"timezone": "UTC", "calendar": "holidays.ics", "calendar_action": "approve"}`), 0o600); err != nil {
+		t.Fatalf("Failed to write freeze file: %v", err)
+	}
+
+	schedule, err := loadFreezeSchedule(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	testCases := []struct {
+		time     string // RFC 3339
+		expected string
+	}{
+		{"2026-12-23T23:59:00Z", ""},
+		{"2026-12-24T00:00:00Z", "Christmas"},
+		{"2026-12-26T23:59:00Z", "Christmas"},
+		{"2026-12-27T00:00:00Z", ""},
+		{"2026-11-03T13:59:00Z", ""},
+		{"2026-11-03T14:00:00Z", "Release 2.0, final checks"},
+		{"2026-11-11T12:00:00Z", "Day off"},
+	}
+	for _, tc := range testCases {
+		now, _ := time.Parse(time.RFC3339, tc.time)
+		w := schedule.active(now)
+		var name string
+		if w != nil {
+			name = w.name
+			if w.action != policyApprove {
+				t.Errorf("%s: expected calendar action approve, got %s", tc.time, w.action)
+			}
+		}
+		if name != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.time, tc.expected, name)
+		}
+	}
+}
+
+func TestFreezeDecision(t *testing.T) {
+	skip := &freezeWindow{name: "holidays", action: policySkip}
+	approveOnly := &freezeWindow{name: "friday", action: policyApprove}
+	testCases := []struct {
+		name           string
+		freeze         *freezeWindow
+		action         policyAction
+		expectedAction policyAction
+		expectedRule   string
+	}{
+		{"no freeze", nil, policyMerge, policyMerge, "default"},
+		{"approve-only freeze downgrades merges", approveOnly, policyMerge, policyApprove, "freeze:friday"},
+		{"approve-only freeze keeps approvals", approveOnly, policyApprove, policyApprove, "default"},
+		{"skip freeze skips approvals", skip, policyApprove, policySkip, "freeze:holidays"},
+		{"skips are kept", skip, policySkip, policySkip, "default"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &PRProcessor{activeFreeze: tc.freeze}
+			decision := p.freezeDecision(&policyDecision{rule: "default", action: tc.action})
+			if decision.action != tc.expectedAction || decision.rule != tc.expectedRule {
+				t.Errorf("Expected %s by %s, got %s by %s", tc.expectedAction, tc.expectedRule, decision.action, decision.rule)
+			}
+			if decision.action == policySkip && tc.action != policySkip && decision.skipCode != skipFreeze {
+				t.Errorf("Expected skip code %q, got %q", skipFreeze, decision.skipCode)
+			}
+		})
+	}
+}
+
+func TestHandleSuccessfulPR_FreezeStartedDuringRun(t *testing.T) {
+	testCases := []struct {
+		name            string
+		action          policyAction
+		expectedOutcome prOutcome
+		expectedReviews int
+	}{
+		{"skip freeze", policySkip, outcomeSkipped, 0},
+		{"approve-only freeze", policyApprove, outcomeApproved, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := newPolicyTestServer(t, "Bump lib", nil)
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg:    &config{owner: testOwner, repo: testRepo},
+				ctx:    context.Background(),
+				// Started after the run did, so activeFreeze is not set
+				freeze: &freezeSchedule{windows: []*freezeWindow{{name: "incident", action: tc.action, loc: time.UTC}}},
+			}
+			prs, _, err := processor.client.PullRequests.List(processor.ctx, testOwner, testRepo, nil)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if err := processor.processSinglePR(prs[0]); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			r := processor.report(prs[0])
+			if r.outcome != tc.expectedOutcome || r.policyRule != "freeze:incident" {
+				t.Errorf("Expected %s by the freeze, got %s by %s (%s)", tc.expectedOutcome, r.outcome, r.policyRule, r.reason)
+			}
+			if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge"); len(calls) != 0 {
+				t.Errorf("Expected no merge during the freeze, got %d", len(calls))
+			}
+			if calls := transport.find("POST", "/repos/test-owner/test-repo/pulls/1/reviews"); len(calls) != tc.expectedReviews {
+				t.Errorf("Expected %d reviews, got %d", tc.expectedReviews, len(calls))
+			}
+		})
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Supported lock backends
+const (
+	lockBackendFile   = "file"   // Lock files in a directory, for instances sharing a file system
+	lockBackendStatus = "status" // Commit status on the PR head, visible on GitHub
+	lockBackendRedis  = "redis"  // Keys in Redis or a server speaking its protocol
+)
+
+const (
+	defaultLockTTL    = 30 * time.Minute
+	lockStatusContext = "pr-status-checker/lock"
+	lockRedisPrefix   = "pr-status-checker:lock:"
+	lockRedisTimeout  = 5 * time.Second
+	lockFileGrace     = time.Minute
+)
+
+// locker hands out leases on PRs so that only one instance acts on a PR at a
+// time. Leases expire after their TTL so that a crashed instance does not
+// block a PR forever.
+type locker interface {
+	// Acquire takes the lock of the PR for owner, or extends it if owner
+	// already holds it. If another owner holds an unexpired lease it returns
+	// false and a description of the holder.
+	Acquire(ctx context.Context, pr *github.PullRequest, owner string, ttl time.Duration) (bool, string, error)
+	// Release gives up the lock of the PR if owner still holds it
+	Release(ctx context.Context, pr *github.PullRequest, owner string) error
+}
+
+// openLocker returns the locker of the configured backend
+func openLocker(cfg *config, client *github.Client) (locker, error) {
+	repo := cfg.owner + "/" + cfg.repo
+	switch cfg.lockBackend {
+	case lockBackendFile:
+		if err := os.MkdirAll(cfg.lockAddress, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create lock directory: %v", err)
+		}
+		return &fileLocker{dir: cfg.lockAddress, repo: repo}, nil
+	case lockBackendStatus:
+		return &statusLocker{client: client, owner: cfg.owner, repo: cfg.repo}, nil
+	case lockBackendRedis:
+		return newRedisLocker(cfg.lockAddress, repo)
+	default:
+		return nil, fmt.Errorf("invalid lock backend %q: must be %q, %q or %q", cfg.lockBackend, lockBackendFile, lockBackendStatus, lockBackendRedis)
+	}
+}
+
+// newLockOwner identifies this instance in locks: host, process and a random
+// suffix telling apart runs that reuse a PID
+func newLockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func lockKey(repo string, number int) string {
+	return repo + "#" + strconv.Itoa(number)
+}
+
+// lockHolder describes the holder of a lease for log lines and reports
+func lockHolder(owner string, expires time.Time) string {
+	return fmt.Sprintf("%s until %s", owner, expires.UTC().Format(time.RFC3339))
+}
+
+// lockPR takes the lock of the PR before acting on it. It returns false and
+// skips the PR if another instance holds the lock. Without a lock backend or
+// in dry-run mode nothing is locked.
+func (p *PRProcessor) lockPR(pr *github.PullRequest) (bool, error) {
+	if p.locker == nil || p.cfg.dryRun {
+		return true, nil
+	}
+	r := p.report(pr)
+	inputs := map[string]string{"backend": p.cfg.lockBackend, "owner": p.lockOwner, "ttl": traceValue(p.cfg.lockTTL)}
+	acquired, holder, err := p.locker.Acquire(p.ctx, pr, p.lockOwner, p.cfg.lockTTL)
+	if err != nil {
+		r.record(stageLock, "lock", "error: "+err.Error(), inputs)
+		return false, fmt.Errorf("error locking PR: %v", err)
+	}
+	if !acquired {
+		fmt.Printf("PR #%d: Locked by %s, skipping\n", pr.GetNumber(), holder)
+		r.record(stageLock, "lock", "held by "+holder, inputs)
+		r.skip(skipLocked, "locked by "+holder, false)
+		return false, nil
+	}
+	r.record(stageLock, "lock", "acquired", inputs)
+	r.locked = pr
+	return true, nil
+}
+
+// unlockPR releases the lock of the PR if this instance holds it
+func (p *PRProcessor) unlockPR(pr *github.PullRequest) {
+	r := p.report(pr)
+	if r.locked == nil {
+		return
+	}
+	locked := r.locked
+	r.locked = nil
+	if err := p.locker.Release(p.ctx, locked, p.lockOwner); err != nil {
+		log.Printf("Error releasing lock of PR #%d: %v", pr.GetNumber(), err)
+	}
+}
+
+// releaseLocks releases the locks still held at the end of a run, for
+// example of PRs whose processing failed
+func (p *PRProcessor) releaseLocks() {
+	if p.reports == nil {
+		return
+	}
+	for _, r := range p.sortedReports() {
+		if r.locked != nil {
+			p.unlockPR(r.locked)
+		}
+	}
+}
+
+// fileLock is the content of a lock file
+type fileLock struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// fileLocker creates one lock file per PR. Lock files are created
+// exclusively, so only one instance can take a free lock; expired ones are
+// moved aside before being replaced.
+type fileLocker struct {
+	dir  string
+	repo string
+}
+
+func (l *fileLocker) path(number int) string {
+	name := strings.NewReplacer("/", "_", "#", "_").Replace(lockKey(l.repo, number))
+	return filepath.Join(l.dir, name+".lock")
+}
+
+func (l *fileLocker) Acquire(_ context.Context, pr *github.PullRequest, owner string, ttl time.Duration) (bool, string, error) {
+	path := l.path(pr.GetNumber())
+	data, err := json.Marshal(fileLock{Owner: owner, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return false, "", err
+	}
+
+	// One retry after clearing an expired lock
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) // #nosec G304 -- lock directory is supplied by the user on purpose
+		if err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return false, "", fmt.Errorf("failed to write lock file: %v", err)
+			}
+			return true, "", nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return false, "", fmt.Errorf("failed to create lock file: %v", err)
+		}
+
+		current, err := readFileLock(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, "", err
+		}
+		if current.Owner == owner {
+			// Extend our own lease
+			if err := writeFileAtomic(path, data); err != nil {
+				return false, "", fmt.Errorf("failed to write lock file: %v", err)
+			}
+			return true, "", nil
+		}
+		if time.Now().Before(current.Expires) {
+			return false, lockHolder(current.Owner, current.Expires), nil
+		}
+		if err := l.clearExpired(path, current); err != nil {
+			return false, "", err
+		}
+	}
+	return false, "", fmt.Errorf("lock file %s keeps changing", path)
+}
+
+// clearExpired moves an expired lock file aside. If another instance replaced
+// it in the meantime, its fresh lock file is put back.
+func (l *fileLocker) clearExpired(path string, expired *fileLock) error {
+	aside := path + ".expired-" + strconv.Itoa(os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to clear expired lock file: %v", err)
+	}
+	moved, err := readFileLock(aside)
+	if err == nil && *moved != *expired {
+		// Only put it back if nobody took the lock since
+		if err := os.Link(aside, path); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to restore lock file: %v", err)
+		}
+	}
+	_ = os.Remove(aside)
+	return nil
+}
+
+func (l *fileLocker) Release(_ context.Context, pr *github.PullRequest, owner string) error {
+	path := l.path(pr.GetNumber())
+	current, err := readFileLock(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Owner != owner {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %v", err)
+	}
+	return nil
+}
+
+func readFileLock(path string) (*fileLock, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- lock directory is supplied by the user on purpose
+	if err != nil {
+		return nil, err
+	}
+	var lock fileLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		// A lock file being written is empty for a moment, so unreadable
+		// lock files count as held for a grace period
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return &fileLock{Owner: "unknown", Expires: info.ModTime().Add(lockFileGrace)}, nil
+	}
+	return &lock, nil
+}
+
+// writeFileAtomic replaces the file through a temporary file in the same
+// directory
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// statusLocker keeps the lock in a commit status on the PR head: pending
+// while held, with the holder and the end of the lease in the description,
+// and success once released. GitHub has no compare-and-swap for statuses,
+// so the status is read back after writing it and the last writer wins. This
+// is best-effort: instances writing at about the same time can both read
+// their own status back. Statuses cannot be deleted, so the success status
+// of a released lock stays on the commit.
+type statusLocker struct {
+	client *github.Client
+	owner  string
+	repo   string
+}
+
+// current returns the latest lock status of the PR head, nil if none
+func (l *statusLocker) current(ctx context.Context, pr *github.PullRequest) (*github.RepoStatus, error) {
+	statuses, _, err := l.client.Repositories.ListStatuses(ctx, l.owner, l.repo, pr.GetHead().GetSHA(), &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, fmt.Errorf("error getting lock status: %v", err)
+	}
+	// Statuses are listed newest first
+	for _, status := range statuses {
+		if status.GetContext() == lockStatusContext {
+			return status, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseLockStatus returns the holder and the end of the lease of a pending
+// lock status
+func parseLockStatus(status *github.RepoStatus) (string, time.Time, bool) {
+	if status == nil || status.GetState() != "pending" {
+		return "", time.Time{}, false
+	}
+	owner, until, ok := strings.Cut(strings.TrimPrefix(status.GetDescription(), "locked by "), " until ")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	expires, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return owner, expires, true
+}
+
+func (l *statusLocker) Acquire(ctx context.Context, pr *github.PullRequest, owner string, ttl time.Duration) (bool, string, error) {
+	status, err := l.current(ctx, pr)
+	if err != nil {
+		return false, "", err
+	}
+	if holder, expires, ok := parseLockStatus(status); ok && holder != owner && time.Now().Before(expires) {
+		return false, lockHolder(holder, expires), nil
+	}
+
+	description := "locked by " + lockHolder(owner, time.Now().Add(ttl).Truncate(time.Second))
+	if _, _, err := l.client.Repositories.CreateStatus(ctx, l.owner, l.repo, pr.GetHead().GetSHA(), &github.RepoStatus{
+		State:       github.Ptr("pending"),
+		Context:     github.Ptr(lockStatusContext),
+		Description: github.Ptr(description),
+	}); err != nil {
+		return false, "", fmt.Errorf("error setting lock status: %v", err)
+	}
+
+	// Another instance may have written its status at the same time
+	status, err = l.current(ctx, pr)
+	if err != nil {
+		return false, "", err
+	}
+	if holder, expires, ok := parseLockStatus(status); ok && holder != owner {
+		return false, lockHolder(holder, expires), nil
+	}
+	return true, "", nil
+}
+
+func (l *statusLocker) Release(ctx context.Context, pr *github.PullRequest, owner string) error {
+	status, err := l.current(ctx, pr)
+	if err != nil {
+		return err
+	}
+	if holder, _, ok := parseLockStatus(status); !ok || holder != owner {
+		return nil
+	}
+	if _, _, err := l.client.Repositories.CreateStatus(ctx, l.owner, l.repo, pr.GetHead().GetSHA(), &github.RepoStatus{
+		State:       github.Ptr("success"),
+		Context:     github.Ptr(lockStatusContext),
+		Description: github.Ptr("released by " + owner),
+	}); err != nil {
+		return fmt.Errorf("error releasing lock status: %v", err)
+	}
+	return nil
+}
+
+// redisReleaseScript deletes the lock only if it is still held by the owner
+const redisReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// redisLocker keeps the locks as Redis keys expiring with the lease. It
+// speaks the Redis protocol directly, so any compatible server (Redis,
+// Valkey, KeyDB, ...) works.
+type redisLocker struct {
+	addr     string
+	password string
+	db       int
+	repo     string
+}
+
+// newRedisLocker parses a redis://[:password@]host[:port][/db] URL or a plain
+// host:port address
+func newRedisLocker(address, repo string) (*redisLocker, error) {
+	l := &redisLocker{addr: address, repo: repo}
+	if !strings.Contains(address, "://") {
+		return l, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q: must be redis://[:password@]host[:port][/db]", address)
+	}
+	l.addr = u.Host
+	if u.Port() == "" {
+		l.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		l.password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return l, nil
+}
+
+func (l *redisLocker) key(number int) string {
+	return lockRedisPrefix + lockKey(l.repo, number)
+}
+
+// do runs the commands on a fresh connection and returns the reply of the
+// last one
+func (l *redisLocker) do(ctx context.Context, commands ...[]string) (interface{}, error) {
+	dialer := net.Dialer{Timeout: lockRedisTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", l.addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to Redis: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(lockRedisTimeout))
+
+	if l.db != 0 {
+		commands = append([][]string{{"SELECT", strconv.Itoa(l.db)}}, commands...)
+	}
+	if l.password != "" {
+		commands = append([][]string{{"AUTH", l.password}}, commands...)
+	}
+
+	reader := bufio.NewReader(conn)
+	var reply interface{}
+	for _, command := range commands {
+		if _, err := conn.Write(encodeRESP(command)); err != nil {
+			return nil, fmt.Errorf("error writing to Redis: %v", err)
+		}
+		if reply, err = readRESP(reader); err != nil {
+			return nil, fmt.Errorf("redis %s: %v", command[0], err)
+		}
+	}
+	return reply, nil
+}
+
+func (l *redisLocker) Acquire(ctx context.Context, pr *github.PullRequest, owner string, ttl time.Duration) (bool, string, error) {
+	key := l.key(pr.GetNumber())
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+
+	// One retry if the lease expired between SET and GET
+	for attempt := 0; attempt < 2; attempt++ {
+		reply, err := l.do(ctx, []string{"SET", key, owner, "NX", "PX", ms})
+		if err != nil {
+			return false, "", err
+		}
+		if reply == "OK" {
+			return true, "", nil
+		}
+
+		holder, err := l.do(ctx, []string{"GET", key})
+		if err != nil {
+			return false, "", err
+		}
+		if holder == nil {
+			continue
+		}
+		if holder == owner {
+			// Extend our own lease
+			if _, err := l.do(ctx, []string{"SET", key, owner, "XX", "PX", ms}); err != nil {
+				return false, "", err
+			}
+			return true, "", nil
+		}
+		remaining, err := l.do(ctx, []string{"PTTL", key})
+		if err != nil {
+			return false, "", err
+		}
+		ms, _ := remaining.(int64)
+		return false, lockHolder(fmt.Sprint(holder), time.Now().Add(time.Duration(ms)*time.Millisecond)), nil
+	}
+	return false, "", fmt.Errorf("redis lock %s keeps changing", key)
+}
+
+func (l *redisLocker) Release(ctx context.Context, pr *github.PullRequest, owner string) error {
+	_, err := l.do(ctx, []string{"EVAL", redisReleaseScript, "1", l.key(pr.GetNumber()), owner})
+	return err
+}
+
+// encodeRESP encodes a command as a RESP array of bulk strings
+func encodeRESP(args []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// readRESP reads a RESP reply: simple and bulk strings become strings, nil
+// bulk strings and arrays nil, integers int64 and arrays []interface{}
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// testLocker runs the contract every lock backend has to fulfil
+func testLocker(t *testing.T, l locker) {
+	t.Helper()
+	ctx := context.Background()
+	pr := &github.PullRequest{Number: github.Ptr(1), Head: &github.PullRequestBranch{SHA: github.Ptr("test-sha")}}
+
+	acquire := func(owner string, expected bool) string {
+		t.Helper()
+		acquired, holder, err := l.Acquire(ctx, pr, owner, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", owner, err)
+		}
+		if acquired != expected {
+			t.Fatalf("%s: expected acquired %v, got %v (holder %q)", owner, expected, acquired, holder)
+		}
+		return holder
+	}
+
+	acquire("instance-a", true)
+	if holder := acquire("instance-b", false); !strings.HasPrefix(holder, "instance-a until ") {
+		t.Errorf("Expected the holder to be instance-a, got %q", holder)
+	}
+	// Extending the own lease
+	acquire("instance-a", true)
+
+	// Only the holder can release the lock
+	if err := l.Release(ctx, pr, "instance-b"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	acquire("instance-b", false)
+	if err := l.Release(ctx, pr, "instance-a"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	acquire("instance-b", true)
+
+	// Other PRs are locked independently
+	other := &github.PullRequest{Number: github.Ptr(2), Head: &github.PullRequestBranch{SHA: github.Ptr("other-sha")}}
+	if acquired, _, err := l.Acquire(ctx, other, "instance-a", time.Minute); err != nil || !acquired {
+		t.Errorf("Expected another PR to be lockable, got %v (%v)", acquired, err)
+	}
+
+	// Expired leases are taken over
+	if acquired, _, err := l.Acquire(ctx, other, "instance-a", time.Millisecond); err != nil || !acquired {
+		t.Fatalf("Expected the lease to be shortened, got %v (%v)", acquired, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if acquired, holder, err := l.Acquire(ctx, other, "instance-b", time.Minute); err != nil || !acquired {
+		t.Errorf("Expected the expired lock to be taken over, got %v (holder %q, %v)", acquired, holder, err)
+	}
+}
+
+func TestFileLocker(t *testing.T) {
+	dir := t.TempDir()
+	testLocker(t, &fileLocker{dir: dir, repo: testOwner + "/" + testRepo})
+
+	if _, err := os.Stat(filepath.Join(dir, "test-owner_test-repo_1.lock")); err != nil {
+		t.Errorf("Expected a lock file per PR: %v", err)
+	}
+
+	// A lock file that is still being written counts as held
+	path := filepath.Join(dir, "test-owner_test-repo_3.lock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l := &fileLocker{dir: dir, repo: testOwner + "/" + testRepo}
+	pr := &github.PullRequest{Number: github.Ptr(3)}
+	if acquired, _, err := l.Acquire(context.Background(), pr, "instance-a", time.Minute); err != nil || acquired {
+		t.Errorf("Expected an empty lock file to be held, got %v (%v)", acquired, err)
+	}
+}
+
+func TestStatusLocker(t *testing.T) {
+	var mu sync.Mutex
+	statuses := make(map[string][]*github.RepoStatus)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/statuses", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		writeJSON(t, w, statuses[r.PathValue("sha")])
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/statuses/{sha}", func(w http.ResponseWriter, r *http.Request) {
+		var status github.RepoStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			t.Errorf("Invalid status: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Newest first, like GitHub
+		sha := r.PathValue("sha")
+		statuses[sha] = append([]*github.RepoStatus{&status}, statuses[sha]...)
+		writeJSON(t, w, &status)
+	})
+	client := github.NewClient(&http.Client{Transport: &handlerTransport{handler: mux}})
+
+	testLocker(t, &statusLocker{client: client, owner: testOwner, repo: testRepo})
+
+	latest := statuses["test-sha"][0]
+	if latest.GetContext() != lockStatusContext || latest.GetState() != "pending" || !strings.HasPrefix(latest.GetDescription(), "locked by instance-b until ") {
+		t.Errorf("Expected a pending lock status held by instance-b, got %s %s %q", latest.GetContext(), latest.GetState(), latest.GetDescription())
+	}
+	if released := statuses["test-sha"][1]; released.GetState() != "success" || released.GetDescription() != "released by instance-a" {
+		t.Errorf("Expected the release to be a success status, got %s %q", released.GetState(), released.GetDescription())
+	}
+}
+
+// redisStandIn is a local server speaking enough of the Redis protocol for
+// the lock backend
+type redisStandIn struct {
+	password string
+	mu       sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	commands []string
+}
+
+func startRedisStandIn(t *testing.T, password string) (*redisStandIn, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	s := &redisStandIn{password: password, values: make(map[string]string), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, listener.Addr().String()
+}
+
+func (s *redisStandIn) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		request, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		items, _ := request.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		if args[0] != "AUTH" && !authenticated {
+			_, _ = conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		if args[0] == "AUTH" {
+			authenticated = len(args) == 2 && args[1] == s.password
+		}
+		_, _ = conn.Write([]byte(s.handle(args)))
+	}
+}
+
+func (s *redisStandIn) handle(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, args[0])
+	for key, expires := range s.expires {
+		if time.Now().After(expires) {
+			delete(s.values, key)
+			delete(s.expires, key)
+		}
+	}
+	bulk := func(key string) string {
+		value, ok := s.values[key]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	}
+
+	switch args[0] {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		return bulk(args[1])
+	case "PTTL":
+		expires, ok := s.expires[args[1]]
+		if !ok {
+			return ":-2\r\n"
+		}
+		return ":" + strconv.FormatInt(time.Until(expires).Milliseconds(), 10) + "\r\n"
+	case "SET":
+		key, value := args[1], args[2]
+		_, exists := s.values[key]
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch args[i] {
+			case "NX":
+				if exists {
+					return "$-1\r\n"
+				}
+			case "XX":
+				if !exists {
+					return "$-1\r\n"
+				}
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		s.values[key] = value
+		s.expires[key] = time.Now().Add(ttl)
+		return "+OK\r\n"
+	case "EVAL":
+		if args[1] != redisReleaseScript {
+			return "-ERR unknown script\r\n"
+		}
+		key, owner := args[3], args[4]
+		if s.values[key] != owner {
+			return ":0\r\n"
+		}
+		delete(s.values, key)
+		delete(s.expires, key)
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	server, addr := startRedisStandIn(t, "secret")
+	l, err := newRedisLocker("redis://:secret@"+addr+"/2", testOwner+"/"+testRepo)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if l.addr != addr || l.password != "secret" || l.db != 2 {
+		t.Errorf("Unexpected Redis settings %+v", l)
+	}
+
+	testLocker(t, l)
+
+	if server.values[lockRedisPrefix+"test-owner/test-repo#1"] != "instance-b" {
+		t.Errorf("Expected the lock key to be held by instance-b, got %v", server.values)
+	}
+	if server.commands[0] != "AUTH" || server.commands[1] != "SELECT" {
+		t.Errorf("Expected to authenticate and select the database first, got %v", server.commands[:2])
+	}
+
+	wrong, err := newRedisLocker("redis://:wrong@"+addr, testOwner+"/"+testRepo)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pr := &github.PullRequest{Number: github.Ptr(1)}
+	if _, _, err := wrong.Acquire(context.Background(), pr, "instance-c", time.Minute); err == nil {
+		t.Error("Expected an error for unauthenticated commands")
+	}
+
+	if _, err := newRedisLocker("http://"+addr, "r"); err == nil {
+		t.Error("Expected an error for a non-Redis URL")
+	}
+}
+
+func TestProcessPullRequests_Locks(t *testing.T) {
+	dir := t.TempDir()
+	other := &fileLocker{dir: dir, repo: testOwner + "/" + testRepo}
+	lockedPR := &github.PullRequest{Number: github.Ptr(1)}
+
+	for _, lockedElsewhere := range []bool{true, false} {
+		if lockedElsewhere {
+			if acquired, _, err := other.Acquire(context.Background(), lockedPR, "cron", time.Minute); err != nil || !acquired {
+				t.Fatalf("Failed to lock the PR: %v", err)
+			}
+		}
+
+		transport := newPolicyTestServer(t, "Bump lib", nil)
+		processor := &PRProcessor{
+			client:    github.NewClient(&http.Client{Transport: transport}),
+			cfg:       &config{owner: testOwner, repo: testRepo, lockBackend: lockBackendFile, lockTTL: time.Minute},
+			ctx:       context.Background(),
+			locker:    &fileLocker{dir: dir, repo: testOwner + "/" + testRepo},
+			lockOwner: "actions",
+		}
+		if err := processor.ProcessPullRequests(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		r := processor.sortedReports()[0]
+		merges := len(transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge"))
+		if lockedElsewhere {
+			if r.outcome != outcomeSkipped || r.skipCode != skipLocked || !strings.HasPrefix(r.reason, "locked by cron until ") {
+				t.Errorf("Expected the PR to be skipped as locked, got %s (%s)", r.outcome, r.reason)
+			}
+			if merges != 0 {
+				t.Errorf("Expected a PR locked by another instance not to be merged, got %d merges", merges)
+			}
+			if err := other.Release(context.Background(), lockedPR, "cron"); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if r.outcome != outcomeMerged || merges != 1 {
+			t.Errorf("Expected the PR to be merged once, got %s with %d merges", r.outcome, merges)
+		}
+		if r.trace[0].String() != "[lock] lock: acquired (backend=file, owner=actions, ttl=1m0s)" {
+			t.Errorf("Expected the lock in the trace, got %q", r.trace[0])
+		}
+		if _, err := os.Stat(other.path(1)); !os.IsNotExist(err) {
+			t.Errorf("Expected the lock to be released after the run, got %v", err)
+		}
+	}
+}
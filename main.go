@@ -7,27 +7,114 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/google/go-github/v71/github"
-	"golang.org/x/oauth2"
 )
 
 // Define execCommand as a variable for testing
 var execCommand = exec.Command
 
+// Defaults for waiting on GitHub to finish updating a PR branch
+const (
+	defaultUpdateWaitInterval = 5 * time.Second
+	defaultUpdateWaitBackoff  = 1.0
+	defaultUpdateWaitTimeout  = 25 * time.Second
+)
+
 type config struct {
 	token            string
+	tokenFile        string // Path to a file containing the GitHub token
+	tokenCommand     string // Shell command printing the GitHub token on stdout
+	tokenSource      string // Where the token was obtained from
 	owner            string
 	repo             string
+	remote           string // Git remote to read owner/repo from when not specified
+	host             string // GitHub host: github.com, a GitHub Enterprise Server or a GHE.com subdomain
+	botLogin         string // Login the tool comments as when the authenticated user cannot be read
 	approve          bool
 	skipPattern      string // Regular expression pattern to skip PRs
 	authorPattern    string // Regular expression pattern to filter PRs by author
 	autoRebase       bool   // Whether to automatically rebase PRs that are behind
 	filterByReviewer bool   // Whether to filter PRs by reviewer (default: true)
+	updateStrategy   string // How to update branches that are behind: "merge" or "rebase"
+
+	updateWaitInterval time.Duration // Initial interval between polls for a branch update
+	updateWaitBackoff  float64       // Multiplier applied to the poll interval after each poll
+	updateWaitTimeout  time.Duration // Maximum time to wait for a branch update
+
+	waitForChecks      bool          // Whether to wait for pending checks to settle before deciding
+	checksWaitInterval time.Duration // Interval between polls of pending checks
+	checksWaitTimeout  time.Duration // Maximum time to wait for pending checks per PR
+
+	rerunFailedJobs int // Maximum number of times to re-run failed GitHub Actions jobs per PR (0 = disabled)
+
+	statusComment bool // Whether to maintain a sticky status comment on each processed PR
+
+	notifyConfig string // Path to the JSON notification configuration
+
+	interval       time.Duration // Time between runs in daemon mode (0 = run once and exit)
+	metricsAddr    string        // Address to serve Prometheus metrics on, empty to disable
+	pushgatewayURL string        // Prometheus Pushgateway to push metrics to after a one-shot run
+
+	traceExporter string // Where to export OpenTelemetry traces: "otlp", "stdout" or empty to disable
+
+	stateFile         string        // Where to persist per-PR state between runs, empty to disable
+	stateBackend      string        // State store backend: "json" or "sqlite"
+	stateRecheckAfter time.Duration // Re-evaluate unchanged blocked PRs after this long
+	maxRebaseAttempts int           // Maximum number of branch updates per PR across runs (0 = unlimited)
+
+	policyFile string // Path to the JSON merge policy
+
+	dependencyPolicySpec string                  // Action per dependency update type, e.g. "patch=merge,major=approve"
+	dependencyPolicy     map[string]policyAction // Parsed dependencyPolicySpec
+
+	mergeMethod        string             // How to merge PRs: "merge", "squash" or "rebase"
+	mergeCommitTitle   string             // Go template of the merge commit title, empty for GitHub's default
+	mergeCommitBody    string             // Go template of the merge commit message
+	mergeTitleTemplate *template.Template // Parsed mergeCommitTitle, nil if empty
+	mergeBodyTemplate  *template.Template // Parsed mergeCommitBody, nil if empty
+	coAuthoredBy       bool               // Whether to credit all commit authors in squash merge commits
+
+	deleteBranch bool // Whether to delete the head branch of same-repository PRs after merging
+
+	conflictLabel   string // Label applied to PRs conflicting with their base branch, empty to disable
+	conflictComment bool   // Whether to comment on conflicting PRs with the conflicting files
+
+	staleDays          int    // Mark PRs stale after this many days without activity (0 = disabled)
+	staleCloseDays     int    // Close stale PRs this many days after the warning (0 = never)
+	staleLabel         string // Label marking stale PRs
+	staleExemptLabels  string // Comma separated labels exempting PRs from stale handling
+	staleExemptAuthors string // Comma separated authors exempt from stale handling
+
+	dryRun bool // Print what would be done without changing anything
+
+	serialMerge    bool   // Merge green PRs one at a time per base branch after checking all of them
+	priorityLabels string // Comma separated labels moving PRs to the front of the merge queue, highest first
+
+	mergeTrain       bool   // Test batches of green PRs together on a staging branch and fast-forward the base branch
+	mergeTrainSize   int    // Maximum number of PRs per merge train
+	mergeTrainPrefix string // Prefix of the staging branches, followed by the base branch name
+
+	freezeFile string // Path to the JSON merge freeze schedule
+
+	tui bool // Review the open PRs in an interactive terminal UI instead of processing them
+
+	jsonOutput bool // Print the result of explain as JSON
+
+	lockBackend string        // Where to lock PRs against other instances: "file", "status", "redis" or empty to disable
+	lockAddress string        // Lock directory for the file backend, Redis address for the redis backend
+	lockTTL     time.Duration // How long a lock is held at most, in case its holder dies
+
+	httpCache    string // Revalidate GitHub API responses from a "memory" or "disk" cache, empty to disable
+	httpCacheDir string // Directory of the disk cache
 }
 
 type PRProcessor struct {
@@ -35,6 +122,24 @@ type PRProcessor struct {
 	cfg         *config
 	ctx         context.Context
 	currentUser string // Current authenticated user login
+
+	reports *prReports // Per-PR outcome of the current run
+
+	notifier *notifier       // Chat and webhook notifications, nil if disabled
+	metrics  *metrics        // Prometheus metrics, nil if disabled
+	state    stateStore      // Per-PR state persisted between runs, nil if disabled
+	policy   *policy         // Rules of the policy file, nil if none
+	freeze   *freezeSchedule // Merge freeze windows, nil if none
+	locker   locker          // Locks PRs against other instances, nil if disabled
+
+	httpCache *cachingTransport // Conditional request cache of the GitHub client, nil if disabled
+
+	lockOwner string // Identifies this instance in locks
+
+	activeFreeze *freezeWindow // Freeze window in effect for the current run, nil if none
+
+	merging    bool // Set on the copy running the serial merge or merge train phase
+	updateOnly bool // Set for the update command: updating a branch never approves or merges the PR
 }
 
 func getGitConfig(key string) (string, error) {
@@ -46,28 +151,22 @@ func getGitConfig(key string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func getRepositoryInfo() (owner string, repo string, err error) {
-	remoteURL, err := getGitConfig("remote.origin.url")
+func getRepositoryInfo(remote string) (owner string, repo string, err error) {
+	info, err := getRemoteInfo(remote)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get remote URL: %v", err)
-	}
-
-	// Handle both HTTPS and SSH URL formats
-	remoteURL = strings.TrimSuffix(remoteURL, ".git")
-	parts := strings.Split(remoteURL, "/")
-	if len(parts) < 2 {
-		return "", "", fmt.Errorf("invalid remote URL format: %s", remoteURL)
+		return "", "", err
 	}
+	return info.owner, info.repo, nil
+}
 
-	repo = parts[len(parts)-1]
-	owner = parts[len(parts)-2]
-
-	// For SSH format, remove the username part from owner
-	if strings.Contains(owner, ":") {
-		owner = strings.Split(owner, ":")[1]
+// getRemoteInfo returns the host and repository of the selected git remote
+func getRemoteInfo(remote string) (*remoteInfo, error) {
+	remote = selectRemote(remote)
+	remoteURL, err := getRemoteURL(remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get URL of remote %s: %v", remote, err)
 	}
-
-	return owner, repo, nil
+	return parseRemoteURL(remoteURL)
 }
 
 func loadConfigWithFlags(flags *flag.FlagSet, args []string) (*config, error) {
@@ -75,16 +174,81 @@ func loadConfigWithFlags(flags *flag.FlagSet, args []string) (*config, error) {
 		approve:          true, // Default to true
 		autoRebase:       true, // Default to true
 		filterByReviewer: true, // Default to true
+		updateStrategy:   updateStrategyMerge,
+
+		updateWaitInterval: defaultUpdateWaitInterval,
+		updateWaitBackoff:  defaultUpdateWaitBackoff,
+		updateWaitTimeout:  defaultUpdateWaitTimeout,
+		checksWaitInterval: defaultChecksWaitInterval,
+		checksWaitTimeout:  defaultChecksWaitTimeout,
+		stateBackend:       stateBackendJSON,
+		stateRecheckAfter:  defaultStateRecheckAfter,
+		mergeMethod:        mergeMethodMerge,
+		mergeTrainSize:     defaultMergeTrainSize,
+		mergeTrainPrefix:   defaultMergeTrainPrefix,
+		lockTTL:            defaultLockTTL,
+		httpCacheDir:       defaultHTTPCacheDir(),
 	}
 
 	// Define command line flags
 	flags.StringVar(&cfg.token, "token", "", "GitHub personal access token")
+	flags.StringVar(&cfg.tokenFile, "token-file", "", "Read the GitHub token from this file")
+	flags.StringVar(&cfg.tokenCommand, "token-command", "", "Run this command and use its output as the GitHub token (re-run when the token is rejected)")
 	flags.StringVar(&cfg.owner, "owner", "", "Repository owner")
 	flags.StringVar(&cfg.repo, "repo", "", "Repository name")
+	flags.StringVar(&cfg.remote, "remote", "", "Git remote to detect the repository from (default: upstream if present, otherwise origin)")
+	flags.StringVar(&cfg.host, "github-host", "", "GitHub host, e.g. github.example.com for GitHub Enterprise Server (default: the host of the git remote the repository is detected from, otherwise github.com)")
+	flags.StringVar(&cfg.botLogin, "bot-login", "", "Login of the app the token belongs to, e.g. my-app[bot], used to recognize own comments when the authenticated user cannot be read (default: github-actions[bot] in GitHub Actions)")
 	flags.BoolVar(&cfg.approve, "approve", true, "Automatically approve PR when status checks pass")
 	flags.StringVar(&cfg.skipPattern, "skip-pattern", "", "Skip PRs whose titles match this regular expression pattern")
 	flags.StringVar(&cfg.authorPattern, "author-pattern", "", "Only process PRs whose authors match this regular expression pattern")
 	flags.BoolVar(&cfg.autoRebase, "auto-rebase", true, "Automatically rebase PRs that are behind the base branch")
+	flags.StringVar(&cfg.updateStrategy, "update-strategy", updateStrategyMerge, "How to update branches that are behind: 'merge' (GitHub update branch) or 'rebase' (local rebase and force-push)")
+	flags.DurationVar(&cfg.updateWaitInterval, "update-wait-interval", defaultUpdateWaitInterval, "Initial interval between checks whether a branch update has completed")
+	flags.Float64Var(&cfg.updateWaitBackoff, "update-wait-backoff", defaultUpdateWaitBackoff, "Multiplier applied to the wait interval after each check (1 = constant interval)")
+	flags.DurationVar(&cfg.updateWaitTimeout, "update-wait-timeout", defaultUpdateWaitTimeout, "Maximum time to wait for a branch update to complete")
+	flags.BoolVar(&cfg.waitForChecks, "wait-for-checks", false, "Wait for pending checks to complete before deciding what to do with a PR")
+	flags.DurationVar(&cfg.checksWaitInterval, "wait-for-checks-interval", defaultChecksWaitInterval, "Interval between checks of pending status checks")
+	flags.DurationVar(&cfg.checksWaitTimeout, "wait-for-checks-timeout", defaultChecksWaitTimeout, "Maximum time to wait for pending checks per PR")
+	flags.IntVar(&cfg.rerunFailedJobs, "rerun-failed-jobs", 0, "Re-run failed GitHub Actions jobs up to this many times per PR before giving up (0 = disabled)")
+	flags.BoolVar(&cfg.statusComment, "status-comment", false, "Maintain a single comment on each processed PR explaining the decision")
+	flags.StringVar(&cfg.notifyConfig, "notify-config", "", "Path to a JSON file configuring Slack, Discord and webhook notifications")
+	flags.DurationVar(&cfg.interval, "interval", 0, "Keep running and process the open PRs every interval (0 = run once and exit)")
+	flags.StringVar(&cfg.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090")
+	flags.StringVar(&cfg.pushgatewayURL, "pushgateway-url", "", "Push metrics to this Prometheus Pushgateway at the end of a one-shot run")
+	flags.StringVar(&cfg.traceExporter, "trace-exporter", "", "Export OpenTelemetry traces of each run: 'otlp' (configured via OTEL_EXPORTER_OTLP_* variables) or 'stdout'")
+	flags.StringVar(&cfg.stateFile, "state-file", "", "Remember per-PR decisions in this file to skip unchanged PRs and enforce budgets across runs")
+	flags.StringVar(&cfg.stateBackend, "state-backend", stateBackendJSON, "State store backend: 'json' or 'sqlite'")
+	flags.DurationVar(&cfg.stateRecheckAfter, "state-recheck-after", defaultStateRecheckAfter, "Re-evaluate blocked PRs whose head and base did not change after this long")
+	flags.IntVar(&cfg.maxRebaseAttempts, "max-rebase-attempts", 0, "Maximum number of branch updates per PR across runs, requires -state-file (0 = unlimited)")
+	flags.StringVar(&cfg.policyFile, "policy-file", "", "Path to a JSON file with merge policy rules written as CEL expressions")
+	flags.StringVar(&cfg.mergeMethod, "merge-method", mergeMethodMerge, "How to merge PRs: 'merge', 'squash' or 'rebase'")
+	flags.StringVar(&cfg.mergeCommitTitle, "merge-commit-title", "", "Go template of the merge commit title, e.g. '{{.Title}} (#{{.Number}})'")
+	flags.StringVar(&cfg.mergeCommitBody, "merge-commit-body", "", "Go template of the merge commit message (default: \""+defaultMergeCommitMessage+"\")")
+	flags.BoolVar(&cfg.coAuthoredBy, "co-authored-by", false, "Append Co-authored-by trailers for all commit authors to squash merge commits")
+	flags.BoolVar(&cfg.deleteBranch, "delete-branch", false, "Delete the head branch after merging, unless it is protected, in a fork or the base of other open PRs")
+	flags.StringVar(&cfg.conflictLabel, "conflict-label", "", "Label PRs that conflict with their base branch, e.g. needs-rebase; removed once they are mergeable again")
+	flags.BoolVar(&cfg.conflictComment, "conflict-comment", false, "Comment on PRs that conflict with their base branch, listing the conflicting files when known")
+	flags.IntVar(&cfg.staleDays, "stale-days", 0, "Label and warn PRs without activity for this many days (0 = disabled)")
+	flags.IntVar(&cfg.staleCloseDays, "stale-close-days", 0, "Close stale PRs this many days after the warning (0 = never)")
+	flags.StringVar(&cfg.staleLabel, "stale-label", defaultStaleLabel, "Label marking stale PRs")
+	flags.StringVar(&cfg.staleExemptLabels, "stale-exempt-labels", "", "Comma separated labels exempting PRs from stale handling")
+	flags.StringVar(&cfg.staleExemptAuthors, "stale-exempt-authors", "", "Comma separated authors whose PRs are never marked stale")
+	flags.BoolVar(&cfg.dryRun, "dry-run", false, "Print what would be done without approving, merging, updating, labeling, commenting or closing anything")
+	flags.BoolVar(&cfg.serialMerge, "serial-merge", false, "Merge green PRs one at a time per base branch, updating only the next PR in line after each merge")
+	flags.StringVar(&cfg.priorityLabels, "priority-labels", "", "Comma separated labels moving PRs to the front of the merge queue, highest priority first")
+	flags.BoolVar(&cfg.mergeTrain, "merge-train", false, "Test batches of green PRs together on a staging branch and merge them all at once, bisecting failed batches")
+	flags.IntVar(&cfg.mergeTrainSize, "merge-train-size", defaultMergeTrainSize, "Maximum number of PRs per merge train")
+	flags.StringVar(&cfg.mergeTrainPrefix, "merge-train-prefix", defaultMergeTrainPrefix, "Prefix of the merge train staging branches, followed by the base branch name")
+	flags.StringVar(&cfg.freezeFile, "freeze-file", "", "Path to a JSON file with merge freeze windows during which PRs are only approved or skipped")
+	flags.BoolVar(&cfg.tui, "tui", false, "Review the open PRs in an interactive terminal UI and act on them with keystrokes")
+	flags.BoolVar(&cfg.jsonOutput, "json", false, "Print the result of explain as JSON, including the decision trace")
+	flags.StringVar(&cfg.lockBackend, "lock-backend", "", "Lock PRs so that only one instance acts on a PR at a time: 'file', 'status' (best-effort, no mutual exclusion) or 'redis' (default: no locking)")
+	flags.StringVar(&cfg.lockAddress, "lock-address", "", "Lock directory for the file backend, redis://[:password@]host[:port][/db] for the redis backend")
+	flags.DurationVar(&cfg.lockTTL, "lock-ttl", defaultLockTTL, "Lease of a PR lock, after which it expires if its holder did not release it")
+	flags.StringVar(&cfg.httpCache, "http-cache", "", "Cache GitHub API responses and revalidate them with conditional requests: 'memory' or 'disk' (default: no cache)")
+	flags.StringVar(&cfg.httpCacheDir, "http-cache-dir", cfg.httpCacheDir, "Directory of the 'disk' HTTP cache")
+	flags.StringVar(&cfg.dependencyPolicySpec, "dependency-policy", "", "Action per Renovate/Dependabot update type, e.g. 'patch=merge,minor=merge,major=approve'")
 	var noFilterReviewer bool
 	flags.BoolVar(&noFilterReviewer, "no-filter-reviewer", false, "Disable filtering by reviewer (process all PRs)")
 
@@ -98,8 +262,11 @@ func loadConfigWithFlags(flags *flag.FlagSet, args []string) (*config, error) {
 	}
 
 	// Load from environment variables if not specified in command line
-	if cfg.token == "" {
-		cfg.token = os.Getenv("GITHUB_TOKEN")
+	if cfg.tokenFile == "" {
+		cfg.tokenFile = os.Getenv("GITHUB_TOKEN_FILE")
+	}
+	if cfg.tokenCommand == "" {
+		cfg.tokenCommand = os.Getenv("GITHUB_TOKEN_COMMAND")
 	}
 	if cfg.owner == "" {
 		cfg.owner = os.Getenv("GITHUB_OWNER")
@@ -107,20 +274,292 @@ func loadConfigWithFlags(flags *flag.FlagSet, args []string) (*config, error) {
 	if cfg.repo == "" {
 		cfg.repo = os.Getenv("GITHUB_REPO")
 	}
+	if cfg.remote == "" {
+		cfg.remote = os.Getenv("GITHUB_REMOTE")
+	}
+	if host := os.Getenv("GITHUB_HOST"); host != "" && !isFlagSet(flags, "github-host") {
+		cfg.host = host
+	}
+	if botLogin := os.Getenv("GITHUB_BOT_LOGIN"); botLogin != "" && !isFlagSet(flags, "bot-login") {
+		cfg.botLogin = botLogin
+	}
+	if cfg.botLogin == "" && os.Getenv("GITHUB_ACTIONS") == "true" {
+		cfg.botLogin = defaultActionsBotLogin
+	}
+	if cfg.notifyConfig == "" {
+		cfg.notifyConfig = os.Getenv("GITHUB_NOTIFY_CONFIG")
+	}
+	if cfg.metricsAddr == "" {
+		cfg.metricsAddr = os.Getenv("GITHUB_METRICS_ADDR")
+	}
+	if cfg.pushgatewayURL == "" {
+		cfg.pushgatewayURL = os.Getenv("GITHUB_PUSHGATEWAY_URL")
+	}
+	if cfg.traceExporter == "" {
+		cfg.traceExporter = os.Getenv("GITHUB_TRACE_EXPORTER")
+	}
+	if cfg.stateFile == "" {
+		cfg.stateFile = os.Getenv("GITHUB_STATE_FILE")
+	}
+	if backend := os.Getenv("GITHUB_STATE_BACKEND"); backend != "" && !isFlagSet(flags, "state-backend") {
+		cfg.stateBackend = backend
+	}
+	if cfg.policyFile == "" {
+		cfg.policyFile = os.Getenv("GITHUB_POLICY_FILE")
+	}
+	if cfg.freezeFile == "" {
+		cfg.freezeFile = os.Getenv("GITHUB_FREEZE_FILE")
+	}
+	if cfg.dependencyPolicySpec == "" {
+		cfg.dependencyPolicySpec = os.Getenv("GITHUB_DEPENDENCY_POLICY")
+	}
+	if method := os.Getenv("GITHUB_MERGE_METHOD"); method != "" && !isFlagSet(flags, "merge-method") {
+		cfg.mergeMethod = method
+	}
+	if cfg.mergeCommitTitle == "" {
+		cfg.mergeCommitTitle = os.Getenv("GITHUB_MERGE_COMMIT_TITLE")
+	}
+	if cfg.mergeCommitBody == "" {
+		cfg.mergeCommitBody = os.Getenv("GITHUB_MERGE_COMMIT_BODY")
+	}
+	if days := os.Getenv("GITHUB_STALE_DAYS"); days != "" && !isFlagSet(flags, "stale-days") {
+		value, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_STALE_DAYS: %v", err)
+		}
+		cfg.staleDays = value
+	}
+	if days := os.Getenv("GITHUB_STALE_CLOSE_DAYS"); days != "" && !isFlagSet(flags, "stale-close-days") {
+		value, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_STALE_CLOSE_DAYS: %v", err)
+		}
+		cfg.staleCloseDays = value
+	}
+	if label := os.Getenv("GITHUB_STALE_LABEL"); label != "" && !isFlagSet(flags, "stale-label") {
+		cfg.staleLabel = label
+	}
+	if cfg.staleExemptLabels == "" {
+		cfg.staleExemptLabels = os.Getenv("GITHUB_STALE_EXEMPT_LABELS")
+	}
+	if cfg.staleExemptAuthors == "" {
+		cfg.staleExemptAuthors = os.Getenv("GITHUB_STALE_EXEMPT_AUTHORS")
+	}
+	if serialMerge := os.Getenv("GITHUB_SERIAL_MERGE"); (serialMerge == "true" || serialMerge == "1") && !isFlagSet(flags, "serial-merge") {
+		cfg.serialMerge = true
+	}
+	if cfg.priorityLabels == "" {
+		cfg.priorityLabels = os.Getenv("GITHUB_PRIORITY_LABELS")
+	}
+	if mergeTrain := os.Getenv("GITHUB_MERGE_TRAIN"); (mergeTrain == "true" || mergeTrain == "1") && !isFlagSet(flags, "merge-train") {
+		cfg.mergeTrain = true
+	}
+	if size := os.Getenv("GITHUB_MERGE_TRAIN_SIZE"); size != "" && !isFlagSet(flags, "merge-train-size") {
+		value, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_MERGE_TRAIN_SIZE: %v", err)
+		}
+		cfg.mergeTrainSize = value
+	}
+	if prefix := os.Getenv("GITHUB_MERGE_TRAIN_PREFIX"); prefix != "" && !isFlagSet(flags, "merge-train-prefix") {
+		cfg.mergeTrainPrefix = prefix
+	}
+	if backend := os.Getenv("GITHUB_LOCK_BACKEND"); backend != "" && !isFlagSet(flags, "lock-backend") {
+		cfg.lockBackend = backend
+	}
+	if address := os.Getenv("GITHUB_LOCK_ADDRESS"); address != "" && !isFlagSet(flags, "lock-address") {
+		cfg.lockAddress = address
+	}
+	if err := loadDurationEnv(flags, "lock-ttl", "GITHUB_LOCK_TTL", &cfg.lockTTL); err != nil {
+		return nil, err
+	}
+	if cache := os.Getenv("GITHUB_HTTP_CACHE"); cache != "" && !isFlagSet(flags, "http-cache") {
+		cfg.httpCache = cache
+	}
+	if dir := os.Getenv("GITHUB_HTTP_CACHE_DIR"); dir != "" && !isFlagSet(flags, "http-cache-dir") {
+		cfg.httpCacheDir = dir
+	}
+	if dryRun := os.Getenv("GITHUB_DRY_RUN"); (dryRun == "true" || dryRun == "1") && !isFlagSet(flags, "dry-run") {
+		cfg.dryRun = true
+	}
+	if cfg.conflictLabel == "" {
+		cfg.conflictLabel = os.Getenv("GITHUB_CONFLICT_LABEL")
+	}
+	if conflictComment := os.Getenv("GITHUB_CONFLICT_COMMENT"); (conflictComment == "true" || conflictComment == "1") && !isFlagSet(flags, "conflict-comment") {
+		cfg.conflictComment = true
+	}
+	if deleteBranch := os.Getenv("GITHUB_DELETE_BRANCH"); (deleteBranch == "true" || deleteBranch == "1") && !isFlagSet(flags, "delete-branch") {
+		cfg.deleteBranch = true
+	}
+	if coAuthoredBy := os.Getenv("GITHUB_CO_AUTHORED_BY"); (coAuthoredBy == "true" || coAuthoredBy == "1") && !isFlagSet(flags, "co-authored-by") {
+		cfg.coAuthoredBy = true
+	}
 	if cfg.skipPattern == "" {
 		cfg.skipPattern = os.Getenv("GITHUB_PR_SKIP_PATTERN")
 	}
 	if cfg.authorPattern == "" {
 		cfg.authorPattern = os.Getenv("GITHUB_PR_AUTHOR_PATTERN")
 	}
+	if strategy := os.Getenv("GITHUB_UPDATE_STRATEGY"); strategy != "" && !isFlagSet(flags, "update-strategy") {
+		cfg.updateStrategy = strategy
+	}
+	if err := loadDurationEnv(flags, "update-wait-interval", "GITHUB_UPDATE_WAIT_INTERVAL", &cfg.updateWaitInterval); err != nil {
+		return nil, err
+	}
+	if err := loadDurationEnv(flags, "update-wait-timeout", "GITHUB_UPDATE_WAIT_TIMEOUT", &cfg.updateWaitTimeout); err != nil {
+		return nil, err
+	}
+	if backoff := os.Getenv("GITHUB_UPDATE_WAIT_BACKOFF"); backoff != "" && !isFlagSet(flags, "update-wait-backoff") {
+		value, err := strconv.ParseFloat(backoff, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_UPDATE_WAIT_BACKOFF: %v", err)
+		}
+		cfg.updateWaitBackoff = value
+	}
+	if waitForChecks := os.Getenv("GITHUB_WAIT_FOR_CHECKS"); (waitForChecks == "true" || waitForChecks == "1") && !isFlagSet(flags, "wait-for-checks") {
+		cfg.waitForChecks = true
+	}
+	if err := loadDurationEnv(flags, "wait-for-checks-interval", "GITHUB_WAIT_FOR_CHECKS_INTERVAL", &cfg.checksWaitInterval); err != nil {
+		return nil, err
+	}
+	if err := loadDurationEnv(flags, "wait-for-checks-timeout", "GITHUB_WAIT_FOR_CHECKS_TIMEOUT", &cfg.checksWaitTimeout); err != nil {
+		return nil, err
+	}
+	if rerun := os.Getenv("GITHUB_RERUN_FAILED_JOBS"); rerun != "" && !isFlagSet(flags, "rerun-failed-jobs") {
+		value, err := strconv.Atoi(rerun)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_RERUN_FAILED_JOBS: %v", err)
+		}
+		cfg.rerunFailedJobs = value
+	}
+	if err := loadDurationEnv(flags, "interval", "GITHUB_RUN_INTERVAL", &cfg.interval); err != nil {
+		return nil, err
+	}
+	if err := loadDurationEnv(flags, "state-recheck-after", "GITHUB_STATE_RECHECK_AFTER", &cfg.stateRecheckAfter); err != nil {
+		return nil, err
+	}
+	if attempts := os.Getenv("GITHUB_MAX_REBASE_ATTEMPTS"); attempts != "" && !isFlagSet(flags, "max-rebase-attempts") {
+		value, err := strconv.Atoi(attempts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_MAX_REBASE_ATTEMPTS: %v", err)
+		}
+		cfg.maxRebaseAttempts = value
+	}
+	if statusComment := os.Getenv("GITHUB_STATUS_COMMENT"); (statusComment == "true" || statusComment == "1") && !isFlagSet(flags, "status-comment") {
+		cfg.statusComment = true
+	}
 	// Check environment variable for filterByReviewer (inverted logic: GITHUB_NO_FILTER_REVIEWER=true means filterByReviewer=false)
 	if noFilterReviewer := os.Getenv("GITHUB_NO_FILTER_REVIEWER"); noFilterReviewer == "true" || noFilterReviewer == "1" {
 		cfg.filterByReviewer = false
 	}
 
-	// Token is required
-	if cfg.token == "" {
-		return nil, fmt.Errorf("GitHub token is required. Set it via -token flag or GITHUB_TOKEN environment variable")
+	// Get repository info from git config if owner/repo not specified. The
+	// host of the remote also selects the API and the credentials to use.
+	if cfg.owner == "" || cfg.repo == "" {
+		info, err := getRemoteInfo(cfg.remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get repository info: %v", err)
+		}
+		cfg.owner, cfg.repo = info.owner, info.repo
+		if cfg.host == "" {
+			cfg.host = normalizeGitHubHost(info.host)
+		}
+		log.Printf("Using repository from git config: %s/%s on %s", cfg.owner, cfg.repo, cfg.host)
+	}
+	if cfg.host == "" {
+		cfg.host = defaultGitHubHost
+	}
+
+	// Token is required, fall back to gh and git credential helpers
+	token, source, err := resolveToken(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GitHub token: %v", err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("GitHub token is required. Set it via -token, -token-file or -token-command flag, GITHUB_TOKEN environment variable, or log in with gh")
+	}
+	cfg.token = token
+	cfg.tokenSource = source
+
+	// Validate update strategy
+	if cfg.updateStrategy != updateStrategyMerge && cfg.updateStrategy != updateStrategyRebase {
+		return nil, fmt.Errorf("invalid update strategy %q: must be %q or %q", cfg.updateStrategy, updateStrategyMerge, updateStrategyRebase)
+	}
+
+	// Validate trace exporter
+	if cfg.traceExporter != "" && cfg.traceExporter != traceExporterOTLP && cfg.traceExporter != traceExporterStdout {
+		return nil, fmt.Errorf("invalid trace exporter %q: must be %q or %q", cfg.traceExporter, traceExporterOTLP, traceExporterStdout)
+	}
+
+	// Validate dependency policy
+	if cfg.dependencyPolicy, err = parseDependencyPolicy(cfg.dependencyPolicySpec); err != nil {
+		return nil, fmt.Errorf("invalid dependency policy: %v", err)
+	}
+
+	// Validate merge settings
+	if cfg.mergeMethod != mergeMethodMerge && cfg.mergeMethod != mergeMethodSquash && cfg.mergeMethod != mergeMethodRebase {
+		return nil, fmt.Errorf("invalid merge method %q: must be %q, %q or %q", cfg.mergeMethod, mergeMethodMerge, mergeMethodSquash, mergeMethodRebase)
+	}
+	if cfg.mergeTitleTemplate, err = parseMergeTemplate("merge commit title", cfg.mergeCommitTitle); err != nil {
+		return nil, err
+	}
+	if cfg.mergeBodyTemplate, err = parseMergeTemplate("merge commit message", cfg.mergeCommitBody); err != nil {
+		return nil, err
+	}
+
+	// Validate state settings
+	if cfg.stateBackend != stateBackendJSON && cfg.stateBackend != stateBackendSQLite {
+		return nil, fmt.Errorf("invalid state backend %q: must be %q or %q", cfg.stateBackend, stateBackendJSON, stateBackendSQLite)
+	}
+	if cfg.maxRebaseAttempts < 0 {
+		return nil, fmt.Errorf("max-rebase-attempts must not be negative")
+	}
+	if cfg.maxRebaseAttempts > 0 && cfg.stateFile == "" {
+		return nil, fmt.Errorf("max-rebase-attempts requires a state file")
+	}
+
+	// Validate update wait settings
+	if cfg.updateWaitInterval <= 0 || cfg.updateWaitTimeout <= 0 {
+		return nil, fmt.Errorf("update wait interval and timeout must be positive")
+	}
+	if cfg.checksWaitInterval <= 0 || cfg.checksWaitTimeout <= 0 {
+		return nil, fmt.Errorf("wait-for-checks interval and timeout must be positive")
+	}
+	if cfg.interval < 0 {
+		return nil, fmt.Errorf("interval must not be negative")
+	}
+	if cfg.tui && cfg.interval > 0 {
+		return nil, fmt.Errorf("tui cannot be combined with interval")
+	}
+	if cfg.rerunFailedJobs < 0 {
+		return nil, fmt.Errorf("rerun-failed-jobs must not be negative")
+	}
+	if cfg.mergeTrainSize < 1 {
+		return nil, fmt.Errorf("merge-train-size must be at least 1")
+	}
+	if cfg.mergeTrain && strings.Trim(cfg.mergeTrainPrefix, "/") == "" {
+		return nil, fmt.Errorf("merge-train-prefix must not be empty")
+	}
+	if cfg.mergeTrain && cfg.mergeMethod != mergeMethodMerge {
+		return nil, fmt.Errorf("merge-train lands PRs as merge commits and cannot be combined with merge method %q", cfg.mergeMethod)
+	}
+	switch cfg.lockBackend {
+	case "", lockBackendStatus:
+	case lockBackendFile, lockBackendRedis:
+		if cfg.lockAddress == "" {
+			return nil, fmt.Errorf("lock backend %q requires a lock address", cfg.lockBackend)
+		}
+	default:
+		return nil, fmt.Errorf("invalid lock backend %q: must be %q, %q or %q", cfg.lockBackend, lockBackendFile, lockBackendStatus, lockBackendRedis)
+	}
+	if cfg.lockTTL <= 0 {
+		return nil, fmt.Errorf("lock-ttl must be positive")
+	}
+	if cfg.httpCache != "" && cfg.httpCache != httpCacheMemory && cfg.httpCache != httpCacheDisk {
+		return nil, fmt.Errorf("invalid HTTP cache %q: must be %q or %q", cfg.httpCache, httpCacheMemory, httpCacheDisk)
+	}
+	if cfg.updateWaitBackoff < 1 {
+		return nil, fmt.Errorf("update wait backoff must be at least 1, got %v", cfg.updateWaitBackoff)
 	}
 
 	// Validate skip pattern if provided
@@ -137,34 +576,135 @@ func loadConfigWithFlags(flags *flag.FlagSet, args []string) (*config, error) {
 		}
 	}
 
-	// Get repository info from git config if owner/repo not specified
-	if cfg.owner == "" || cfg.repo == "" {
-		var err error
-		cfg.owner, cfg.repo, err = getRepositoryInfo()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get repository info: %v", err)
+	return cfg, nil
+}
+
+// isFlagSet reports whether the named flag was given on the command line
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
-		log.Printf("Using repository from git config: %s/%s", cfg.owner, cfg.repo)
+	})
+	return set
+}
+
+// loadDurationEnv reads a duration from an environment variable unless the
+// corresponding flag was given on the command line
+func loadDurationEnv(flags *flag.FlagSet, name, env string, value *time.Duration) error {
+	raw := os.Getenv(env)
+	if raw == "" || isFlagSet(flags, name) {
+		return nil
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", env, err)
 	}
+	*value = duration
+	return nil
+}
 
-	return cfg, nil
+// sleepContext waits for the given duration or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
 func NewPRProcessor(ctx context.Context, cfg *config) (*PRProcessor, error) {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: cfg.token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+	httpClient := newAuthenticatedHTTPClient(ctx, cfg)
+	var m *metrics
+	if cfg.metricsAddr != "" || cfg.pushgatewayURL != "" {
+		m = newMetrics()
+		httpClient.Transport = &metricsTransport{metrics: m, base: httpClient.Transport}
+	}
+	if cfg.traceExporter != "" {
+		httpClient.Transport = &tracingTransport{base: httpClient.Transport}
+	}
+	// Outermost, so that metrics and traces show the conditional requests
+	var cache *cachingTransport
+	if cfg.httpCache != "" {
+		store, err := openHTTPCacheStore(cfg.httpCache, cfg.httpCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		cache = newCachingTransport(store, cfg.token, httpClient.Transport)
+		httpClient.Transport = cache
+	}
+	client := github.NewClient(httpClient)
+	if baseURL := githubAPIURL(cfg.host); baseURL != "" {
+		var err error
+		if client, err = client.WithEnterpriseURLs(baseURL, baseURL); err != nil {
+			return nil, fmt.Errorf("invalid GitHub host %q: %v", cfg.host, err)
+		}
+	}
 
-	// Get current authenticated user
+	// Get current authenticated user, also needed to find the own marked
+	// comments. Installation tokens, e.g. of GitHub Actions, cannot read it.
 	currentUser := ""
-	if cfg.filterByReviewer {
-		user, _, err := client.Users.Get(ctx, "")
+	user, _, err := client.Users.Get(ctx, "")
+	switch {
+	case err == nil:
+		currentUser = user.GetLogin()
+	case cfg.filterByReviewer:
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	default:
+		if cfg.botLogin != "" {
+			fmt.Printf("Warning: failed to get current user, comments of %s are recognized as own: %v\n", cfg.botLogin, err)
+		} else {
+			fmt.Printf("Warning: failed to get current user, own comments cannot be recognized, see -bot-login: %v\n", err)
+		}
+	}
+
+	var n *notifier
+	if cfg.notifyConfig != "" {
+		var err error
+		n, err = loadNotifier(cfg.notifyConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get current user: %w", err)
+			return nil, err
+		}
+	}
+
+	var pol *policy
+	if cfg.policyFile != "" {
+		var err error
+		pol, err = loadPolicy(cfg.policyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var freeze *freezeSchedule
+	if cfg.freezeFile != "" {
+		var err error
+		freeze, err = loadFreezeSchedule(cfg.freezeFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var state stateStore
+	if cfg.stateFile != "" {
+		var err error
+		state, err = openStateStore(cfg.stateBackend, cfg.stateFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lock locker
+	if cfg.lockBackend != "" {
+		var err error
+		lock, err = openLocker(cfg, client)
+		if err != nil {
+			return nil, err
 		}
-		currentUser = user.GetLogin()
 	}
 
 	return &PRProcessor{
@@ -172,10 +712,29 @@ func NewPRProcessor(ctx context.Context, cfg *config) (*PRProcessor, error) {
 		cfg:         cfg,
 		ctx:         ctx,
 		currentUser: currentUser,
+		notifier:    n,
+		metrics:     m,
+		state:       state,
+		policy:      pol,
+		freeze:      freeze,
+		locker:      lock,
+		httpCache:   cache,
+		lockOwner:   newLockOwner(),
 	}, nil
 }
 
 func (p *PRProcessor) ProcessPullRequests() error {
+	// Every run starts from a clean slate in daemon mode
+	p.reports = &prReports{}
+
+	run, span := p.startSpan("ProcessPullRequests", attrRepository.String(p.cfg.owner+"/"+p.cfg.repo))
+	err := run.processPullRequests()
+	endSpan(span, err)
+	p.metrics.observeRun(p.sortedReports(), err)
+	return err
+}
+
+func (p *PRProcessor) processPullRequests() error {
 	// Get open pull requests
 	prs, _, err := p.client.PullRequests.List(p.ctx, p.cfg.owner, p.cfg.repo, &github.PullRequestListOptions{
 		State: "open",
@@ -194,6 +753,19 @@ func (p *PRProcessor) ProcessPullRequests() error {
 	if p.cfg.skipPattern != "" {
 		fmt.Printf("Skip pattern enabled: %s\n", p.cfg.skipPattern)
 	}
+	if p.cfg.rerunFailedJobs > 0 {
+		fmt.Printf("Re-running failed GitHub Actions jobs up to %d times per PR\n", p.cfg.rerunFailedJobs)
+	}
+	if p.cfg.waitForChecks {
+		fmt.Printf("Waiting up to %v per PR for pending checks\n", p.cfg.checksWaitTimeout)
+	}
+
+	if p.cfg.dryRun {
+		fmt.Println("Dry run: no changes will be made")
+	}
+	if p.activeFreeze = p.freeze.active(time.Now()); p.activeFreeze != nil {
+		fmt.Printf("Merge freeze active: %s\n", p.activeFreeze)
+	}
 
 	// Filter out draft PRs
 	var nonDraftPRs []*github.PullRequest
@@ -202,26 +774,66 @@ func (p *PRProcessor) ProcessPullRequests() error {
 			nonDraftPRs = append(nonDraftPRs, pr)
 		} else {
 			fmt.Printf("PR #%d: Skipping draft PR: %s\n", pr.GetNumber(), pr.GetTitle())
+			p.report(pr).skip(skipDraft, "draft", false)
 		}
 	}
 
+	// Stale PRs are handled first so that they don't clutter the rest of the run
+	var staleErrs []error
+	if p.cfg.staleDays > 0 {
+		fmt.Printf("Stale handling enabled: marking PRs stale after %d days without activity\n", p.cfg.staleDays)
+		nonDraftPRs, staleErrs = p.handleStalePRs(nonDraftPRs)
+	}
+
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(nonDraftPRs))
+	errChan := make(chan error, len(nonDraftPRs)+len(staleErrs))
+	for _, err := range staleErrs {
+		errChan <- err
+	}
 
 	for _, pr := range nonDraftPRs {
 		wg.Add(1)
 		go func(pr *github.PullRequest) {
 			defer wg.Done()
-			if err := p.processSinglePR(pr); err != nil {
+			proc, span := p.startPRSpan("processSinglePR", pr)
+			locked, err := proc.lockPR(pr)
+			if locked {
+				err = proc.processSinglePR(pr)
+			}
+			if err != nil {
 				log.Printf("Error processing PR #%d: %v", pr.GetNumber(), err)
 				errChan <- fmt.Errorf("PR #%d: %w", pr.GetNumber(), err)
+				if r := proc.report(pr); r.outcome != outcomeConflict {
+					r.decide(outcomeError, err.Error())
+				}
 			}
+			// Queued PRs are finished by the merge phase, PRs locked by
+			// another instance are left to it
+			if locked && !proc.report(pr).queued {
+				proc.finishPR(pr)
+			}
+			endSpan(span, err)
 		}(pr)
 	}
 
 	wg.Wait()
+
+	if p.queuesMerges() {
+		if err := p.processMergeQueue(nonDraftPRs); err != nil {
+			errChan <- err
+		}
+	}
+	p.releaseLocks()
 	close(errChan)
 
+	p.printSummary(os.Stdout)
+
+	if p.notifier != nil && !p.cfg.dryRun {
+		if err := p.notifyDigest(); err != nil {
+			log.Printf("Error sending run digest: %v", err)
+		}
+	}
+
 	var errors []error
 	for err := range errChan {
 		errors = append(errors, err)
@@ -234,65 +846,87 @@ func (p *PRProcessor) ProcessPullRequests() error {
 	return nil
 }
 
-func (p *PRProcessor) shouldSkipPR(pr *github.PullRequest) (bool, error) {
-	// Check reviewer filter (if enabled, only process PRs where current user is a reviewer)
-	if p.cfg.filterByReviewer {
-		requestedReviewers := pr.RequestedReviewers
-		if len(requestedReviewers) == 0 {
-			fmt.Printf("PR #%d: Skipping due to no reviewers assigned\n", pr.GetNumber())
-			return true, nil
-		}
-		isReviewer := false
-		for _, reviewer := range requestedReviewers {
-			if reviewer.GetLogin() == p.currentUser {
-				isReviewer = true
-				break
-			}
-		}
-		if !isReviewer {
-			fmt.Printf("PR #%d: Skipping due to %s not being a reviewer\n", pr.GetNumber(), p.currentUser)
-			return true, nil
+// finishPR publishes and persists the outcome of a processed PR and releases
+// its lock
+func (p *PRProcessor) finishPR(pr *github.PullRequest) {
+	if p.handlesConflicts() {
+		if err := p.syncConflictLabel(pr); err != nil {
+			log.Printf("Error updating conflict label of PR #%d: %v", pr.GetNumber(), err)
 		}
 	}
-
-	// Check skip pattern
-	if p.cfg.skipPattern != "" {
-		matched, err := regexp.MatchString(p.cfg.skipPattern, pr.GetTitle())
-		if err != nil {
-			return false, fmt.Errorf("error matching skip pattern: %v", err)
+	if p.cfg.statusComment {
+		if err := p.updateStatusComment(pr); err != nil {
+			log.Printf("Error updating status comment on PR #%d: %v", pr.GetNumber(), err)
 		}
-		if matched {
-			fmt.Printf("PR #%d: Skipping due to title matching skip pattern: %s\n", pr.GetNumber(), p.cfg.skipPattern)
-			return true, nil
+	}
+	if p.notifier != nil && !p.cfg.dryRun {
+		if err := p.notifyPR(pr); err != nil {
+			log.Printf("Error sending notification for PR #%d: %v", pr.GetNumber(), err)
 		}
 	}
+	if err := p.saveState(pr); err != nil {
+		log.Printf("Error saving state of PR #%d: %v", pr.GetNumber(), err)
+	}
+	p.unlockPR(pr)
+}
 
-	// Check author pattern (if specified, only process PRs from matching authors)
-	if p.cfg.authorPattern != "" {
-		author := pr.GetUser().GetLogin()
-		matched, err := regexp.MatchString(p.cfg.authorPattern, author)
-		if err != nil {
-			return false, fmt.Errorf("error matching author pattern: %v", err)
-		}
-		if !matched {
-			fmt.Printf("PR #%d: Skipping due to author '%s' not matching author pattern: %s\n", pr.GetNumber(), author, p.cfg.authorPattern)
-			return true, nil
-		}
+func (p *PRProcessor) shouldSkipPR(pr *github.PullRequest) (bool, error) {
+	decision, err := p.evaluatePolicy(pr)
+	if err != nil {
+		return false, err
 	}
 
-	return false, nil
+	r := p.report(pr)
+	r.policyRule, r.policyAction, r.policySHA = decision.rule, decision.action, pr.GetHead().GetSHA()
+	if r.dependency = parseDependencyUpdate(pr); r.dependency != nil {
+		fmt.Printf("PR #%d: %s update of %s\n", pr.GetNumber(), r.dependency.bot, r.dependency)
+	}
+	if decision.action != policySkip {
+		return false, nil
+	}
+
+	fmt.Printf("PR #%d: Skipping due to %s\n", pr.GetNumber(), decision.reason)
+	r.skip(decision.skipCode, decision.reason, decision.inScope)
+	return true, nil
 }
 
 func (p *PRProcessor) checkStatusChecks(pr *github.PullRequest) ([]string, []string, error) {
-	combinedStatus, _, err := p.client.Repositories.GetCombinedStatus(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetHead().GetSHA(), nil)
+	failedStatuses, pendingStatuses, total, err := p.checkCommit(pr.GetHead().GetSHA())
 	if err != nil {
-		return nil, nil, fmt.Errorf("error getting status: %v", err)
+		return nil, nil, err
+	}
+
+	result := "passed"
+	switch {
+	case len(failedStatuses) > 0:
+		result = "failed"
+	case len(pendingStatuses) > 0:
+		result = "pending"
+	case total == 0:
+		result = "passed, none reported"
+	}
+	p.report(pr).record(stageChecks, "status-checks", result, checksInputs(pr.GetHead().GetSHA(), failedStatuses, pendingStatuses, total))
+	return failedStatuses, pendingStatuses, nil
+}
+
+// checkCommit returns the failed and pending statuses and check runs of a
+// commit together with the number of statuses and check runs reported
+func (p *PRProcessor) checkCommit(sha string) ([]string, []string, int, error) {
+	combinedStatus, _, err := p.client.Repositories.GetCombinedStatus(p.ctx, p.cfg.owner, p.cfg.repo, sha, nil)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error getting status: %v", err)
 	}
 
 	var failedStatuses []string
 	var pendingStatuses []string
 
+	var total int
 	for _, status := range combinedStatus.Statuses {
+		// The lock of another instance is no check
+		if status.GetContext() == lockStatusContext {
+			continue
+		}
+		total++
 		switch status.GetState() {
 		case "failure", "error":
 			failedStatuses = append(failedStatuses, status.GetContext())
@@ -303,7 +937,20 @@ func (p *PRProcessor) checkStatusChecks(pr *github.PullRequest) ([]string, []str
 		}
 	}
 
-	return failedStatuses, pendingStatuses, nil
+	checkRuns, err := p.listCheckRuns(sha)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for _, run := range checkRuns {
+		switch checkRunState(run) {
+		case "failure":
+			failedStatuses = append(failedStatuses, run.GetName())
+		case "pending":
+			pendingStatuses = append(pendingStatuses, run.GetName())
+		}
+	}
+
+	return failedStatuses, pendingStatuses, total + len(checkRuns), nil
 }
 
 func (p *PRProcessor) handleFailedChecks(pr *github.PullRequest, failedStatuses, pendingStatuses []string) error {
@@ -315,7 +962,27 @@ func (p *PRProcessor) handleFailedChecks(pr *github.PullRequest, failedStatuses,
 		fmt.Printf("PR #%d: Pending checks: %s\n", pr.GetNumber(), strings.Join(pendingStatuses, ", "))
 	}
 
+	r := p.report(pr)
+	r.failedChecks, r.pendingChecks = failedStatuses, pendingStatuses
+	if len(failedStatuses) > 0 {
+		r.decide(outcomeBlocked, reasonChecksFailed)
+	} else {
+		r.decide(outcomePending, "status checks pending")
+	}
+
+	// Flaky jobs are retried before anything else
+	if len(failedStatuses) > 0 && p.cfg.rerunFailedJobs > 0 {
+		retried, err := p.rerunFailedJobs(pr)
+		if err != nil {
+			return err
+		}
+		if retried {
+			return nil
+		}
+	}
+
 	if !p.cfg.autoRebase {
+		r.record(stageUpdate, "auto-rebase", "disabled, branch left as is", nil)
 		if len(failedStatuses) > 0 {
 			fmt.Printf("PR #%d: Status checks failed and auto-rebase is disabled\n", pr.GetNumber())
 		} else {
@@ -328,41 +995,111 @@ func (p *PRProcessor) handleFailedChecks(pr *github.PullRequest, failedStatuses,
 }
 
 func (p *PRProcessor) tryRebasePR(pr *github.PullRequest) error {
-	comparison, _, err := p.client.Repositories.CompareCommits(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetBase().GetSHA(), pr.GetHead().GetSHA(), nil)
+	// Compare against the live base branch: the base SHA of the PR is stale
+	// once another PR was merged during the run
+	base := pr.GetBase().GetRef()
+	if base == "" {
+		base = pr.GetBase().GetSHA()
+	}
+	comparison, _, err := p.client.Repositories.CompareCommits(p.ctx, p.cfg.owner, p.cfg.repo, base, pr.GetHead().GetSHA(), nil)
 	if err != nil {
 		return fmt.Errorf("error comparing commits: %v", err)
 	}
 
+	r := p.report(pr)
+	inputs := map[string]string{
+		"base":      base,
+		"head":      pr.GetHead().GetSHA(),
+		"behind_by": strconv.Itoa(comparison.GetBehindBy()),
+	}
 	if comparison.GetBehindBy() == 0 {
 		fmt.Printf("PR #%d: Branch is up to date with base branch\n", pr.GetNumber())
+		r.record(stageUpdate, "behind-base", "up to date", inputs)
+		return nil
+	}
+
+	r.behindBy = comparison.GetBehindBy()
+	if p.cfg.maxRebaseAttempts > 0 && r.previous != nil && r.previous.RebaseAttempts >= p.cfg.maxRebaseAttempts {
+		fmt.Printf("PR #%d: Behind by %d commits but update budget exhausted (%d/%d attempts)\n", pr.GetNumber(), comparison.GetBehindBy(), r.previous.RebaseAttempts, p.cfg.maxRebaseAttempts)
+		r.rebase = fmt.Sprintf("not updated, budget of %d updates exhausted", p.cfg.maxRebaseAttempts)
+		inputs["attempts"] = fmt.Sprintf("%d/%d", r.previous.RebaseAttempts, p.cfg.maxRebaseAttempts)
+		r.record(stageUpdate, "behind-base", "behind, update budget exhausted", inputs)
 		return nil
 	}
 
+	r.record(stageUpdate, "behind-base", "behind, updating with "+p.cfg.updateStrategy, inputs)
 	fmt.Printf("PR #%d: Needs rebase, behind by %d commits. Updating branch...\n", pr.GetNumber(), comparison.GetBehindBy())
+	r.rebaseAttempted = true
 	return p.updatePRBranch(pr)
 }
 
 func (p *PRProcessor) updatePRBranch(pr *github.PullRequest) error {
-	result, _, err := p.client.PullRequests.UpdateBranch(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), nil)
+	if p.dryRun(pr, "update the branch using the %s strategy", p.cfg.updateStrategy) {
+		r := p.report(pr)
+		r.rebase = fmt.Sprintf("would update the branch (behind by %d commits, dry run)", r.behindBy)
+		r.decide(outcomeUpdated, "branch is behind the base branch (dry run)")
+		return nil
+	}
+
+	if p.cfg.updateStrategy == updateStrategyRebase {
+		proc, span := p.startSpan("rebasePRBranch")
+		err := proc.rebasePRBranch(pr)
+		endSpan(span, err)
+		return err
+	}
+
+	// Only update if the head is still the one the decision was based on
+	result, _, err := p.client.PullRequests.UpdateBranch(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), &github.PullRequestBranchUpdateOptions{
+		ExpectedHeadSHA: github.Ptr(pr.GetHead().GetSHA()),
+	})
 	if err != nil {
+		if strings.Contains(err.Error(), "expected head sha") {
+			return fmt.Errorf("PR #%d: head changed since it was checked, skipping update: %v", pr.GetNumber(), err)
+		}
 		if strings.Contains(err.Error(), "not mergeable") {
+			p.report(pr).decide(outcomeConflict, "branch cannot be updated automatically")
 			return fmt.Errorf("PR #%d: cannot be updated automatically, manual rebase required: %v", pr.GetNumber(), err)
 		}
 		return fmt.Errorf("error updating branch: %v", err)
 	}
 
+	r := p.report(pr)
+	r.rebase = fmt.Sprintf("merged `%s` into the branch (was behind by %d commits)", pr.GetBase().GetRef(), r.behindBy)
+	r.decide(outcomeUpdated, "branch was behind the base branch")
+
 	if result.GetMessage() != "Updating pull request branch." {
 		return nil
 	}
 
 	fmt.Printf("PR #%d: Update in progress, waiting for completion...\n", pr.GetNumber())
-	return p.waitForUpdateCompletion(pr)
+	proc, span := p.startSpan("waitForUpdateCompletion")
+	err = proc.waitForUpdateCompletion(pr)
+	endSpan(span, err)
+	return err
 }
 
 func (p *PRProcessor) waitForUpdateCompletion(pr *github.PullRequest) error {
-	maxRetries := 5
-	for i := 0; i < maxRetries; i++ {
-		time.Sleep(5 * time.Second)
+	interval := p.cfg.updateWaitInterval
+	if interval <= 0 {
+		interval = defaultUpdateWaitInterval
+	}
+	backoff := p.cfg.updateWaitBackoff
+	if backoff < 1 {
+		backoff = defaultUpdateWaitBackoff
+	}
+	timeout := p.cfg.updateWaitTimeout
+	if timeout <= 0 {
+		timeout = defaultUpdateWaitTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if remaining := time.Until(deadline); remaining < interval {
+			interval = remaining
+		}
+		if err := sleepContext(p.ctx, interval); err != nil {
+			return fmt.Errorf("PR #%d: waiting for branch update cancelled: %w", pr.GetNumber(), err)
+		}
 
 		updatedPR, _, err := p.client.PullRequests.Get(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber())
 		if err != nil {
@@ -373,9 +1110,14 @@ func (p *PRProcessor) waitForUpdateCompletion(pr *github.PullRequest) error {
 			fmt.Printf("PR #%d: Branch update completed\n", pr.GetNumber())
 			return p.checkUpdatedPRStatus(updatedPR)
 		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+		interval = time.Duration(float64(interval) * backoff)
 	}
 
-	return fmt.Errorf("PR #%d: branch update timed out", pr.GetNumber())
+	return fmt.Errorf("PR #%d: branch update timed out after %v", pr.GetNumber(), timeout)
 }
 
 func (p *PRProcessor) checkUpdatedPRStatus(pr *github.PullRequest) error {
@@ -385,16 +1127,28 @@ func (p *PRProcessor) checkUpdatedPRStatus(pr *github.PullRequest) error {
 	}
 
 	if len(failedStatuses) == 0 && len(pendingStatuses) == 0 {
+		if p.updateOnly {
+			fmt.Printf("PR #%d: Status checks passed after update\n", pr.GetNumber())
+			return nil
+		}
 		return p.handleSuccessfulPR(pr)
 	}
 
 	fmt.Printf("PR #%d: Status checks still not passed after update\n", pr.GetNumber())
+	r := p.report(pr)
+	r.failedChecks, r.pendingChecks = failedStatuses, pendingStatuses
 	return nil
 }
 
 func (p *PRProcessor) processSinglePR(pr *github.PullRequest) error {
 	fmt.Printf("Processing PR #%d: %s\n", pr.GetNumber(), pr.GetTitle())
 
+	// Loaded first so that skipped PRs keep what earlier runs recorded
+	previous, err := p.loadState(pr)
+	if err != nil {
+		return err
+	}
+
 	shouldSkip, err := p.shouldSkipPR(pr)
 	if err != nil {
 		return err
@@ -402,12 +1156,65 @@ func (p *PRProcessor) processSinglePR(pr *github.PullRequest) error {
 	if shouldSkip {
 		return nil
 	}
+	// Without the base head the PR is evaluated again, as if it had changed
+	if p.state != nil {
+		if baseHead, err := p.baseBranchHead(pr.GetBase().GetRef()); err != nil {
+			log.Printf("Error getting the base branch of PR #%d: %v", pr.GetNumber(), err)
+		} else {
+			p.report(pr).baseHead = baseHead
+		}
+	}
+	if p.unchangedSinceLastRun(pr, previous) {
+		fmt.Printf("PR #%d: Unchanged since last run (%s: %s), skipping\n", pr.GetNumber(), previous.Decision, previous.Reason)
+		r := p.report(pr)
+		r.decide(prOutcome(previous.Decision), previous.Reason)
+		r.unchanged = true
+		return nil
+	}
+
+	// Checks are irrelevant while the PR can't be merged anyway
+	if p.handlesConflicts() {
+		conflict, err := p.detectConflict(pr)
+		if err != nil {
+			return err
+		}
+		if conflict {
+			fmt.Printf("PR #%d: Conflicts with %s, manual rebase required\n", pr.GetNumber(), pr.GetBase().GetRef())
+			p.report(pr).decide(outcomeConflict, "conflicts with the base branch")
+			return nil
+		}
+	}
 
 	failedStatuses, pendingStatuses, err := p.checkStatusChecks(pr)
 	if err != nil {
 		return err
 	}
 
+	// Let pending checks finish instead of acting on a half-done CI run
+	if p.cfg.waitForChecks && len(failedStatuses) == 0 && len(pendingStatuses) > 0 {
+		head := pr.GetHead().GetSHA()
+		proc, span := p.startSpan("waitForChecks")
+		pr, failedStatuses, pendingStatuses, err = proc.waitForChecks(pr, pendingStatuses)
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+		if pr.GetState() != "" && pr.GetState() != "open" {
+			return nil
+		}
+		// The policy was evaluated for the old head, a push may change
+		// its title, files or labels
+		if pr.GetHead().GetSHA() != head {
+			shouldSkip, err := p.shouldSkipPR(pr)
+			if err != nil {
+				return err
+			}
+			if shouldSkip {
+				return nil
+			}
+		}
+	}
+
 	if len(failedStatuses) > 0 || len(pendingStatuses) > 0 {
 		return p.handleFailedChecks(pr, failedStatuses, pendingStatuses)
 	}
@@ -425,58 +1232,243 @@ func (p *PRProcessor) handleSuccessfulPR(pr *github.PullRequest) error {
 	// Don't approve if there are any failed checks
 	if len(failedStatuses) > 0 {
 		fmt.Printf("PR #%d: Cannot approve - CI checks failed: %s\n", pr.GetNumber(), strings.Join(failedStatuses, ", "))
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = failedStatuses, pendingStatuses
+		r.decide(outcomeBlocked, reasonChecksFailed)
 		return nil
 	}
 
 	// Don't approve if there are pending checks
 	if len(pendingStatuses) > 0 {
 		fmt.Printf("PR #%d: Cannot approve - CI checks still pending: %s\n", pr.GetNumber(), strings.Join(pendingStatuses, ", "))
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = failedStatuses, pendingStatuses
+		r.decide(outcomePending, "status checks pending")
 		return nil
 	}
 
+	action := p.report(pr).policyAction
+
+	// In serial merge and merge train modes PRs are merged after all were checked
+	if p.queuesMerges() && !p.merging && action != policyApprove {
+		fmt.Printf("PR #%d: All status checks passed, queued for merge\n", pr.GetNumber())
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = nil, nil
+		r.queued = true
+		r.record(stageMerge, "merge-queue", "queued for the merge phase", nil)
+		r.decide(outcomePending, "queued for merge")
+		return nil
+	}
+
+	if frozen := p.freezeAtMerge(pr); frozen != nil {
+		if frozen.action == policySkip {
+			r := p.report(pr)
+			r.failedChecks, r.pendingChecks = nil, nil
+			r.skip(frozen.skipCode, frozen.reason, frozen.inScope)
+			return nil
+		}
+		action = frozen.action
+	}
+
 	// Enable auto-merge first using direct REST API call
 	fmt.Printf("PR #%d: All status checks passed, enabling auto-merge...\n", pr.GetNumber())
 
-	// Create review
-	review := &github.PullRequestReviewRequest{
-		Event: github.Ptr("APPROVE"),
+	if err := p.approvePR(pr, p.cfg.approve || action == policyApprove); err != nil {
+		return err
 	}
 
-	// Then approve if configured
-	if p.cfg.approve {
-		fmt.Printf("PR #%d: Approving PR...\n", pr.GetNumber())
-		review, _, err := p.client.PullRequests.CreateReview(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), review)
-		if err != nil {
-			return fmt.Errorf("error approving PR: %v", err)
-		}
-		fmt.Printf("PR #%d: Approved with review ID %d\n", pr.GetNumber(), review.GetID())
+	if action == policyApprove {
+		fmt.Printf("PR #%d: Policy rule %s leaves merging to a human\n", pr.GetNumber(), p.report(pr).policyRule)
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = nil, nil
+		r.record(stageMerge, "policy-action", "not merged, left to a human", map[string]string{"rule": r.policyRule})
+		r.decide(outcomeApproved, "policy rule "+r.policyRule+" only allows approval")
+		return nil
 	}
 
 	// Try to merge the PR
-	result, _, err := p.client.PullRequests.Merge(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), "Auto-merge successful", &github.PullRequestOptions{
-		MergeMethod: "merge",
+	method := p.cfg.mergeMethod
+	if method == "" {
+		method = mergeMethodMerge
+	}
+	title, message, err := p.mergeCommitMessage(pr, method)
+	if err != nil {
+		return err
+	}
+	inputs := map[string]string{"method": method, "head": pr.GetHead().GetSHA()}
+	if p.dryRun(pr, "merge using the %s method", method) {
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = nil, nil
+		r.record(stageMerge, "merge", "would merge (dry run)", inputs)
+		r.decide(outcomeMerged, "all status checks passed (dry run)")
+		return nil
+	}
+	// Merging the checked head only, GitHub refuses if it moved since
+	result, _, err := p.client.PullRequests.Merge(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), message, &github.PullRequestOptions{
+		CommitTitle: title,
+		MergeMethod: method,
+		SHA:         pr.GetHead().GetSHA(),
 	})
 	if err != nil {
+		p.report(pr).record(stageMerge, "merge", "error: "+err.Error(), inputs)
 		return fmt.Errorf("error merging PR: %v", err)
 	}
 
 	fmt.Printf("PR #%d: Successfully merged: %v\n", pr.GetNumber(), result.GetMerged())
+	r := p.report(pr)
+	r.failedChecks, r.pendingChecks = nil, nil
+	if result.GetMerged() {
+		r.record(stageMerge, "merge", "merged", inputs)
+		r.decide(outcomeMerged, "all status checks passed")
+		p.metrics.observeMerged(pr)
+		if p.cfg.deleteBranch {
+			p.deleteHeadBranch(pr)
+		}
+	} else {
+		r.record(stageMerge, "merge", "not merged: "+result.GetMessage(), inputs)
+		r.decide(outcomeBlocked, "merge was not performed: "+result.GetMessage())
+	}
+	return nil
+}
+
+// approvePR approves the PR if approve is set, unless this head was approved
+// in an earlier run
+func (p *PRProcessor) approvePR(pr *github.PullRequest, approve bool) error {
+	r := p.report(pr)
+	inputs := map[string]string{"requested": strconv.FormatBool(approve), "head": pr.GetHead().GetSHA()}
+	if previous := r.previous; approve && previous != nil && previous.ApprovedSHA == pr.GetHead().GetSHA() {
+		fmt.Printf("PR #%d: Already approved at this commit\n", pr.GetNumber())
+		r.record(stageApprove, "approve", "already approved at this commit", inputs)
+		return nil
+	}
+	if !approve {
+		r.record(stageApprove, "approve", "not requested", inputs)
+		return nil
+	}
+	if p.dryRun(pr, "approve") {
+		r.record(stageApprove, "approve", "would approve (dry run)", inputs)
+		return nil
+	}
+
+	fmt.Printf("PR #%d: Approving PR...\n", pr.GetNumber())
+	review, _, err := p.client.PullRequests.CreateReview(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), &github.PullRequestReviewRequest{
+		Event: github.Ptr("APPROVE"),
+	})
+	if err != nil {
+		r.record(stageApprove, "approve", "error: "+err.Error(), inputs)
+		return fmt.Errorf("error approving PR: %v", err)
+	}
+	fmt.Printf("PR #%d: Approved with review ID %d\n", pr.GetNumber(), review.GetID())
+	r.record(stageApprove, "approve", fmt.Sprintf("approved with review %d", review.GetID()), inputs)
+	r.approvedSHA = pr.GetHead().GetSHA()
+	p.metrics.observeApproved()
 	return nil
 }
 
+// runDaemon processes the open PRs every interval until ctx is done. Failed
+// runs are logged and retried on the next tick.
+func runDaemon(ctx context.Context, processor *PRProcessor, interval time.Duration) {
+	for {
+		if err := processor.ProcessPullRequests(); err != nil {
+			log.Printf("Failed to process pull requests: %v", err)
+		}
+		fmt.Printf("Next run in %v\n", interval)
+		if err := sleepContext(ctx, interval); err != nil {
+			return
+		}
+	}
+}
+
 func main() {
-	ctx := context.Background()
-	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	cfg, err := loadConfigWithFlags(flags, os.Args[1:])
+	// Cancel in-flight waits on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	command, args, err := splitCommand(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%v, see %s -h for usage", err, os.Args[0])
+	}
+	flags := flag.NewFlagSet(os.Args[0]+" "+command, flag.ExitOnError)
+	flags.Usage = commandUsage(os.Stderr, os.Args[0], flags)
+	cfg, err := loadConfigWithFlags(flags, args)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	number, err := commandPRNumber(command, flags.Args())
+	if err != nil {
+		flags.Usage()
+		log.Fatalf("%v", err)
+	}
+
+	shutdownTracing, err := setupTracing(ctx, cfg.traceExporter)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	// Flush pending spans, also when exiting because of an error
+	flushTracing := func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}
+	defer flushTracing()
 
 	processor, err := NewPRProcessor(ctx, cfg)
 	if err != nil {
+		flushTracing()
 		log.Fatalf("Failed to create PR processor: %v", err)
 	}
-	if err := processor.ProcessPullRequests(); err != nil {
+	if processor.state != nil {
+		defer func() {
+			if err := processor.state.Close(); err != nil {
+				log.Printf("Failed to close state store: %v", err)
+			}
+		}()
+	}
+
+	switch command {
+	case commandStatus:
+		err = processor.printStatus(os.Stdout)
+	case commandExplain, commandApprove, commandMerge, commandUpdate, commandRerun:
+		err = processor.runPRCommand(os.Stdout, command, number)
+	}
+	if command != commandRun {
+		if err != nil {
+			flushTracing()
+			log.Fatalf("Failed to %s: %v", command, err)
+		}
+		return
+	}
+
+	if cfg.metricsAddr != "" {
+		if err := processor.metrics.serve(ctx, cfg.metricsAddr); err != nil {
+			log.Fatalf("Failed to serve metrics: %v", err)
+		}
+	}
+
+	if cfg.tui {
+		if err := runTUI(processor); err != nil {
+			flushTracing()
+			log.Fatalf("Terminal UI failed: %v", err)
+		}
+		return
+	}
+
+	if cfg.interval > 0 {
+		fmt.Printf("Running every %v until interrupted\n", cfg.interval)
+		runDaemon(ctx, processor, cfg.interval)
+		log.Println("Stopped")
+		return
+	}
+
+	err = processor.ProcessPullRequests()
+	if cfg.pushgatewayURL != "" {
+		if pushErr := processor.metrics.push(ctx, cfg.pushgatewayURL, cfg.owner+"/"+cfg.repo); pushErr != nil {
+			log.Printf("%v", pushErr)
+		}
+	}
+	if err != nil {
+		flushTracing()
 		log.Fatalf("Failed to process pull requests: %v", err)
 	}
 
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v71/github"
 )
@@ -258,7 +263,7 @@ func TestGetRepositoryInfoFromHTTPS(t *testing.T) {
 		return cmd
 	}
 
-	owner, repo, err := getRepositoryInfo()
+	owner, repo, err := getRepositoryInfo("")
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -288,7 +293,7 @@ func TestGetRepositoryInfoFromSSH(t *testing.T) {
 		return cmd
 	}
 
-	owner, repo, err := getRepositoryInfo()
+	owner, repo, err := getRepositoryInfo("")
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -334,6 +339,17 @@ func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		return recorder.Result(), nil
 	}
 
+	// Commits without check runs are the common case in these tests
+	if req.Method == http.MethodGet && strings.HasSuffix(key, "/check-runs") {
+		if _, ok := m.responses[key]; !ok {
+			recorder.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(recorder).Encode(&github.ListCheckRunsResults{Total: github.Ptr(0)}); err != nil {
+				return nil, fmt.Errorf("failed to encode response: %v", err)
+			}
+			return recorder.Result(), nil
+		}
+	}
+
 	// POSTリクエストの場合、レビューエンドポイントへのリクエストを特別に処理
 	if req.Method == http.MethodPost && strings.HasSuffix(key, "/reviews") {
 		response, ok := m.responses[key]
@@ -1087,3 +1103,169 @@ func TestProcessSinglePR_WithReviewerFilterAndCI(t *testing.T) {
 		})
 	}
 }
+
+// recordingTransport wraps another transport and records the method, path
+// and body of every request
+type recordingTransport struct {
+	base     http.RoundTripper
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	r.mu.Lock()
+	r.requests = append(r.requests, recordedRequest{method: req.Method, path: req.URL.Path, body: string(body)})
+	r.mu.Unlock()
+	return r.base.RoundTrip(req)
+}
+
+// find returns the recorded requests matching method and path
+func (r *recordingTransport) find(method, path string) []recordedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []recordedRequest
+	for _, req := range r.requests {
+		if req.method == method && req.path == path {
+			found = append(found, req)
+		}
+	}
+	return found
+}
+
+func TestUpdatePRBranch_ExpectedHeadSHAAndWait(t *testing.T) {
+	transport := &recordingTransport{base: &mockTransport{
+		responses: map[string]interface{}{
+			"/repos/test-owner/test-repo/pulls/1/update-branch": &github.PullRequestBranchUpdateResponse{
+				Message: github.Ptr("Updating pull request branch."),
+			},
+			"/repos/test-owner/test-repo/pulls/1": &github.PullRequest{
+				Number: github.Ptr(1),
+				Head:   &github.PullRequestBranch{SHA: github.Ptr("new-sha")},
+			},
+			"/repos/test-owner/test-repo/commits/new-sha/status": &github.CombinedStatus{
+				State: github.Ptr("pending"),
+				Statuses: []*github.RepoStatus{
+					{State: github.Ptr("pending"), Context: github.Ptr("test-check")},
+				},
+			},
+		},
+	}}
+
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg: &config{
+			owner:              testOwner,
+			repo:               testRepo,
+			updateWaitInterval: time.Millisecond,
+			updateWaitTimeout:  time.Second,
+		},
+		ctx: context.Background(),
+	}
+
+	pr := &github.PullRequest{
+		Number: github.Ptr(1),
+		Head:   &github.PullRequestBranch{SHA: github.Ptr("old-sha")},
+	}
+	if err := processor.updatePRBranch(pr); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	updates := transport.find(http.MethodPut, "/repos/test-owner/test-repo/pulls/1/update-branch")
+	if len(updates) != 1 {
+		t.Fatalf("Expected one update-branch request, got %d", len(updates))
+	}
+	if !strings.Contains(updates[0].body, `"expected_head_sha":"old-sha"`) {
+		t.Errorf("Expected expected_head_sha to be sent, got body %s", updates[0].body)
+	}
+}
+
+func TestWaitForUpdateCompletion(t *testing.T) {
+	newProcessor := func(ctx context.Context, headSHA string, timeout time.Duration) *PRProcessor {
+		mockResp := &mockTransport{
+			responses: map[string]interface{}{
+				"/repos/test-owner/test-repo/pulls/1": &github.PullRequest{
+					Number: github.Ptr(1),
+					Head:   &github.PullRequestBranch{SHA: github.Ptr(headSHA)},
+				},
+			},
+		}
+		return &PRProcessor{
+			client: github.NewClient(&http.Client{Transport: mockResp}),
+			cfg: &config{
+				owner:              testOwner,
+				repo:               testRepo,
+				updateWaitInterval: time.Millisecond,
+				updateWaitBackoff:  2,
+				updateWaitTimeout:  timeout,
+			},
+			ctx: ctx,
+		}
+	}
+	pr := &github.PullRequest{
+		Number: github.Ptr(1),
+		Head:   &github.PullRequestBranch{SHA: github.Ptr("old-sha")},
+	}
+
+	t.Run("times out when head does not change", func(t *testing.T) {
+		processor := newProcessor(context.Background(), "old-sha", 20*time.Millisecond)
+		start := time.Now()
+		err := processor.waitForUpdateCompletion(pr)
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("Expected timeout error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected wait to respect the deadline, took %v", elapsed)
+		}
+	})
+
+	t.Run("cancelled through context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		processor := newProcessor(ctx, "old-sha", time.Hour)
+		err := processor.waitForUpdateCompletion(pr)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestLoadConfigUpdateWait(t *testing.T) {
+	t.Setenv("GITHUB_UPDATE_WAIT_INTERVAL", "2s")
+	t.Setenv("GITHUB_UPDATE_WAIT_BACKOFF", "1.5")
+	baseArgs := []string{"-token", testToken, "-owner", testOwner, "-repo", testRepo}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := loadConfigWithFlags(flags, append(baseArgs, "-update-wait-timeout", "2m"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.updateWaitInterval != 2*time.Second {
+		t.Errorf("Expected updateWaitInterval to be 2s, got %v", cfg.updateWaitInterval)
+	}
+	if cfg.updateWaitBackoff != 1.5 {
+		t.Errorf("Expected updateWaitBackoff to be 1.5, got %v", cfg.updateWaitBackoff)
+	}
+	if cfg.updateWaitTimeout != 2*time.Minute {
+		t.Errorf("Expected updateWaitTimeout to be 2m, got %v", cfg.updateWaitTimeout)
+	}
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := loadConfigWithFlags(flags, append(baseArgs, "-update-wait-backoff", "0.5")); err == nil {
+		t.Error("Expected error for backoff below 1, got nil")
+	}
+}
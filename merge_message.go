@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Supported merge methods
+const (
+	mergeMethodMerge  = "merge"
+	mergeMethodSquash = "squash"
+	mergeMethodRebase = "rebase"
+)
+
+// defaultMergeCommitMessage is used as the merge commit body when no body
+// template is configured
+const defaultMergeCommitMessage = "Auto-merge successful"
+
+var (
+	// Closing keywords GitHub recognizes, e.g. "Fixes #12" or "closes octo/repo#3"
+	linkedIssuePattern = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?):?\s+((?:[\w.-]+/[\w.-]+)?#\d+)\b`)
+	// Co-authored-by trailers already present in commit messages
+	coAuthorTrailerPattern = regexp.MustCompile(`(?im)^co-authored-by:\s*(.+<[^>]+>)\s*$`)
+)
+
+// mergeTemplateData is what merge commit title and body templates are executed with
+type mergeTemplateData struct {
+	Number       int
+	Title        string
+	Body         string
+	Author       string
+	URL          string
+	BaseRef      string
+	HeadRef      string
+	Labels       []string
+	LinkedIssues []string // Issues closed by the PR, e.g. "#12" or "octo/repo#3"
+	Commits      []mergeCommitData
+	CoAuthors    []string // "Name <email>" of commit authors other than the PR author
+}
+
+type mergeCommitData struct {
+	SHA         string
+	Subject     string // First line of the message
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	AuthorLogin string
+}
+
+var mergeTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// parseMergeTemplate parses a merge commit title or body template. It
+// returns nil for an empty template.
+func parseMergeTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Funcs(mergeTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// linkedIssues returns the issues the PR body says it closes, in order of appearance
+func linkedIssues(body string) []string {
+	var issues []string
+	seen := make(map[string]bool)
+	for _, m := range linkedIssuePattern.FindAllStringSubmatch(body, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			issues = append(issues, m[1])
+		}
+	}
+	return issues
+}
+
+// coAuthors returns the distinct authors of the commits, and the co-authors
+// they credit, except the PR author
+func coAuthors(commits []mergeCommitData, prAuthor string) []string {
+	var authors []string
+	seen := make(map[string]bool)
+	add := func(author, email string) {
+		key := strings.ToLower(email)
+		if email == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		authors = append(authors, author)
+	}
+	for _, c := range commits {
+		if c.AuthorLogin != prAuthor {
+			add(fmt.Sprintf("%s <%s>", c.AuthorName, c.AuthorEmail), c.AuthorEmail)
+		}
+		for _, m := range coAuthorTrailerPattern.FindAllStringSubmatch(c.Message, -1) {
+			trailer := strings.TrimSpace(m[1])
+			add(trailer, trailer[strings.LastIndex(trailer, "<")+1:len(trailer)-1])
+		}
+	}
+	return authors
+}
+
+// listMergeCommits returns the commits of the PR, oldest first
+func (p *PRProcessor) listMergeCommits(pr *github.PullRequest) ([]mergeCommitData, error) {
+	var commits []mergeCommitData
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := p.client.PullRequests.ListCommits(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing commits: %v", err)
+		}
+		for _, c := range page {
+			message := c.GetCommit().GetMessage()
+			subject, _, _ := strings.Cut(message, "\n")
+			commits = append(commits, mergeCommitData{
+				SHA:         c.GetSHA(),
+				Subject:     subject,
+				Message:     message,
+				AuthorName:  c.GetCommit().GetAuthor().GetName(),
+				AuthorEmail: c.GetCommit().GetAuthor().GetEmail(),
+				AuthorLogin: c.GetAuthor().GetLogin(),
+			})
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return commits, nil
+}
+
+// mergeCommitMessage renders the title and body of the merge commit. An empty
+// title leaves the default title to GitHub.
+func (p *PRProcessor) mergeCommitMessage(pr *github.PullRequest, method string) (string, string, error) {
+	titleTmpl, bodyTmpl := p.cfg.mergeTitleTemplate, p.cfg.mergeBodyTemplate
+	coAuthored := p.cfg.coAuthoredBy && method == mergeMethodSquash
+	if titleTmpl == nil && bodyTmpl == nil && !coAuthored {
+		return "", defaultMergeCommitMessage, nil
+	}
+
+	commits, err := p.listMergeCommits(pr)
+	if err != nil {
+		return "", "", err
+	}
+	data := &mergeTemplateData{
+		Number:       pr.GetNumber(),
+		Title:        pr.GetTitle(),
+		Body:         pr.GetBody(),
+		Author:       pr.GetUser().GetLogin(),
+		URL:          pr.GetHTMLURL(),
+		BaseRef:      pr.GetBase().GetRef(),
+		HeadRef:      pr.GetHead().GetRef(),
+		LinkedIssues: linkedIssues(pr.GetBody()),
+		Commits:      commits,
+		CoAuthors:    coAuthors(commits, pr.GetUser().GetLogin()),
+	}
+	for _, label := range pr.Labels {
+		data.Labels = append(data.Labels, label.GetName())
+	}
+
+	var title string
+	if titleTmpl != nil {
+		var b strings.Builder
+		if err := titleTmpl.Execute(&b, data); err != nil {
+			return "", "", fmt.Errorf("error rendering merge commit title: %v", err)
+		}
+		title = strings.TrimSpace(b.String())
+	}
+
+	body := defaultMergeCommitMessage
+	if bodyTmpl != nil {
+		var b strings.Builder
+		if err := bodyTmpl.Execute(&b, data); err != nil {
+			return "", "", fmt.Errorf("error rendering merge commit message: %v", err)
+		}
+		body = strings.TrimSpace(b.String())
+	}
+
+	if coAuthored {
+		var trailers []string
+		for _, author := range data.CoAuthors {
+			// The template may already have listed them
+			if trailer := "Co-authored-by: " + author; !strings.Contains(body, trailer) {
+				trailers = append(trailers, trailer)
+			}
+		}
+		if len(trailers) > 0 {
+			if body != "" {
+				body += "\n\n"
+			}
+			body += strings.Join(trailers, "\n")
+		}
+	}
+	return title, body, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestLinkedIssues(t *testing.T) {
+	body := "Fixes #12 and closes octo/other#3.\nRelated to #4, resolves: #12"
+	expected := []string{"#12", "octo/other#3"}
+	if got := linkedIssues(body); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestCoAuthors(t *testing.T) {
+	commits := []mergeCommitData{
+		{AuthorName: "Alice", AuthorEmail: "alice@example.com", AuthorLogin: "alice"},
+		{AuthorName: "Bob", AuthorEmail: "bob@example.com", AuthorLogin: "bob"},
+		{AuthorName: "Bob", AuthorEmail: "BOB@example.com", AuthorLogin: "bob"},
+		{
+			AuthorName: "Alice", AuthorEmail: "alice@example.com", AuthorLogin: "alice",
+			Message: "Pair on it\n\nCo-authored-by: Carol <carol@example.com>",
+		},
+	}
+	expected := []string{"Bob <bob@example.com>", "Carol <carol@example.com>"}
+	if got := coAuthors(commits, "alice"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestParseMergeTemplate_Invalid(t *testing.T) {
+	if _, err := parseMergeTemplate("merge commit title", "{{.Title"); err == nil {
+		t.Error("Expected an error for an unterminated action")
+	}
+}
+
+func TestHandleSuccessfulPR_MergeCommitMessage(t *testing.T) {
+	testCases := []struct {
+		name            string
+		method          string
+		title           string
+		body            string
+		coAuthoredBy    bool
+		expectedTitle   string
+		expectedMessage string
+	}{
+		{
+			name:            "default message",
+			method:          mergeMethodMerge,
+			expectedMessage: defaultMergeCommitMessage,
+		},
+		{
+			name:            "templated squash with co-authors",
+			method:          mergeMethodSquash,
+			title:           "{{.Title}} (#{{.Number}})",
+			body:            "{{range .Commits}}* {{.Subject}}\n{{end}}{{with .LinkedIssues}}\nCloses {{join . \", \"}}{{end}}",
+			coAuthoredBy:    true,
+			expectedTitle:   "Add feature (#1)",
+			expectedMessage: "* Add feature\n* Fix typo\n\nCloses #7\n\nCo-authored-by: Bob <bob@example.com>",
+		},
+		{
+			name:            "co-authors only on squash merges",
+			method:          mergeMethodMerge,
+			coAuthoredBy:    true,
+			expectedMessage: defaultMergeCommitMessage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var merge map[string]string
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.CombinedStatus{})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1/commits", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, []*github.RepositoryCommit{
+					{
+						SHA:    github.Ptr("c1"),
+						Author: &github.User{Login: github.Ptr("alice")},
+						Commit: &github.Commit{Message: github.Ptr("Add feature\n\nDetails"), Author: &github.CommitAuthor{Name: github.Ptr("Alice"), Email: github.Ptr("alice@example.com")}},
+					},
+					{
+						SHA:    github.Ptr("c2"),
+						Author: &github.User{Login: github.Ptr("bob")},
+						Commit: &github.Commit{Message: github.Ptr("Fix typo"), Author: &github.CommitAuthor{Name: github.Ptr("Bob"), Email: github.Ptr("bob@example.com")}},
+					},
+				})
+			})
+			mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&merge); err != nil {
+					t.Errorf("Failed to decode merge request: %v", err)
+				}
+				writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+			})
+
+			titleTmpl, err := parseMergeTemplate("merge commit title", tc.title)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			bodyTmpl, err := parseMergeTemplate("merge commit message", tc.body)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: &handlerTransport{handler: mux}}),
+				cfg: &config{
+					owner:              testOwner,
+					repo:               testRepo,
+					mergeMethod:        tc.method,
+					mergeTitleTemplate: titleTmpl,
+					mergeBodyTemplate:  bodyTmpl,
+					coAuthoredBy:       tc.coAuthoredBy,
+				},
+				ctx: context.Background(),
+			}
+			pr := &github.PullRequest{
+				Number: github.Ptr(1),
+				Title:  github.Ptr("Add feature"),
+				Body:   github.Ptr("Fixes #7"),
+				User:   &github.User{Login: github.Ptr("alice")},
+				Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+			}
+			if err := processor.handleSuccessfulPR(pr); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if merge["merge_method"] != tc.method || merge["commit_title"] != tc.expectedTitle || merge["commit_message"] != tc.expectedMessage {
+				t.Errorf("Expected %s merge titled %q with message %q, got %v", tc.method, tc.expectedTitle, tc.expectedMessage, merge)
+			}
+		})
+	}
+}
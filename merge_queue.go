@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// labelRank returns the position of the first priority label the PR carries,
+// or len(labels) if it has none
+func labelRank(pr *github.PullRequest, labels []string) int {
+	for i, label := range labels {
+		if hasLabel(pr, label) {
+			return i
+		}
+	}
+	return len(labels)
+}
+
+// sortMergeCandidates orders PRs by priority label, then age (oldest
+// first), then size (smallest first)
+func sortMergeCandidates(prs []*github.PullRequest, priorityLabels []string) {
+	sort.SliceStable(prs, func(i, j int) bool {
+		a, b := prs[i], prs[j]
+		if ra, rb := labelRank(a, priorityLabels), labelRank(b, priorityLabels); ra != rb {
+			return ra < rb
+		}
+		if ca, cb := a.GetCreatedAt().Time, b.GetCreatedAt().Time; !ca.Equal(cb) {
+			return ca.Before(cb)
+		}
+		return a.GetAdditions()+a.GetDeletions() < b.GetAdditions()+b.GetDeletions()
+	})
+}
+
+// queuesMerges reports whether green PRs are queued and merged after all PRs
+// were checked
+func (p *PRProcessor) queuesMerges() bool {
+	return p.cfg.serialMerge || p.cfg.mergeTrain
+}
+
+// processMergeQueue merges the PRs queued during the run, one base branch
+// per goroutine and one PR at a time per base branch
+func (p *PRProcessor) processMergeQueue(prs []*github.PullRequest) error {
+	byBase := make(map[string][]*github.PullRequest)
+	var bases []string
+	for _, pr := range prs {
+		if !p.report(pr).queued {
+			continue
+		}
+		base := pr.GetBase().GetRef()
+		if _, ok := byBase[base]; !ok {
+			bases = append(bases, base)
+		}
+		byBase[base] = append(byBase[base], pr)
+	}
+
+	merger := p.withContext(p.ctx)
+	merger.merging = true
+
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(prs))
+	for _, base := range bases {
+		wg.Add(1)
+		go func(base string, candidates []*github.PullRequest) {
+			defer wg.Done()
+			proc, span := merger.startSpan("mergeQueue", attrBaseRef.String(base))
+			proc.mergeQueueForBase(base, candidates, errChan)
+			endSpan(span, nil)
+		}(base, byBase[base])
+	}
+	wg.Wait()
+	close(errChan)
+
+	var errors []error
+	for err := range errChan {
+		errors = append(errors, err)
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("merge queue: %d errors: %v", len(errors), errors)
+	}
+	return nil
+}
+
+// mergeQueueForBase merges the candidates in priority order. Once a
+// candidate has to be updated because an earlier merge moved the base
+// branch, the remaining candidates wait for the next run so that only one
+// branch at a time re-runs CI.
+func (p *PRProcessor) mergeQueueForBase(base string, candidates []*github.PullRequest, errChan chan<- error) {
+	// Refetch the candidates: the list endpoint lacks the diff size, and
+	// heads may have moved while the other PRs were processed
+	var fresh []*github.PullRequest
+	for _, pr := range candidates {
+		full, _, err := p.client.PullRequests.Get(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber())
+		if err != nil {
+			p.failQueued(pr, fmt.Errorf("error getting PR: %v", err), errChan)
+			continue
+		}
+		fresh = append(fresh, full)
+	}
+	sortMergeCandidates(fresh, splitList(p.cfg.priorityLabels))
+
+	order := make([]string, len(fresh))
+	for i, pr := range fresh {
+		order[i] = fmt.Sprintf("#%d", pr.GetNumber())
+	}
+	fmt.Printf("Merge queue for %s: %s\n", base, strings.Join(order, ", "))
+
+	if p.cfg.mergeTrain {
+		p.runMergeTrain(base, fresh, errChan)
+		return
+	}
+
+	for i, pr := range fresh {
+		proc, span := p.startPRSpan("mergeCandidate", pr)
+		err := proc.mergeCandidate(pr)
+		endSpan(span, err)
+		if err != nil {
+			p.failQueued(pr, err, errChan)
+			continue
+		}
+		p.finishPR(pr)
+
+		// A branch being updated holds up the queue until its checks pass
+		if p.report(pr).outcome == outcomeUpdated {
+			for _, waiting := range fresh[i+1:] {
+				fmt.Printf("PR #%d: Waiting in the merge queue behind #%d\n", waiting.GetNumber(), pr.GetNumber())
+				p.report(waiting).decide(outcomePending, fmt.Sprintf("queued for merge behind #%d", pr.GetNumber()))
+				p.finishPR(waiting)
+			}
+			return
+		}
+	}
+}
+
+// mergeCandidate brings the candidate up to date with its base branch if an
+// earlier merge moved it, and merges it if it is up to date and still green.
+// A head pushed to since the policy was evaluated is evaluated again.
+func (p *PRProcessor) mergeCandidate(pr *github.PullRequest) error {
+	r := p.report(pr)
+	if checked := r.policySHA; checked != "" && checked != pr.GetHead().GetSHA() {
+		fmt.Printf("PR #%d: Head moved from %s since the policy check, evaluating it again\n", pr.GetNumber(), checked)
+		if skip, err := p.shouldSkipPR(pr); err != nil || skip {
+			return err
+		}
+	}
+	r.behindBy = 0
+	if p.cfg.autoRebase {
+		if err := p.tryRebasePR(pr); err != nil {
+			return err
+		}
+		if r.outcome == outcomeUpdated {
+			fmt.Printf("PR #%d: Updated after earlier merges, will be merged once checks pass\n", pr.GetNumber())
+			return nil
+		}
+	}
+	return p.handleSuccessfulPR(pr)
+}
+
+func (p *PRProcessor) failQueued(pr *github.PullRequest, err error, errChan chan<- error) {
+	log.Printf("Error merging PR #%d: %v", pr.GetNumber(), err)
+	errChan <- fmt.Errorf("PR #%d: %w", pr.GetNumber(), err)
+	if r := p.report(pr); r.outcome != outcomeConflict {
+		r.decide(outcomeError, err.Error())
+	}
+	p.finishPR(pr)
+}
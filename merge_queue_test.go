@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestSortMergeCandidates(t *testing.T) {
+	now := time.Now()
+	pr := func(number int, label string, age time.Duration, size int) *github.PullRequest {
+		p := &github.PullRequest{
+			Number:    github.Ptr(number),
+			CreatedAt: &github.Timestamp{Time: now.Add(-age)},
+			Additions: github.Ptr(size),
+		}
+		if label != "" {
+			p.Labels = []*github.Label{{Name: github.Ptr(label)}}
+		}
+		return p
+	}
+	prs := []*github.PullRequest{
+		pr(1, "", time.Hour, 10),
+		pr(2, "", 2*time.Hour, 10),
+		pr(3, "urgent", time.Minute, 500),
+		pr(4, "hotfix", time.Minute, 10),
+		pr(5, "", time.Hour, 5),
+	}
+	sortMergeCandidates(prs, []string{"hotfix", "urgent"})
+
+	var order []string
+	for _, p := range prs {
+		order = append(order, strconv.Itoa(p.GetNumber()))
+	}
+	if got := strings.Join(order, ","); got != "4,3,2,5,1" {
+		t.Errorf("Expected order 4,3,2,5,1, got %s", got)
+	}
+}
+
+func TestProcessPullRequests_SerialMerge(t *testing.T) {
+	created := time.Now().Add(-24 * time.Hour)
+	prs := []*github.PullRequest{
+		{Number: github.Ptr(1), CreatedAt: &github.Timestamp{Time: created}},
+		{Number: github.Ptr(2), CreatedAt: &github.Timestamp{Time: created.Add(time.Hour)}, Labels: []*github.Label{{Name: github.Ptr("urgent")}}},
+		{Number: github.Ptr(3), CreatedAt: &github.Timestamp{Time: created.Add(2 * time.Hour)}},
+	}
+	for _, pr := range prs {
+		pr.Title = github.Ptr(fmt.Sprintf("PR %d", pr.GetNumber()))
+		pr.Head = &github.PullRequestBranch{SHA: github.Ptr(fmt.Sprintf("head-%d", pr.GetNumber()))}
+		pr.Base = &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")}
+	}
+
+	// Every merge moves main, leaving the other PRs behind
+	var mu sync.Mutex
+	merges := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, prs)
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/{number}", func(w http.ResponseWriter, r *http.Request) {
+		number, _ := strconv.Atoi(r.PathValue("number"))
+		writeJSON(t, w, prs[number-1])
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr("success"), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/{spec}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Only the live base branch is behind, the base SHA of the PRs is
+		// where they branched off
+		base, head, _ := strings.Cut(r.PathValue("spec"), "...")
+		if !strings.HasPrefix(head, "head-") {
+			t.Errorf("Expected a PR head to be compared, got %q", head)
+		}
+		behindBy := 0
+		if base == "main" {
+			behindBy = merges
+		}
+		writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(behindBy)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/{number}/update-branch", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestBranchUpdateResponse{Message: github.Ptr("Updated.")})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/{number}/merge", func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		merges++
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg: &config{
+			owner:          testOwner,
+			repo:           testRepo,
+			autoRebase:     true,
+			updateStrategy: updateStrategyMerge,
+			serialMerge:    true,
+			priorityLabels: "urgent",
+		},
+		ctx: context.Background(),
+	}
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[int]prOutcome{2: outcomeMerged, 1: outcomeUpdated, 3: outcomePending}
+	for _, r := range processor.sortedReports() {
+		if r.outcome != expected[r.number] {
+			t.Errorf("PR #%d: expected %s, got %s (%s)", r.number, expected[r.number], r.outcome, r.reason)
+		}
+	}
+	if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/2/merge"); len(calls) != 1 {
+		t.Errorf("Expected the urgent PR to be merged once, got %d merges", len(calls))
+	}
+	for _, number := range []int{1, 3} {
+		if calls := transport.find("PUT", fmt.Sprintf("/repos/test-owner/test-repo/pulls/%d/merge", number)); len(calls) != 0 {
+			t.Errorf("Expected PR #%d not to be merged, got %d merges", number, len(calls))
+		}
+	}
+	if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/update-branch"); len(calls) != 1 {
+		t.Errorf("Expected only the next candidate to be updated, got %d updates of #1", len(calls))
+	}
+	if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/3/update-branch"); len(calls) != 0 {
+		t.Errorf("Expected PR #3 to wait without being updated, got %d updates", len(calls))
+	}
+}
+
+func TestProcessPullRequests_SerialMergeHeadMoved(t *testing.T) {
+	testCases := []struct {
+		name            string
+		movedTitle      string
+		expectedOutcome prOutcome
+	}{
+		{name: "policy still merges the new head", movedTitle: "PR 1", expectedOutcome: outcomeMerged},
+		{name: "policy skips the new head", movedTitle: "WIP: PR 1", expectedOutcome: outcomeSkipped},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			listed := &github.PullRequest{
+				Number: github.Ptr(1),
+				Title:  github.Ptr("PR 1"),
+				Head:   &github.PullRequestBranch{SHA: github.Ptr("head-1")},
+				Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+			}
+			// A commit is pushed between the check phase and the merge phase
+			moved := *listed
+			moved.Title = github.Ptr(tc.movedTitle)
+			moved.Head = &github.PullRequestBranch{SHA: github.Ptr("head-2")}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, []*github.PullRequest{listed})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &moved)
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+					{State: github.Ptr("success"), Context: github.Ptr("test-check")},
+				}})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/compare/{spec}", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(0)})
+			})
+			mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+			})
+			transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg: &config{
+					owner:          testOwner,
+					repo:           testRepo,
+					autoRebase:     true,
+					updateStrategy: updateStrategyMerge,
+					serialMerge:    true,
+					skipPattern:    "^WIP:",
+				},
+				ctx: context.Background(),
+			}
+			if err := processor.ProcessPullRequests(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			r := processor.report(listed)
+			if r.outcome != tc.expectedOutcome {
+				t.Errorf("Expected %s, got %s (%s)", tc.expectedOutcome, r.outcome, r.reason)
+			}
+			if r.policySHA != "head-2" {
+				t.Errorf("Expected the policy to be evaluated at the new head, got %q", r.policySHA)
+			}
+			merges := transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge")
+			if tc.expectedOutcome != outcomeMerged {
+				if len(merges) != 0 {
+					t.Errorf("Expected no merge, got %d", len(merges))
+				}
+				return
+			}
+			if len(merges) != 1 || !strings.Contains(merges[0].body, `"sha":"head-2"`) {
+				t.Errorf("Expected the checked head to be merged, got %v", merges)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Defaults for merge trains
+const (
+	defaultMergeTrainSize   = 5
+	defaultMergeTrainPrefix = "merge-train"
+)
+
+// stagingBranch is a batch of PRs merged on top of their base branch in a
+// temporary repository and pushed for CI
+type stagingBranch struct {
+	dir      string                // Temporary repository holding the staging commit
+	cloneURL string                // Repository the base and staging branches live in
+	branch   string                // Name of the pushed staging branch
+	sha      string                // Staging commit
+	prs      []*github.PullRequest // PRs merged into the staging commit, in order
+}
+
+// stagingBranchName returns the staging branch used for trains into base
+func (p *PRProcessor) stagingBranchName(base string) string {
+	prefix := strings.Trim(p.cfg.mergeTrainPrefix, "/")
+	if prefix == "" {
+		prefix = defaultMergeTrainPrefix
+	}
+	return prefix + "/" + base
+}
+
+// prNumbers formats PR numbers as "#1, #2"
+func prNumbers(prs []*github.PullRequest) string {
+	numbers := make([]string, len(prs))
+	for i, pr := range prs {
+		numbers[i] = fmt.Sprintf("#%d", pr.GetNumber())
+	}
+	return strings.Join(numbers, ", ")
+}
+
+// runMergeTrain tests the first candidates together on a staging branch and
+// fast-forwards the base branch to it if its checks pass. A failing train is
+// split in halves which are tested on their own until the PRs breaking it are
+// found. Candidates beyond the train size wait for the next run.
+func (p *PRProcessor) runMergeTrain(base string, candidates []*github.PullRequest, errChan chan<- error) {
+	size := p.cfg.mergeTrainSize
+	if size <= 0 {
+		size = defaultMergeTrainSize
+	}
+	train := candidates
+	if len(train) > size {
+		train = candidates[:size]
+		for _, waiting := range candidates[size:] {
+			fmt.Printf("PR #%d: Waiting for the next merge train\n", waiting.GetNumber())
+			p.report(waiting).decide(outcomePending, "queued for the next merge train")
+			p.finishPR(waiting)
+		}
+	}
+
+	fmt.Printf("Merge train for %s: %s\n", base, prNumbers(train))
+	// Landing pushes straight to the base branch, which its rules may reject
+	blocked, err := p.trainBaseBlocked(base)
+	if err == nil && len(blocked) > 0 {
+		err = fmt.Errorf("%s does not accept the fast-forward push a merge train lands with, it %s", base, strings.Join(blocked, ", "))
+	}
+	if err != nil {
+		p.failTrain(train, err, errChan)
+		for _, pr := range train {
+			p.finishPR(pr)
+		}
+		return
+	}
+
+	if p.cfg.dryRun {
+		for _, pr := range train {
+			p.dryRun(pr, "test and merge in a merge train with %s", prNumbers(train))
+			r := p.report(pr)
+			r.failedChecks, r.pendingChecks = nil, nil
+			r.decide(outcomeMerged, "all status checks passed (dry run)")
+			p.finishPR(pr)
+		}
+		return
+	}
+
+	var staged string
+	p.runTrainBatch(base, train, &staged, errChan)
+	p.deleteStagingBranch(base, staged)
+	for _, pr := range train {
+		p.finishPR(pr)
+	}
+}
+
+// trainBaseBlocked lists the rules of the base branch that reject pushing a
+// merge train to it: branch protection and rulesets requiring reviews,
+// checks or a linear history, or restricting who can update it
+func (p *PRProcessor) trainBaseBlocked(base string) ([]string, error) {
+	var blocked []string
+	branch, _, err := p.client.Repositories.GetBranch(p.ctx, p.cfg.owner, p.cfg.repo, base, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error getting branch %s: %v", base, err)
+	}
+	if branch.GetProtected() {
+		protection, _, err := p.client.Repositories.GetBranchProtection(p.ctx, p.cfg.owner, p.cfg.repo, base)
+		switch {
+		case errors.Is(err, github.ErrBranchNotProtected):
+		case err != nil:
+			return nil, fmt.Errorf("error getting the protection of %s, needed to check that a merge train may push to it: %v", base, err)
+		default:
+			if protection.RequiredPullRequestReviews != nil {
+				blocked = append(blocked, "requires pull request reviews")
+			}
+			if protection.RequiredStatusChecks != nil {
+				blocked = append(blocked, "requires status checks")
+			}
+			if linear := protection.GetRequireLinearHistory(); linear != nil && linear.Enabled {
+				blocked = append(blocked, "requires a linear history")
+			}
+			if protection.Restrictions != nil {
+				blocked = append(blocked, "restricts who can push")
+			}
+			if protection.GetLockBranch().GetEnabled() {
+				blocked = append(blocked, "is locked")
+			}
+		}
+	}
+
+	rules, _, err := p.client.Repositories.GetRulesForBranch(p.ctx, p.cfg.owner, p.cfg.repo, base)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the rules of %s: %v", base, err)
+	}
+	if rules != nil {
+		if len(rules.PullRequest) > 0 {
+			blocked = append(blocked, "requires pull requests by a ruleset")
+		}
+		if len(rules.RequiredStatusChecks) > 0 {
+			blocked = append(blocked, "requires status checks by a ruleset")
+		}
+		if len(rules.RequiredLinearHistory) > 0 {
+			blocked = append(blocked, "requires a linear history by a ruleset")
+		}
+		if len(rules.MergeQueue) > 0 {
+			blocked = append(blocked, "requires a merge queue by a ruleset")
+		}
+		if len(rules.Update) > 0 {
+			blocked = append(blocked, "restricts updates by a ruleset")
+		}
+	}
+	return blocked, nil
+}
+
+// runTrainBatch tests a batch on the staging branch, lands it if it passes
+// and bisects it if it fails. staged is the commit this train last pushed to
+// the staging branch.
+func (p *PRProcessor) runTrainBatch(base string, prs []*github.PullRequest, staged *string, errChan chan<- error) {
+	proc, span := p.startSpan("mergeTrainBatch", attrBaseRef.String(base))
+	stage, err := proc.buildStagingBranch(base, prs, staged)
+	if err != nil {
+		endSpan(span, err)
+		p.failTrain(prs, err, errChan)
+		return
+	}
+	if stage == nil {
+		endSpan(span, nil)
+		return
+	}
+	defer func() { _ = os.RemoveAll(stage.dir) }()
+
+	failedStatuses, pendingStatuses, err := proc.waitForStagingChecks(stage)
+	if err == nil && len(failedStatuses) == 0 && len(pendingStatuses) == 0 {
+		err = proc.landStagingBranch(base, stage)
+	}
+	endSpan(span, err)
+	if err != nil {
+		p.failTrain(stage.prs, err, errChan)
+		return
+	}
+
+	switch {
+	case len(failedStatuses) > 0 && len(stage.prs) == 1:
+		pr := stage.prs[0]
+		fmt.Printf("PR #%d: Breaks the merge train: %s\n", pr.GetNumber(), strings.Join(failedStatuses, ", "))
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = failedStatuses, nil
+		r.decide(outcomeBlocked, "status checks failed in the merge train")
+	case len(failedStatuses) > 0:
+		half := len(stage.prs) / 2
+		fmt.Printf("Merge train for %s failed, bisecting: %s | %s\n", base, prNumbers(stage.prs[:half]), prNumbers(stage.prs[half:]))
+		p.runTrainBatch(base, stage.prs[:half], staged, errChan)
+		p.runTrainBatch(base, stage.prs[half:], staged, errChan)
+	case len(pendingStatuses) > 0:
+		for _, pr := range stage.prs {
+			r := p.report(pr)
+			r.failedChecks, r.pendingChecks = nil, pendingStatuses
+			r.decide(outcomePending, "merge train checks still pending")
+		}
+	}
+}
+
+// buildStagingBranch merges the PRs one by one on top of the base branch in
+// a temporary repository and force-pushes the result to the staging branch.
+// PRs that conflict or whose head moved are left out. It returns nil if no PR
+// could be staged.
+//
+// The push only replaces the staging branch if it still is at the commit
+// read before building, and fails once it is not at the commit this train
+// pushed last, so that concurrent trains into the same base don't replace
+// each other's staging branch while its checks run.
+func (p *PRProcessor) buildStagingBranch(base string, prs []*github.PullRequest, staged *string) (*stagingBranch, error) {
+	cloneURL := prs[0].GetBase().GetRepo().GetCloneURL()
+	if cloneURL == "" {
+		return nil, fmt.Errorf("missing repository information for the merge train")
+	}
+
+	dir, err := os.MkdirTemp("", "pr-status-checker-train-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	stage := &stagingBranch{dir: dir, cloneURL: cloneURL, branch: p.stagingBranchName(base)}
+	keep := false
+	defer func() {
+		if !keep {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	auth := p.gitAuthEnv()
+	if _, err := runGit(dir, "init", "--quiet"); err != nil {
+		return nil, err
+	}
+	lease, err := runGitEnv(dir, auth, "ls-remote", cloneURL, "refs/heads/"+stage.branch)
+	if err != nil {
+		return nil, fmt.Errorf("error reading staging branch %s: %v", stage.branch, err)
+	}
+	lease, _, _ = strings.Cut(lease, "\t")
+	if lease != *staged {
+		if lease == "" {
+			return nil, fmt.Errorf("staging branch %s was deleted by another instance", stage.branch)
+		}
+		return nil, fmt.Errorf("staging branch %s is at %s, it is used by another merge train or was left over; delete it once no other train runs", stage.branch, lease)
+	}
+	fetch := []string{"fetch", "--quiet", "--no-tags", cloneURL, "+refs/heads/" + base + ":refs/remotes/base/" + base}
+	for _, pr := range prs {
+		fetch = append(fetch, fmt.Sprintf("+refs/pull/%d/head:refs/remotes/pull/%d", pr.GetNumber(), pr.GetNumber()))
+	}
+	if _, err := runGitEnv(dir, auth, fetch...); err != nil {
+		return nil, fmt.Errorf("error fetching merge train branches: %v", err)
+	}
+	if _, err := runGit(dir, "checkout", "--quiet", "-B", "staging", "refs/remotes/base/"+base); err != nil {
+		return nil, err
+	}
+
+	for _, pr := range prs {
+		ref := fmt.Sprintf("refs/remotes/pull/%d", pr.GetNumber())
+		r := p.report(pr)
+		if head, err := runGit(dir, "rev-parse", ref); err != nil {
+			return nil, err
+		} else if head != pr.GetHead().GetSHA() {
+			fmt.Printf("PR #%d: Head moved to %s, leaving it out of the merge train\n", pr.GetNumber(), head)
+			r.decide(outcomePending, "head changed while the merge train was built")
+			continue
+		}
+
+		message, err := p.stagingCommitMessage(pr)
+		if err != nil {
+			return nil, err
+		}
+		_, err = runGit(dir, "-c", "user.name="+rebaseCommitterName, "-c", "user.email="+rebaseCommitterEmail,
+			"merge", "--quiet", "--no-ff", "-m", message, ref)
+		if err != nil {
+			conflicts, _ := runGit(dir, "diff", "--name-only", "--diff-filter=U")
+			_, _ = runGit(dir, "merge", "--abort")
+			if conflicts == "" {
+				return nil, fmt.Errorf("PR #%d: error merging into the merge train: %v", pr.GetNumber(), err)
+			}
+			reason := "merge into " + base + " hit conflicts"
+			if len(stage.prs) > 0 {
+				reason = "conflicts with " + prNumbers(stage.prs) + " in the merge train"
+			}
+			fmt.Printf("PR #%d: Leaving it out of the merge train, %s\n", pr.GetNumber(), reason)
+			r.decide(outcomeConflict, reason)
+			r.conflictFiles = strings.Split(conflicts, "\n")
+			continue
+		}
+		stage.prs = append(stage.prs, pr)
+	}
+	if len(stage.prs) == 0 {
+		return nil, nil
+	}
+
+	if stage.sha, err = runGit(dir, "rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+	_, err = runGitEnv(dir, auth, "push", "--quiet", "--force-with-lease=refs/heads/"+stage.branch+":"+lease, cloneURL, "HEAD:refs/heads/"+stage.branch)
+	if err != nil {
+		return nil, fmt.Errorf("error pushing staging branch, it may have been changed by another merge train: %v", err)
+	}
+	*staged = stage.sha
+	fmt.Printf("Pushed staging branch %s at %s with %s\n", stage.branch, stage.sha, prNumbers(stage.prs))
+	keep = true
+	return stage, nil
+}
+
+// deleteStagingBranch deletes the staging branch after a train unless
+// another instance changed it since this train last pushed it
+func (p *PRProcessor) deleteStagingBranch(base, staged string) {
+	if staged == "" {
+		return
+	}
+	branch := p.stagingBranchName(base)
+	ref, _, err := p.client.Git.GetRef(p.ctx, p.cfg.owner, p.cfg.repo, "heads/"+branch)
+	if err != nil {
+		log.Printf("Error getting staging branch %s: %v", branch, err)
+		return
+	}
+	if sha := ref.GetObject().GetSHA(); sha != staged {
+		log.Printf("Leaving staging branch %s at %s, it was changed by another instance", branch, sha)
+		return
+	}
+	if _, err := p.client.Git.DeleteRef(p.ctx, p.cfg.owner, p.cfg.repo, "heads/"+branch); err != nil {
+		log.Printf("Error deleting staging branch %s: %v", branch, err)
+	}
+}
+
+// stagingCommitMessage returns the message of the merge commit adding the PR
+// to the staging branch. The commit lands on the base branch as is.
+func (p *PRProcessor) stagingCommitMessage(pr *github.PullRequest) (string, error) {
+	title, body, err := p.mergeCommitMessage(pr, mergeMethodMerge)
+	if err != nil {
+		return "", err
+	}
+	if title == "" {
+		title = fmt.Sprintf("Merge pull request #%d from %s", pr.GetNumber(), pr.GetHead().GetLabel())
+	}
+	if body == "" {
+		return title, nil
+	}
+	return title + "\n\n" + body, nil
+}
+
+// waitForStagingChecks polls the checks of the staging commit until they are
+// reported and none are pending, one fails or the wait deadline passes. A
+// commit without any checks is never considered green.
+func (p *PRProcessor) waitForStagingChecks(stage *stagingBranch) ([]string, []string, error) {
+	interval := p.cfg.checksWaitInterval
+	if interval <= 0 {
+		interval = defaultChecksWaitInterval
+	}
+	timeout := p.cfg.checksWaitTimeout
+	if timeout <= 0 {
+		timeout = defaultChecksWaitTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	fmt.Printf("Waiting up to %v for the checks of staging branch %s\n", timeout, stage.branch)
+	for {
+		wait := interval
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		if err := sleepContext(p.ctx, wait); err != nil {
+			return nil, nil, fmt.Errorf("waiting for merge train checks cancelled: %w", err)
+		}
+
+		failedStatuses, pendingStatuses, total, err := p.checkCommit(stage.sha)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(failedStatuses) > 0 || (total > 0 && len(pendingStatuses) == 0) {
+			fmt.Printf("Staging branch %s: Checks settled\n", stage.branch)
+			return failedStatuses, pendingStatuses, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			if total == 0 {
+				return nil, nil, fmt.Errorf("no checks reported for staging branch %s after %v", stage.branch, timeout)
+			}
+			fmt.Printf("Staging branch %s: Checks still pending after %v\n", stage.branch, timeout)
+			return nil, pendingStatuses, nil
+		}
+	}
+}
+
+// landStagingBranch approves the PRs of a green staging branch if configured
+// and fast-forwards the base branch to it, which GitHub records as merging
+// them. The push is rejected if the base branch moved in the meantime.
+func (p *PRProcessor) landStagingBranch(base string, stage *stagingBranch) error {
+	if w := p.freeze.active(time.Now()); w != nil {
+		p.freezeTrain(stage.prs, w)
+		return nil
+	}
+
+	for _, pr := range stage.prs {
+		if err := p.approvePR(pr, p.cfg.approve); err != nil {
+			return fmt.Errorf("PR #%d: %w", pr.GetNumber(), err)
+		}
+	}
+
+	_, err := runGitEnv(stage.dir, p.gitAuthEnv(), "push", "--quiet", stage.cloneURL, stage.sha+":refs/heads/"+base)
+	if err != nil {
+		return fmt.Errorf("error fast-forwarding %s to the merge train: %v", base, err)
+	}
+	fmt.Printf("Merge train for %s: Fast-forwarded to %s, merging %s\n", base, stage.sha, prNumbers(stage.prs))
+
+	for _, pr := range stage.prs {
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = nil, nil
+		r.decide(outcomeMerged, "merged in a merge train with "+prNumbers(stage.prs))
+		p.metrics.observeMerged(pr)
+		if p.cfg.deleteBranch {
+			p.deleteHeadBranch(pr)
+		}
+	}
+	return nil
+}
+
+// freezeTrain reports the PRs of a green batch that a merge freeze started
+// during the train keeps from landing. PRs are approved if the freeze only
+// allows approvals.
+func (p *PRProcessor) freezeTrain(prs []*github.PullRequest, w *freezeWindow) {
+	for _, pr := range prs {
+		frozen := p.applyFreezeAtMerge(pr, w)
+		if frozen == nil {
+			continue
+		}
+		r := p.report(pr)
+		r.failedChecks, r.pendingChecks = nil, nil
+		if frozen.action == policySkip {
+			r.skip(frozen.skipCode, frozen.reason, frozen.inScope)
+			continue
+		}
+		if err := p.approvePR(pr, true); err != nil {
+			log.Printf("Error approving PR #%d: %v", pr.GetNumber(), err)
+		}
+		r.decide(outcomeApproved, frozen.reason+" only allows approval")
+	}
+}
+
+// failTrain reports an error shared by the PRs of a batch
+func (p *PRProcessor) failTrain(prs []*github.PullRequest, err error, errChan chan<- error) {
+	log.Printf("Error running merge train %s: %v", prNumbers(prs), err)
+	errChan <- fmt.Errorf("merge train %s: %w", prNumbers(prs), err)
+	for _, pr := range prs {
+		if r := p.report(pr); r.outcome != outcomeConflict {
+			r.decide(outcomeError, err.Error())
+		}
+	}
+}
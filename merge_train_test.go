@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// setupMergeTrainRepos creates a bare "remote" repository with a main branch
+// and one PR branch per file, published as refs/pull/N/head like GitHub
+// does. PR N adds or changes files[N-1]. It returns the path of the bare
+// repository and the PRs.
+func setupMergeTrainRepos(t *testing.T, files ...string) (string, []*github.PullRequest) {
+	t.Helper()
+	t.Setenv("GIT_AUTHOR_NAME", "Test Author")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test Author")
+	t.Setenv("GIT_COMMITTER_EMAIL", "author@example.com")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	work := filepath.Join(root, "work")
+
+	mustGit := func(dir string, args ...string) string {
+		t.Helper()
+		output, err := runGit(dir, args...)
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return output
+	}
+
+	mustGit(root, "init", "--quiet", "--bare", "-b", "main", remote)
+	mustGit(root, "clone", "--quiet", remote, work)
+	mustGit(work, "checkout", "--quiet", "-b", "main")
+	if err := os.WriteFile(filepath.Join(work, "README.md"), []byte("base\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write README.md: %v", err)
+	}
+	mustGit(work, "add", ".")
+	mustGit(work, "commit", "--quiet", "-m", "initial")
+	mustGit(work, "push", "--quiet", "origin", "main")
+
+	repo := &github.Repository{FullName: github.Ptr("test-owner/test-repo"), CloneURL: github.Ptr(remote)}
+	var prs []*github.PullRequest
+	for i, file := range files {
+		number := i + 1
+		branch := fmt.Sprintf("feature-%d", number)
+		mustGit(work, "checkout", "--quiet", "-B", branch, "main")
+		content := fmt.Sprintf("change %d\n", number)
+		if err := os.WriteFile(filepath.Join(work, file), []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+		mustGit(work, "add", ".")
+		mustGit(work, "commit", "--quiet", "-m", "change "+file)
+		mustGit(work, "push", "--quiet", "origin", fmt.Sprintf("HEAD:refs/pull/%d/head", number))
+		prs = append(prs, &github.PullRequest{
+			Number:    github.Ptr(number),
+			Title:     github.Ptr(fmt.Sprintf("Change %d", number)),
+			CreatedAt: &github.Timestamp{Time: time.Now().Add(time.Duration(number-10) * time.Hour)},
+			Head: &github.PullRequestBranch{
+				Ref:   github.Ptr(branch),
+				SHA:   github.Ptr(mustGit(work, "rev-parse", "HEAD")),
+				Label: github.Ptr("test-owner:" + branch),
+				Repo:  repo,
+			},
+			Base: &github.PullRequestBranch{Ref: github.Ptr("main"), Repo: repo},
+		})
+	}
+	return remote, prs
+}
+
+// newMergeTrainTestProcessor serves checks failing for every commit whose
+// tree contains broken.txt
+func newMergeTrainTestProcessor(t *testing.T, remote string, size int) (*PRProcessor, *recordingTransport) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, r *http.Request) {
+		state := "success"
+		if _, err := runGit(remote, "cat-file", "-e", r.PathValue("sha")+":broken.txt"); err == nil {
+			state = "failure"
+		}
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr(state), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/{number}/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/git/ref/{ref...}", func(w http.ResponseWriter, r *http.Request) {
+		sha, err := runGit(remote, "rev-parse", "refs/"+r.PathValue("ref"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(t, w, &github.Reference{Object: &github.GitObject{SHA: github.Ptr(sha)}})
+	})
+	mux.HandleFunc("DELETE /repos/test-owner/test-repo/git/refs/{ref...}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	registerBranchRules(t, mux, nil, nil)
+	transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+	return &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg: &config{
+			owner:              testOwner,
+			repo:               testRepo,
+			approve:            true,
+			mergeTrain:         true,
+			mergeTrainSize:     size,
+			mergeTrainPrefix:   defaultMergeTrainPrefix,
+			checksWaitInterval: time.Millisecond,
+			checksWaitTimeout:  time.Second,
+		},
+		ctx:     context.Background(),
+		reports: &prReports{},
+	}, transport
+}
+
+// registerBranchRules serves the protection and rulesets of the main branch,
+// unprotected and without rules if nil
+func registerBranchRules(t *testing.T, mux *http.ServeMux, protection *github.Protection, rules []map[string]interface{}) {
+	mux.HandleFunc("GET /repos/test-owner/test-repo/branches/main", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.Branch{Name: github.Ptr("main"), Protected: github.Ptr(protection != nil)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/branches/main/protection", func(w http.ResponseWriter, _ *http.Request) {
+		if protection == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Branch not protected"}`))
+			return
+		}
+		writeJSON(t, w, protection)
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/rules/branches/main", func(w http.ResponseWriter, _ *http.Request) {
+		if rules == nil {
+			rules = []map[string]interface{}{}
+		}
+		writeJSON(t, w, rules)
+	})
+}
+
+func TestRunMergeTrain_Bisect(t *testing.T) {
+	remote, prs := setupMergeTrainRepos(t, "one.txt", "broken.txt", "three.txt")
+	processor, transport := newMergeTrainTestProcessor(t, remote, 5)
+
+	errChan := make(chan error, len(prs))
+	processor.runMergeTrain("main", prs, errChan)
+	close(errChan)
+	for err := range errChan {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	expected := map[int]prOutcome{1: outcomeMerged, 2: outcomeBlocked, 3: outcomeMerged}
+	for _, pr := range prs {
+		if r := processor.report(pr); r.outcome != expected[pr.GetNumber()] {
+			t.Errorf("PR #%d: expected %s, got %s (%s)", pr.GetNumber(), expected[pr.GetNumber()], r.outcome, r.reason)
+		}
+	}
+	for _, pr := range prs {
+		_, err := runGit(remote, "merge-base", "--is-ancestor", pr.GetHead().GetSHA(), "refs/heads/main")
+		if landed := err == nil; landed != (pr.GetNumber() != 2) {
+			t.Errorf("PR #%d: expected landed on main %v, got %v", pr.GetNumber(), pr.GetNumber() != 2, landed)
+		}
+	}
+	if calls := transport.find("POST", "/repos/test-owner/test-repo/pulls/2/reviews"); len(calls) != 0 {
+		t.Errorf("Expected the breaking PR not to be approved, got %d reviews", len(calls))
+	}
+	if calls := transport.find("DELETE", "/repos/test-owner/test-repo/git/refs/heads/merge-train/main"); len(calls) != 1 {
+		t.Errorf("Expected the staging branch to be deleted once, got %d deletions", len(calls))
+	}
+}
+
+func TestRunMergeTrain_ConflictAndSize(t *testing.T) {
+	remote, prs := setupMergeTrainRepos(t, "one.txt", "one.txt", "three.txt", "four.txt")
+	processor, _ := newMergeTrainTestProcessor(t, remote, 3)
+
+	errChan := make(chan error, len(prs))
+	processor.runMergeTrain("main", prs, errChan)
+	close(errChan)
+	for err := range errChan {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	expected := map[int]prOutcome{1: outcomeMerged, 2: outcomeConflict, 3: outcomeMerged, 4: outcomePending}
+	for _, pr := range prs {
+		if r := processor.report(pr); r.outcome != expected[pr.GetNumber()] {
+			t.Errorf("PR #%d: expected %s, got %s (%s)", pr.GetNumber(), expected[pr.GetNumber()], r.outcome, r.reason)
+		}
+	}
+	if files := processor.report(prs[1]).conflictFiles; len(files) != 1 || files[0] != "one.txt" {
+		t.Errorf("Expected conflict in one.txt, got %v", files)
+	}
+}
+
+func TestRunMergeTrain_FreezeStartedDuringTrain(t *testing.T) {
+	remote, prs := setupMergeTrainRepos(t, "one.txt", "two.txt")
+	processor, transport := newMergeTrainTestProcessor(t, remote, 5)
+	processor.freeze = &freezeSchedule{windows: []*freezeWindow{{name: "incident", action: policySkip, loc: time.UTC}}}
+	before, err := runGit(remote, "rev-parse", "refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errChan := make(chan error, len(prs))
+	processor.runMergeTrain("main", prs, errChan)
+	close(errChan)
+	for err := range errChan {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	for _, pr := range prs {
+		if r := processor.report(pr); r.outcome != outcomeSkipped || r.skipCode != skipFreeze {
+			t.Errorf("PR #%d: expected to be skipped by the freeze, got %s (%s)", pr.GetNumber(), r.outcome, r.reason)
+		}
+	}
+	if after, _ := runGit(remote, "rev-parse", "refs/heads/main"); after != before {
+		t.Errorf("Expected main to stay at %s during the freeze, got %s", before, after)
+	}
+	if calls := transport.find("POST", "/repos/test-owner/test-repo/pulls/1/reviews"); len(calls) != 0 {
+		t.Errorf("Expected no approval during a skip freeze, got %d", len(calls))
+	}
+}
+
+func TestRunMergeTrain_BaseRejectsPush(t *testing.T) {
+	testCases := []struct {
+		name          string
+		protection    *github.Protection
+		rules         []map[string]interface{}
+		expectedError string
+	}{
+		{
+			name:          "required reviews",
+			protection:    &github.Protection{RequiredPullRequestReviews: &github.PullRequestReviewsEnforcement{RequiredApprovingReviewCount: 1}},
+			expectedError: "main does not accept the fast-forward push a merge train lands with, it requires pull request reviews",
+		},
+		{
+			name:          "required status checks",
+			protection:    &github.Protection{RequiredStatusChecks: &github.RequiredStatusChecks{Strict: true}},
+			expectedError: "it requires status checks",
+		},
+		{
+			name:          "ruleset requiring pull requests",
+			rules:         []map[string]interface{}{{"type": "pull_request", "ruleset_id": 1, "parameters": map[string]interface{}{}}},
+			expectedError: "it requires pull requests by a ruleset",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			remote, prs := setupMergeTrainRepos(t, "one.txt", "two.txt")
+			before, err := runGit(remote, "rev-parse", "refs/heads/main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mux := http.NewServeMux()
+			registerBranchRules(t, mux, tc.protection, tc.rules)
+			transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+			processor := &PRProcessor{
+				client:  github.NewClient(&http.Client{Transport: transport}),
+				cfg:     &config{owner: testOwner, repo: testRepo, approve: true, mergeTrain: true, mergeTrainPrefix: defaultMergeTrainPrefix},
+				ctx:     context.Background(),
+				reports: &prReports{},
+			}
+
+			errChan := make(chan error, len(prs))
+			processor.runMergeTrain("main", prs, errChan)
+			close(errChan)
+			err = <-errChan
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected an error containing %q, got %v", tc.expectedError, err)
+			}
+			for _, pr := range prs {
+				if r := processor.report(pr); r.outcome != outcomeError {
+					t.Errorf("PR #%d: expected an error, got %s (%s)", pr.GetNumber(), r.outcome, r.reason)
+				}
+			}
+			if after, _ := runGit(remote, "rev-parse", "refs/heads/main"); after != before {
+				t.Errorf("Expected main to stay at %s, got %s", before, after)
+			}
+			if _, err := runGit(remote, "rev-parse", "--verify", "--quiet", "refs/heads/merge-train/main"); err == nil {
+				t.Error("Expected no staging branch to be pushed")
+			}
+		})
+	}
+}
+
+func TestRunMergeTrain_StagingBranchInUse(t *testing.T) {
+	remote, prs := setupMergeTrainRepos(t, "one.txt")
+	processor, transport := newMergeTrainTestProcessor(t, remote, 5)
+	// Another instance's train is waiting for the checks of its staging branch
+	other := prs[0].GetHead().GetSHA()
+	if _, err := runGit(remote, "update-ref", "refs/heads/merge-train/main", other); err != nil {
+		t.Fatal(err)
+	}
+
+	errChan := make(chan error, len(prs))
+	processor.runMergeTrain("main", prs, errChan)
+	close(errChan)
+	if err := <-errChan; err == nil || !strings.Contains(err.Error(), "used by another merge train") {
+		t.Errorf("Expected the staging branch to be reported as in use, got %v", err)
+	}
+	if sha, _ := runGit(remote, "rev-parse", "refs/heads/merge-train/main"); sha != other {
+		t.Errorf("Expected the other staging branch to stay at %s, got %s", other, sha)
+	}
+	if calls := transport.find("DELETE", "/repos/test-owner/test-repo/git/refs/heads/merge-train/main"); len(calls) != 0 {
+		t.Errorf("Expected the other staging branch not to be deleted, got %d deletions", len(calls))
+	}
+}
+
+func TestLoadConfigMergeTrain(t *testing.T) {
+	args := []string{"-token", testToken, "-owner", testOwner, "-repo", testRepo, "-merge-train"}
+	if _, err := loadConfigWithFlags(flag.NewFlagSet("test", flag.ContinueOnError), args); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	args = append(args, "-merge-method", "squash")
+	if _, err := loadConfigWithFlags(flag.NewFlagSet("test", flag.ContinueOnError), args); err == nil || !strings.Contains(err.Error(), "merge method") {
+		t.Errorf("Expected the merge train to reject the squash method, got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	metricsNamespace = "pr_status_checker"
+	pushgatewayJob   = "pr_status_checker"
+)
+
+// metrics holds the Prometheus collectors of the checker. It uses its own
+// registry so that only our metrics are exposed and pushed. A nil *metrics is
+// valid and records nothing.
+type metrics struct {
+	registry *prometheus.Registry
+
+	runs         *prometheus.CounterVec
+	lastSuccess  prometheus.Gauge
+	processed    *prometheus.CounterVec
+	skipped      *prometheus.CounterVec
+	approved     prometheus.Counter
+	merged       prometheus.Counter
+	timeToMerge  prometheus.Histogram
+	apiDuration  *prometheus.HistogramVec
+	apiErrors    *prometheus.CounterVec
+	rateLimitRem *prometheus.GaugeVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "runs_total",
+			Help:      "Number of runs over the open pull requests, by result.",
+		}, []string{"result"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_successful_run_timestamp_seconds",
+			Help:      "Unix time of the last run that completed without errors.",
+		}),
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prs_processed_total",
+			Help:      "Number of pull requests processed, by outcome.",
+		}, []string{"outcome"}),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prs_skipped_total",
+			Help:      "Number of pull requests skipped, by reason.",
+		}, []string{"reason"}),
+		approved: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prs_approved_total",
+			Help:      "Number of pull requests approved.",
+		}),
+		merged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prs_merged_total",
+			Help:      "Number of pull requests merged.",
+		}),
+		timeToMerge: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "pr_time_to_merge_seconds",
+			Help:      "Time from pull request creation to merge.",
+			Buckets:   prometheus.ExponentialBuckets(15*60, 2, 12), // 15m to ~21d
+		}),
+		apiDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_api_request_duration_seconds",
+			Help:      "Latency of GitHub API requests, by endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "endpoint"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_api_errors_total",
+			Help:      "Number of failed GitHub API requests, by endpoint and status code.",
+		}, []string{"method", "endpoint", "code"}),
+		rateLimitRem: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_rate_limit_remaining",
+			Help:      "Remaining GitHub API requests in the current rate limit window, by resource.",
+		}, []string{"resource"}),
+	}
+	m.registry.MustRegister(m.runs, m.lastSuccess, m.processed, m.skipped, m.approved, m.merged,
+		m.timeToMerge, m.apiDuration, m.apiErrors, m.rateLimitRem)
+	return m
+}
+
+// observeRun records the result of a run and the outcome of every PR seen
+func (m *metrics) observeRun(reports []*prReport, err error) {
+	if m == nil {
+		return
+	}
+	for _, r := range reports {
+		m.processed.WithLabelValues(string(r.outcome)).Inc()
+		if r.outcome == outcomeSkipped {
+			m.skipped.WithLabelValues(r.skipCode).Inc()
+		}
+	}
+	if err != nil {
+		m.runs.WithLabelValues("error").Inc()
+		return
+	}
+	m.runs.WithLabelValues("success").Inc()
+	m.lastSuccess.SetToCurrentTime()
+}
+
+func (m *metrics) observeApproved() {
+	if m == nil {
+		return
+	}
+	m.approved.Inc()
+}
+
+func (m *metrics) observeMerged(pr *github.PullRequest) {
+	if m == nil {
+		return
+	}
+	m.merged.Inc()
+	if created := pr.GetCreatedAt().Time; !created.IsZero() {
+		m.timeToMerge.Observe(time.Since(created).Seconds())
+	}
+}
+
+// serve exposes the metrics on /metrics until ctx is done. The listener is
+// opened before returning so that a bad address is reported right away.
+func (m *metrics) serve(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+	fmt.Printf("Serving metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+// push sends the metrics to a Prometheus Pushgateway, replacing the ones
+// previously pushed for the repository
+func (m *metrics) push(ctx context.Context, url, repository string) error {
+	err := push.New(url, pushgatewayJob).
+		Gatherer(m.registry).
+		Grouping("repository", repository).
+		PushContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %v", url, err)
+	}
+	return nil
+}
+
+// metricsTransport records latency, errors and rate limits of GitHub API
+// requests
+type metricsTransport struct {
+	metrics *metrics
+	base    http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	endpoint := apiEndpoint(req.URL.Path)
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	t.metrics.apiDuration.WithLabelValues(req.Method, endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		t.metrics.apiErrors.WithLabelValues(req.Method, endpoint, "network").Inc()
+		return resp, err
+	}
+
+	if resp.StatusCode >= 400 {
+		t.metrics.apiErrors.WithLabelValues(req.Method, endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		resource := resp.Header.Get("X-RateLimit-Resource")
+		if resource == "" {
+			resource = "core"
+		}
+		t.metrics.rateLimitRem.WithLabelValues(resource).Set(float64(remaining))
+	}
+	return resp, nil
+}
+
+// apiRoutes are the GitHub API endpoints used by the tool. Placeholders
+// match one path segment, {number} and {id} only numeric ones, and a
+// trailing {name...} matches the rest of the path, e.g. branch names
+// containing slashes.
+var apiRoutes = []string{
+	"/user",
+	"/repos/{owner}/{repo}/pulls",
+	"/repos/{owner}/{repo}/pulls/{number}",
+	"/repos/{owner}/{repo}/pulls/{number}/commits",
+	"/repos/{owner}/{repo}/pulls/{number}/files",
+	"/repos/{owner}/{repo}/pulls/{number}/merge",
+	"/repos/{owner}/{repo}/pulls/{number}/reviews",
+	"/repos/{owner}/{repo}/pulls/{number}/update-branch",
+	"/repos/{owner}/{repo}/issues/{number}/comments",
+	"/repos/{owner}/{repo}/issues/{number}/labels",
+	"/repos/{owner}/{repo}/issues/{number}/labels/{name...}",
+	"/repos/{owner}/{repo}/issues/comments/{id}",
+	"/repos/{owner}/{repo}/commits/{sha}/check-runs",
+	"/repos/{owner}/{repo}/commits/{sha}/status",
+	"/repos/{owner}/{repo}/commits/{sha}/statuses",
+	"/repos/{owner}/{repo}/statuses/{sha}",
+	"/repos/{owner}/{repo}/compare/{basehead...}",
+	"/repos/{owner}/{repo}/branches/{branch...}",
+	"/repos/{owner}/{repo}/git/refs/{ref...}",
+	"/repos/{owner}/{repo}/actions/runs",
+	"/repos/{owner}/{repo}/actions/runs/{id}/jobs",
+	"/repos/{owner}/{repo}/actions/runs/{id}/rerun-failed-jobs",
+}
+
+var numericSegment = regexp.MustCompile(`^\d+$`)
+
+// apiEndpoint turns a request path into a low-cardinality endpoint label, the
+// route template it matches, e.g. /repos/o/r/pulls/12/reviews becomes
+// /repos/{owner}/{repo}/pulls/{number}/reviews. Paths of other endpoints are
+// labeled "other", so that no name, SHA or branch ends up in a label.
+func apiEndpoint(path string) string {
+	// GitHub Enterprise Server serves the API below /api/v3
+	prefix := ""
+	if strings.HasPrefix(path, "/api/v3/") {
+		prefix, path = "/api/v3", strings.TrimPrefix(path, "/api/v3")
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range apiRoutes {
+		if routeMatches(route, segments) {
+			return prefix + route
+		}
+	}
+	return "other"
+}
+
+// routeMatches reports whether the path segments match the route template
+func routeMatches(route string, segments []string) bool {
+	parts := strings.Split(strings.Trim(route, "/"), "/")
+	for i, part := range parts {
+		if strings.HasSuffix(part, "...}") {
+			return i < len(segments)
+		}
+		if i >= len(segments) || segments[i] == "" {
+			return false
+		}
+		switch {
+		case part == "{number}" || part == "{id}":
+			if !numericSegment.MatchString(segments[i]) {
+				return false
+			}
+		case strings.HasPrefix(part, "{"):
+		case part != segments[i]:
+			return false
+		}
+	}
+	return len(parts) == len(segments)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/user", "/user"},
+		{"/repos/octo/repo/pulls", "/repos/{owner}/{repo}/pulls"},
+		{"/repos/octo/repo/pulls/12/reviews", "/repos/{owner}/{repo}/pulls/{number}/reviews"},
+		{"/repos/octo/repo/commits/0123abc/status", "/repos/{owner}/{repo}/commits/{sha}/status"},
+		{"/repos/octo/repo/statuses/0123abc", "/repos/{owner}/{repo}/statuses/{sha}"},
+		{"/repos/octo/repo/compare/main...feature/login", "/repos/{owner}/{repo}/compare/{basehead...}"},
+		{"/repos/octo/repo/git/refs/heads/feature/login", "/repos/{owner}/{repo}/git/refs/{ref...}"},
+		{"/repos/octo/repo/branches/feature/login", "/repos/{owner}/{repo}/branches/{branch...}"},
+		{"/repos/octo/repo/issues/7/labels/needs rebase", "/repos/{owner}/{repo}/issues/{number}/labels/{name...}"},
+		{"/repos/octo/repo/issues/comments/99", "/repos/{owner}/{repo}/issues/comments/{id}"},
+		{"/repos/octo/repo/actions/runs/42/rerun-failed-jobs", "/repos/{owner}/{repo}/actions/runs/{id}/rerun-failed-jobs"},
+		{"/api/v3/repos/octo/repo/issues/7/comments", "/api/v3/repos/{owner}/{repo}/issues/{number}/comments"},
+		{"/repos/octo/repo/pulls/new-feature", "other"},
+		{"/repos/octo/repo/contents/secret/path.txt", "other"},
+		{"/orgs/octo/members", "other"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := apiEndpoint(tc.path); got != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestMetricsTransport(t *testing.T) {
+	m := newMetrics()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4321")
+		w.Header().Set("X-RateLimit-Resource", "core")
+		writeJSON(t, w, &github.PullRequest{Number: github.Ptr(1)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/2", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4320")
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+
+	client := github.NewClient(&http.Client{Transport: &metricsTransport{metrics: m, base: &handlerTransport{handler: mux}}})
+	if _, _, err := client.PullRequests.Get(context.Background(), testOwner, testRepo, 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, _, err := client.PullRequests.Get(context.Background(), testOwner, testRepo, 2); err == nil {
+		t.Fatal("Expected an error for the missing PR")
+	}
+
+	if got := testutil.CollectAndCount(m.apiDuration); got != 1 {
+		t.Errorf("Expected latency of 1 endpoint to be recorded, got %d", got)
+	}
+	if got := testutil.ToFloat64(m.apiErrors.WithLabelValues("GET", "/repos/{owner}/{repo}/pulls/{number}", "404")); got != 1 {
+		t.Errorf("Expected 1 error, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.rateLimitRem.WithLabelValues("core")); got != 4320 {
+		t.Errorf("Expected rate limit remaining 4320, got %v", got)
+	}
+}
+
+func TestMetricsTransport_NetworkError(t *testing.T) {
+	m := newMetrics()
+	transport := &metricsTransport{metrics: m, base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})}
+	req := httptest.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if got := testutil.ToFloat64(m.apiErrors.WithLabelValues("GET", "/user", "network")); got != 1 {
+		t.Errorf("Expected 1 network error, got %v", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestProcessPullRequests_Metrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.PullRequest{
+			{
+				Number:    github.Ptr(1),
+				Title:     github.Ptr("Ready PR"),
+				CreatedAt: &github.Timestamp{Time: time.Now().Add(-2 * time.Hour)},
+				User:      &github.User{Login: github.Ptr("test-user")},
+				Head:      &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+				Base:      &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+			},
+			{
+				Number: github.Ptr(2),
+				Title:  github.Ptr("WIP: not yet"),
+				User:   &github.User{Login: github.Ptr("test-user")},
+			},
+			{
+				Number: github.Ptr(3),
+				Title:  github.Ptr("Draft PR"),
+				Draft:  github.Ptr(true),
+			},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr("success"), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+
+	m := newMetrics()
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: &metricsTransport{metrics: m, base: &handlerTransport{handler: mux}}}),
+		cfg: &config{
+			owner:       testOwner,
+			repo:        testRepo,
+			approve:     true,
+			skipPattern: "^WIP:",
+		},
+		ctx:     context.Background(),
+		metrics: m,
+	}
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	got := map[string]float64{
+		"approved":              testutil.ToFloat64(m.approved),
+		"merged":                testutil.ToFloat64(m.merged),
+		"processed merged":      testutil.ToFloat64(m.processed.WithLabelValues("merged")),
+		"processed skipped":     testutil.ToFloat64(m.processed.WithLabelValues("skipped")),
+		"skipped draft":         testutil.ToFloat64(m.skipped.WithLabelValues(skipDraft)),
+		"skipped title pattern": testutil.ToFloat64(m.skipped.WithLabelValues(skipTitlePattern)),
+		"successful runs":       testutil.ToFloat64(m.runs.WithLabelValues("success")),
+	}
+	want := map[string]float64{
+		"approved":              1,
+		"merged":                1,
+		"processed merged":      1,
+		"processed skipped":     2,
+		"skipped draft":         1,
+		"skipped title pattern": 1,
+		"successful runs":       1,
+	}
+	for name, value := range got {
+		if value != want[name] {
+			t.Errorf("Expected %s to be %v, got %v", name, want[name], value)
+		}
+	}
+	if got := testutil.CollectAndCount(m.timeToMerge); got != 1 {
+		t.Errorf("Expected time to merge to be recorded, got %d series", got)
+	}
+
+	// The registry is what /metrics and the Pushgateway expose
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/metrics/job/pr_status_checker/repository") {
+			t.Errorf("Unexpected push request %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	if err := m.push(context.Background(), server.URL, testOwner+"/"+testRepo); err != nil {
+		t.Fatalf("Expected no error pushing metrics, got %v", err)
+	}
+}
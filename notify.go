@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// notifyEvent is something worth telling people about
+type notifyEvent string
+
+const (
+	eventMerged   notifyEvent = "merged"   // A PR was merged
+	eventConflict notifyEvent = "conflict" // Updating a PR branch hit conflicts
+	eventFailing  notifyEvent = "failing"  // A PR has been failing for longer than failing_after
+	eventDigest   notifyEvent = "digest"   // Summary of the whole run
+)
+
+// Supported sink types
+const (
+	sinkSlack   = "slack"   // Slack incoming webhook
+	sinkDiscord = "discord" // Discord webhook
+	sinkWebhook = "webhook" // Generic JSON webhook
+)
+
+const defaultFailingAfter = 24 * time.Hour
+
+var defaultNotifyTemplates = map[notifyEvent]string{
+	eventMerged:   `Merged {{.Repo}}#{{.PR.Number}}: {{.PR.Title}}{{if .PR.URL}} ({{.PR.URL}}){{end}}`,
+	eventConflict: `{{.Repo}}#{{.PR.Number}} has conflicts with {{.PR.Base}} and needs a manual rebase: {{.PR.Title}}{{if .PR.URL}} ({{.PR.URL}}){{end}}`,
+	eventFailing:  `{{.Repo}}#{{.PR.Number}} has been failing for {{.FailingFor}} ({{join .PR.FailedChecks ", "}}): {{.PR.Title}}{{if .PR.URL}} ({{.PR.URL}}){{end}}`,
+	eventDigest: `PR status checker run for {{.Repo}}: {{len .PRs}} PRs
+{{range .PRs}}- #{{.Number}} {{.Title}}: {{.Outcome}}{{if .Reason}} ({{.Reason}}){{end}}{{if .BranchDeletion}}, branch {{.BranchDeletion}}{{end}}
+{{end}}`,
+}
+
+// notifyConfig is the JSON notification configuration file
+type notifyConfig struct {
+	// FailingAfter is how long a PR has to be red before a "failing" event is
+	// sent, as a Go duration (default 24h)
+	FailingAfter string             `json:"failing_after"`
+	Templates    map[string]string  `json:"templates"`
+	Sinks        []notifySinkConfig `json:"sinks"`
+}
+
+type notifySinkConfig struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	URL       string            `json:"url"`
+	Events    []string          `json:"events"`
+	Templates map[string]string `json:"templates"`
+}
+
+// notifyPR is the view of a PR report exposed to templates and webhooks
+type notifyPR struct {
+	Number        int      `json:"number"`
+	Title         string   `json:"title"`
+	Author        string   `json:"author"`
+	URL           string   `json:"url"`
+	Base          string   `json:"base"`
+	HeadSHA       string   `json:"head_sha"`
+	Outcome       string   `json:"outcome"`
+	Reason        string   `json:"reason"`
+	FailedChecks  []string `json:"failed_checks,omitempty"`
+	PendingChecks []string `json:"pending_checks,omitempty"`
+	// BranchDeletion is the result of deleting the head branch after merging
+	BranchDeletion string `json:"branch_deletion,omitempty"`
+	// Trace lists the evaluations the decision was based on
+	Trace []decisionStep `json:"trace,omitempty"`
+}
+
+// notifyData is the data passed to message templates
+type notifyData struct {
+	Event      notifyEvent
+	Repo       string
+	PR         notifyPR
+	PRs        []notifyPR
+	Counts     map[string]int
+	FailingFor time.Duration
+}
+
+type notifySink struct {
+	name      string
+	kind      string
+	url       string
+	events    map[notifyEvent]bool
+	templates map[notifyEvent]*template.Template
+}
+
+// notifier delivers event messages to chat and webhook sinks
+type notifier struct {
+	sinks        []*notifySink
+	failingAfter time.Duration
+	client       *http.Client
+
+	mu   sync.Mutex
+	sent map[string]bool // Per-PR events sent by this process, by notifiedKey
+}
+
+var notifyTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// loadNotifier reads the notification configuration file
+func loadNotifier(path string) (*notifier, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is supplied by the user on purpose
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification config: %v", err)
+	}
+	var cfg notifyConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse notification config: %v", err)
+	}
+	return newNotifier(&cfg)
+}
+
+func newNotifier(cfg *notifyConfig) (*notifier, error) {
+	n := &notifier{
+		failingAfter: defaultFailingAfter,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		sent:         make(map[string]bool),
+	}
+	if cfg.FailingAfter != "" {
+		d, err := time.ParseDuration(cfg.FailingAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid failing_after: %v", err)
+		}
+		n.failingAfter = d
+	}
+
+	for i, sc := range cfg.Sinks {
+		name := sc.Name
+		if name == "" {
+			name = fmt.Sprintf("sink %d", i+1)
+		}
+		switch sc.Type {
+		case sinkSlack, sinkDiscord, sinkWebhook:
+		default:
+			return nil, fmt.Errorf("%s: unknown sink type %q", name, sc.Type)
+		}
+		if sc.URL == "" {
+			return nil, fmt.Errorf("%s: url is required", name)
+		}
+
+		s := &notifySink{
+			name:      name,
+			kind:      sc.Type,
+			url:       sc.URL,
+			events:    make(map[notifyEvent]bool),
+			templates: make(map[notifyEvent]*template.Template),
+		}
+		events := sc.Events
+		if len(events) == 0 {
+			events = []string{string(eventMerged), string(eventConflict), string(eventFailing)}
+		}
+		for _, e := range events {
+			event := notifyEvent(e)
+			if _, ok := defaultNotifyTemplates[event]; !ok {
+				return nil, fmt.Errorf("%s: unknown event %q", name, e)
+			}
+			s.events[event] = true
+
+			text := defaultNotifyTemplates[event]
+			if t, ok := cfg.Templates[e]; ok {
+				text = t
+			}
+			if t, ok := sc.Templates[e]; ok {
+				text = t
+			}
+			tmpl, err := template.New(e).Funcs(notifyTemplateFuncs).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid %s template: %v", name, e, err)
+			}
+			s.templates[event] = tmpl
+		}
+		n.sinks = append(n.sinks, s)
+	}
+
+	return n, nil
+}
+
+// wants reports whether any sink is subscribed to the event
+func (n *notifier) wants(event notifyEvent) bool {
+	if n == nil {
+		return false
+	}
+	for _, s := range n.sinks {
+		if s.events[event] {
+			return true
+		}
+	}
+	return false
+}
+
+// send delivers the event to every subscribed sink. Failing sinks don't stop
+// delivery to the others; their errors are returned together.
+func (n *notifier) send(ctx context.Context, data notifyData) error {
+	var errs []string
+	for _, s := range n.sinks {
+		if !s.events[data.Event] {
+			continue
+		}
+		if err := n.deliver(ctx, s, data); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send %s notification: %s", data.Event, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (n *notifier) deliver(ctx context.Context, s *notifySink, data notifyData) error {
+	var text bytes.Buffer
+	if err := s.templates[data.Event].Execute(&text, data); err != nil {
+		return fmt.Errorf("error rendering template: %v", err)
+	}
+
+	var payload interface{}
+	switch s.kind {
+	case sinkSlack:
+		payload = map[string]string{"text": text.String()}
+	case sinkDiscord:
+		payload = map[string]string{"content": text.String()}
+	default:
+		body := map[string]interface{}{
+			"event":      data.Event,
+			"repository": data.Repo,
+			"text":       text.String(),
+		}
+		if data.Event == eventDigest {
+			body["pull_requests"] = data.PRs
+			body["counts"] = data.Counts
+		} else {
+			body["pull_request"] = data.PR
+		}
+		payload = body
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (r *prReport) notifyView() notifyPR {
+	return notifyPR{
+		Number:        r.number,
+		Title:         r.title,
+		Author:        r.author,
+		URL:           r.url,
+		Base:          r.baseRef,
+		HeadSHA:       r.headSHA,
+		Outcome:       string(r.outcome),
+		Reason:        r.reason,
+		FailedChecks:  r.failedChecks,
+		PendingChecks: r.pendingChecks,
+
+		BranchDeletion: r.branchDeletion,
+		Trace:          r.trace,
+	}
+}
+
+// failingSince returns when the oldest currently failing check of the PR
+// head failed, or the zero time if none is failing.
+func (p *PRProcessor) failingSince(pr *github.PullRequest) (time.Time, error) {
+	var since time.Time
+	earliest := func(t time.Time) {
+		if !t.IsZero() && (since.IsZero() || t.Before(since)) {
+			since = t
+		}
+	}
+
+	combinedStatus, _, err := p.client.Repositories.GetCombinedStatus(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetHead().GetSHA(), nil)
+	if err != nil {
+		return since, fmt.Errorf("error getting status: %v", err)
+	}
+	for _, status := range combinedStatus.Statuses {
+		switch status.GetState() {
+		case "failure", "error":
+			earliest(status.GetUpdatedAt().Time)
+		}
+	}
+
+	runs, err := p.listCheckRuns(pr.GetHead().GetSHA())
+	if err != nil {
+		return since, err
+	}
+	for _, run := range runs {
+		if checkRunState(run) == "failure" {
+			earliest(run.GetCompletedAt().Time)
+		}
+	}
+	return since, nil
+}
+
+// notifiedKey identifies a per-PR event sent for a head of the PR
+func notifiedKey(repo string, number int, sha string, event notifyEvent) string {
+	return fmt.Sprintf("%s#%d@%s:%s", repo, number, sha, event)
+}
+
+// alreadyNotified reports whether the event was sent for the current head of
+// the PR, either by this process or, as recorded in the state, by an earlier
+// run
+func (p *PRProcessor) alreadyNotified(r *prReport, event notifyEvent) bool {
+	if previous := r.previous; previous != nil && previous.NotifiedSHA == r.headSHA &&
+		slices.Contains(previous.Notified, string(event)) {
+		return true
+	}
+	p.notifier.mu.Lock()
+	defer p.notifier.mu.Unlock()
+	return p.notifier.sent[notifiedKey(p.cfg.owner+"/"+p.cfg.repo, r.number, r.headSHA, event)]
+}
+
+// markNotified records that the event was sent for the current head of the
+// PR, so that it is not sent again until a new commit is pushed
+func (p *PRProcessor) markNotified(r *prReport, event notifyEvent) {
+	p.notifier.mu.Lock()
+	p.notifier.sent[notifiedKey(p.cfg.owner+"/"+p.cfg.repo, r.number, r.headSHA, event)] = true
+	p.notifier.mu.Unlock()
+	r.notified = append(r.notified, string(event))
+}
+
+// notifyPR sends the per-PR events matching the outcome of the PR. Each event
+// is sent once per head of the PR, however many runs see the same outcome.
+func (p *PRProcessor) notifyPR(pr *github.PullRequest) error {
+	r := p.report(pr)
+	if r.unchanged {
+		// Already notified when the decision was taken
+		return nil
+	}
+	data := notifyData{Repo: p.cfg.owner + "/" + p.cfg.repo, PR: r.notifyView()}
+
+	switch {
+	case r.outcome == outcomeMerged:
+		data.Event = eventMerged
+	case r.outcome == outcomeConflict:
+		data.Event = eventConflict
+	case len(r.failedChecks) > 0 && r.outcome != outcomeMerged && p.notifier.wants(eventFailing):
+		if p.alreadyNotified(r, eventFailing) {
+			return nil
+		}
+		since, err := p.failingSince(pr)
+		if err != nil {
+			return err
+		}
+		if since.IsZero() || time.Since(since) < p.notifier.failingAfter {
+			return nil
+		}
+		data.Event = eventFailing
+		data.FailingFor = time.Since(since).Round(time.Minute)
+	default:
+		return nil
+	}
+
+	if !p.notifier.wants(data.Event) || p.alreadyNotified(r, data.Event) {
+		return nil
+	}
+	if err := p.notifier.send(p.ctx, data); err != nil {
+		return err
+	}
+	p.markNotified(r, data.Event)
+	return nil
+}
+
+// notifyDigest sends a summary of all PRs seen during the run
+func (p *PRProcessor) notifyDigest() error {
+	if !p.notifier.wants(eventDigest) {
+		return nil
+	}
+	data := notifyData{
+		Event:  eventDigest,
+		Repo:   p.cfg.owner + "/" + p.cfg.repo,
+		Counts: make(map[string]int),
+	}
+	for _, r := range p.sortedReports() {
+		data.PRs = append(data.PRs, r.notifyView())
+		data.Counts[string(r.outcome)]++
+	}
+	return p.notifier.send(p.ctx, data)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// webhookRecorder is a local HTTP server recording the JSON payloads posted to it
+type webhookRecorder struct {
+	mu       sync.Mutex
+	payloads map[string][]map[string]interface{}
+	server   *httptest.Server
+}
+
+func newWebhookRecorder(t *testing.T) *webhookRecorder {
+	w := &webhookRecorder{payloads: make(map[string][]map[string]interface{})}
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		if r.URL.Path == "/broken" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.mu.Lock()
+		w.payloads[r.URL.Path] = append(w.payloads[r.URL.Path], payload)
+		w.mu.Unlock()
+	}))
+	t.Cleanup(w.server.Close)
+	return w
+}
+
+func (w *webhookRecorder) received(path string) []map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.payloads[path]
+}
+
+func TestLoadNotifier(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    string
+		expectErr string
+	}{
+		{
+			name:   "valid",
+			config: `{"failing_after": "12h", "sinks": [{"type": "slack", "url": "http://localhost/slack", "events": ["merged", "digest"]}]}`,
+		},
+		{
+			name:      "unknown sink type",
+			config:    `{"sinks": [{"type": "teams", "url": "http://localhost"}]}`,
+			expectErr: "unknown sink type",
+		},
+		{
+			name:      "unknown event",
+			config:    `{"sinks": [{"type": "slack", "url": "http://localhost", "events": ["approved"]}]}`,
+			expectErr: "unknown event",
+		},
+		{
+			name:      "missing url",
+			config:    `{"sinks": [{"type": "discord"}]}`,
+			expectErr: "url is required",
+		},
+		{
+			name:      "invalid template",
+			config:    `{"templates": {"merged": "{{.PR.Number"}, "sinks": [{"type": "webhook", "url": "http://localhost"}]}`,
+			expectErr: "invalid merged template",
+		},
+		{
+			name:      "invalid duration",
+			config:    `{"failing_after": "a day", "sinks": []}`,
+			expectErr: "invalid failing_after",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notify.json")
+			if err := os.WriteFile(path, []byte(tc.config), 0o600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			n, err := loadNotifier(path)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("Expected error containing %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if n.failingAfter != 12*time.Hour {
+				t.Errorf("Expected failingAfter to be 12h, got %v", n.failingAfter)
+			}
+			if !n.wants(eventDigest) || n.wants(eventConflict) {
+				t.Error("Expected routing to follow the configured events")
+			}
+		})
+	}
+}
+
+func TestNotifierSinks(t *testing.T) {
+	recorder := newWebhookRecorder(t)
+	n, err := newNotifier(&notifyConfig{
+		Templates: map[string]string{"merged": "merged #{{.PR.Number}}"},
+		Sinks: []notifySinkConfig{
+			{Type: sinkSlack, URL: recorder.server.URL + "/slack", Events: []string{"merged"}},
+			{Type: sinkDiscord, URL: recorder.server.URL + "/discord", Events: []string{"merged"},
+				Templates: map[string]string{"merged": "{{.Repo}} merged {{.PR.Title}}"}},
+			{Type: sinkWebhook, URL: recorder.server.URL + "/webhook", Events: []string{"merged", "conflict"}},
+			{Type: sinkSlack, URL: recorder.server.URL + "/conflicts-only", Events: []string{"conflict"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data := notifyData{
+		Event: eventMerged,
+		Repo:  "test-owner/test-repo",
+		PR:    notifyPR{Number: 1, Title: "Test PR", Outcome: "merged"},
+	}
+	if err := n.send(context.Background(), data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := recorder.received("/slack"); len(got) != 1 || got[0]["text"] != "merged #1" {
+		t.Errorf("Unexpected Slack payloads: %v", got)
+	}
+	if got := recorder.received("/discord"); len(got) != 1 || got[0]["content"] != "test-owner/test-repo merged Test PR" {
+		t.Errorf("Unexpected Discord payloads: %v", got)
+	}
+	webhook := recorder.received("/webhook")
+	if len(webhook) != 1 || webhook[0]["event"] != "merged" || webhook[0]["repository"] != "test-owner/test-repo" {
+		t.Fatalf("Unexpected webhook payloads: %v", webhook)
+	}
+	if pr, ok := webhook[0]["pull_request"].(map[string]interface{}); !ok || pr["number"] != float64(1) {
+		t.Errorf("Expected webhook payload to include the PR, got %v", webhook[0])
+	}
+	if got := recorder.received("/conflicts-only"); len(got) != 0 {
+		t.Errorf("Expected merged event not to be routed to conflict-only sink, got %v", got)
+	}
+}
+
+func TestNotifierSendError(t *testing.T) {
+	recorder := newWebhookRecorder(t)
+	n, err := newNotifier(&notifyConfig{
+		Sinks: []notifySinkConfig{
+			{Name: "broken", Type: sinkWebhook, URL: recorder.server.URL + "/broken", Events: []string{"merged"}},
+			{Type: sinkSlack, URL: recorder.server.URL + "/slack", Events: []string{"merged"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err = n.send(context.Background(), notifyData{Event: eventMerged, PR: notifyPR{Number: 1}})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected error naming the broken sink, got %v", err)
+	}
+	if got := recorder.received("/slack"); len(got) != 1 {
+		t.Errorf("Expected other sinks to still be notified, got %v", got)
+	}
+}
+
+func TestProcessPullRequests_Notifications(t *testing.T) {
+	recorder := newWebhookRecorder(t)
+	n, err := newNotifier(&notifyConfig{
+		Sinks: []notifySinkConfig{
+			{Type: sinkSlack, URL: recorder.server.URL + "/events", Events: []string{"merged", "failing"}},
+			{Type: sinkWebhook, URL: recorder.server.URL + "/digest", Events: []string{"digest"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	twoDaysAgo := github.Timestamp{Time: time.Now().Add(-48 * time.Hour)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.PullRequest{
+			{Number: github.Ptr(1), Title: github.Ptr("Green PR"), Head: &github.PullRequestBranch{SHA: github.Ptr("green-sha")}},
+			{Number: github.Ptr(2), Title: github.Ptr("Red PR"), Head: &github.PullRequestBranch{SHA: github.Ptr("red-sha")}},
+			{Number: github.Ptr(3), Title: github.Ptr("Draft PR"), Draft: github.Ptr(true)},
+		})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/green-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/red-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr("failure"), Context: github.Ptr("test-check"), UpdatedAt: &twoDaysAgo},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr[int64](123)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+
+	store, err := openStateStore(stateBackendJSON, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	processor := &PRProcessor{
+		client:   github.NewClient(&http.Client{Transport: &handlerTransport{handler: mux}}),
+		cfg:      &config{owner: testOwner, repo: testRepo, approve: true, stateRecheckAfter: time.Nanosecond},
+		ctx:      context.Background(),
+		notifier: n,
+		state:    store,
+	}
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var texts []string
+	for _, payload := range recorder.received("/events") {
+		texts = append(texts, payload["text"].(string))
+	}
+	joined := strings.Join(texts, "\n")
+	if len(texts) != 2 || !strings.Contains(joined, "Merged test-owner/test-repo#1") || !strings.Contains(joined, "#2 has been failing for 48h0m0s (test-check)") {
+		t.Errorf("Unexpected event notifications: %v", texts)
+	}
+
+	digest := recorder.received("/digest")
+	if len(digest) != 1 {
+		t.Fatalf("Expected one digest, got %d", len(digest))
+	}
+	counts, _ := digest[0]["counts"].(map[string]interface{})
+	if counts["merged"] != float64(1) || counts["blocked"] != float64(1) || counts["skipped"] != float64(1) {
+		t.Errorf("Unexpected digest counts: %v", counts)
+	}
+	if prs, _ := digest[0]["pull_requests"].([]interface{}); len(prs) != 3 {
+		t.Errorf("Expected digest to list 3 PRs, got %v", digest[0]["pull_requests"])
+	}
+
+	// Later runs, in the same process or a new one reading the state, don't
+	// repeat the events for the same heads
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fresh, err := newNotifier(&notifyConfig{
+		Sinks: []notifySinkConfig{{Type: sinkSlack, URL: recorder.server.URL + "/events", Events: []string{"merged", "failing"}}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	processor.notifier = fresh
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if events := recorder.received("/events"); len(events) != 2 {
+		t.Errorf("Expected the events to be sent once, got %d notifications", len(events))
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/go-github/v71/github"
+)
+
+// policyAction is what a matching policy rule does with a PR
+type policyAction string
+
+const (
+	policySkip    policyAction = "skip"    // Leave the PR alone
+	policyApprove policyAction = "approve" // Approve once checks pass, leave merging to a human
+	policyMerge   policyAction = "merge"   // Merge once checks pass, approving first if -approve is set
+)
+
+// policyDefaultRule is the name reported when no rule matched
+const policyDefaultRule = "default"
+
+// factSet is a set of PR fact groups that need extra API calls to gather
+type factSet int
+
+const (
+	factFiles   factSet = 1 << iota // Changed file names
+	factSize                        // Additions, deletions and changed file count
+	factChecks                      // Status checks and check runs of the head
+	factReviews                     // Submitted reviews
+)
+
+// Variables that need a fact group to be loaded; all others come with the PR
+var policyVariableFacts = map[string]factSet{
+	"files":             factFiles,
+	"additions":         factSize,
+	"deletions":         factSize,
+	"changed_files":     factSize,
+	"size":              factSize,
+	"checks":            factChecks,
+	"failed_checks":     factChecks,
+	"pending_checks":    factChecks,
+	"approvals":         factReviews,
+	"changes_requested": factReviews,
+	"approved_by":       factReviews,
+}
+
+// prFacts is what policy rules are evaluated against
+type prFacts struct {
+	loaded factSet
+
+	number             int
+	title              string
+	body               string
+	author             string
+	labels             []string
+	base               string
+	head               string
+	draft              bool
+	requestedReviewers []string
+	currentUser        string
+	createdAt          time.Time
+	dependency         *dependencyUpdate
+
+	files        []string
+	additions    int
+	deletions    int
+	changedFiles int
+
+	checks        map[string]string // Check name -> "success", "failure" or "pending"
+	failedChecks  []string
+	pendingChecks []string
+
+	approvals        int
+	changesRequested int
+	approvedBy       []string
+}
+
+// vars returns the facts as CEL variables
+func (f *prFacts) vars() map[string]interface{} {
+	// Dependency variables are empty for PRs not opened by an update bot
+	dependency := f.dependency
+	if dependency == nil {
+		dependency = &dependencyUpdate{}
+	}
+	return map[string]interface{}{
+		"number":                 f.number,
+		"title":                  f.title,
+		"body":                   f.body,
+		"author":                 f.author,
+		"labels":                 f.labels,
+		"base":                   f.base,
+		"head":                   f.head,
+		"draft":                  f.draft,
+		"requested_reviewers":    f.requestedReviewers,
+		"current_user":           f.currentUser,
+		"created_at":             f.createdAt,
+		"age":                    time.Since(f.createdAt),
+		"files":                  f.files,
+		"additions":              f.additions,
+		"deletions":              f.deletions,
+		"changed_files":          f.changedFiles,
+		"size":                   f.additions + f.deletions,
+		"checks":                 f.checks,
+		"failed_checks":          f.failedChecks,
+		"pending_checks":         f.pendingChecks,
+		"approvals":              f.approvals,
+		"changes_requested":      f.changesRequested,
+		"approved_by":            f.approvedBy,
+		"dependency_bot":         dependency.bot,
+		"dependency_package":     dependency.pkg,
+		"dependency_from":        dependency.from,
+		"dependency_to":          dependency.to,
+		"dependency_update_type": dependency.updateType,
+	}
+}
+
+// policyRule is a single rule of the policy. Rules are evaluated in order and
+// the first matching one decides.
+type policyRule struct {
+	name     string
+	action   policyAction
+	reason   string
+	skipCode string // Skip rule identifier used as metric label
+	inScope  bool   // Whether PRs skipped by the rule get a status comment
+	needs    factSet
+	inputs   []string // Variables the rule reads, shown in decision traces
+	match    func(*prFacts) (bool, error)
+}
+
+// policy is the ordered list of rules loaded from the policy file
+type policy struct {
+	rules         []*policyRule
+	defaultAction policyAction
+}
+
+// policyDecision is the outcome of evaluating the policy for a PR
+type policyDecision struct {
+	rule     string
+	action   policyAction
+	reason   string
+	skipCode string
+	inScope  bool
+}
+
+// policyFile is the JSON policy configuration file
+type policyFile struct {
+	// Default is the action taken when no rule matches (default: merge)
+	Default string             `json:"default"`
+	Rules   []policyRuleConfig `json:"rules"`
+}
+
+type policyRuleConfig struct {
+	Name   string `json:"name"`
+	When   string `json:"when"` // CEL expression evaluating to a bool
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+func newPolicyEnv() (*cel.Env, error) {
+	stringList := cel.ListType(cel.StringType)
+	return cel.NewEnv(
+		cel.Variable("number", cel.IntType),
+		cel.Variable("title", cel.StringType),
+		cel.Variable("body", cel.StringType),
+		cel.Variable("author", cel.StringType),
+		cel.Variable("labels", stringList),
+		cel.Variable("base", cel.StringType),
+		cel.Variable("head", cel.StringType),
+		cel.Variable("draft", cel.BoolType),
+		cel.Variable("requested_reviewers", stringList),
+		cel.Variable("current_user", cel.StringType),
+		cel.Variable("created_at", cel.TimestampType),
+		cel.Variable("age", cel.DurationType),
+		cel.Variable("files", stringList),
+		cel.Variable("additions", cel.IntType),
+		cel.Variable("deletions", cel.IntType),
+		cel.Variable("changed_files", cel.IntType),
+		cel.Variable("size", cel.IntType),
+		cel.Variable("checks", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("failed_checks", stringList),
+		cel.Variable("pending_checks", stringList),
+		cel.Variable("approvals", cel.IntType),
+		cel.Variable("changes_requested", cel.IntType),
+		cel.Variable("approved_by", stringList),
+		cel.Variable("dependency_bot", cel.StringType),
+		cel.Variable("dependency_package", cel.StringType),
+		cel.Variable("dependency_from", cel.StringType),
+		cel.Variable("dependency_to", cel.StringType),
+		cel.Variable("dependency_update_type", cel.StringType),
+	)
+}
+
+func parsePolicyAction(action string) (policyAction, error) {
+	switch a := policyAction(action); a {
+	case policySkip, policyApprove, policyMerge:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown action %q: must be %q, %q or %q", action, policySkip, policyApprove, policyMerge)
+	}
+}
+
+// loadPolicy reads and compiles the policy file
+func loadPolicy(path string) (*policy, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is supplied by the user on purpose
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %v", err)
+	}
+	return compilePolicy(&file)
+}
+
+func compilePolicy(file *policyFile) (*policy, error) {
+	env, err := newPolicyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	pol := &policy{defaultAction: policyMerge}
+	if file.Default != "" {
+		if pol.defaultAction, err = parsePolicyAction(file.Default); err != nil {
+			return nil, fmt.Errorf("invalid default action: %v", err)
+		}
+	}
+
+	variables := (&prFacts{}).vars()
+	for i, rc := range file.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		action, err := parsePolicyAction(rc.Action)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		ast, iss := env.Compile(rc.When)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("%s: invalid expression: %v", name, iss.Err())
+		}
+		if !ast.OutputType().IsExactType(cel.BoolType) {
+			return nil, fmt.Errorf("%s: expression must evaluate to a bool, got %v", name, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		// Only gather the facts the expression refers to. References also
+		// include functions and comprehension variables, which are no inputs.
+		var needs factSet
+		var inputs []string
+		for _, ref := range ast.NativeRep().ReferenceMap() {
+			needs |= policyVariableFacts[ref.Name]
+			if _, ok := variables[ref.Name]; ok && !slices.Contains(inputs, ref.Name) {
+				inputs = append(inputs, ref.Name)
+			}
+		}
+		sort.Strings(inputs)
+
+		reason := rc.Reason
+		if reason == "" {
+			reason = "policy rule " + name
+		}
+		pol.rules = append(pol.rules, &policyRule{
+			name:     name,
+			action:   action,
+			reason:   reason,
+			skipCode: "policy:" + name,
+			inScope:  true,
+			needs:    needs,
+			inputs:   inputs,
+			match: func(f *prFacts) (bool, error) {
+				out, _, err := program.Eval(f.vars())
+				if err != nil {
+					return false, err
+				}
+				matched, ok := out.Value().(bool)
+				if !ok {
+					return false, fmt.Errorf("expression did not evaluate to a bool")
+				}
+				return matched, nil
+			},
+		})
+	}
+	return pol, nil
+}
+
+// builtinRules expresses the filter flags as policy rules evaluated before
+// the rules of the policy file
+func (p *PRProcessor) builtinRules() []*policyRule {
+	var rules []*policyRule
+
+	// Check reviewer filter (if enabled, only process PRs where current user is a reviewer)
+	if p.cfg.filterByReviewer {
+		rules = append(rules, &policyRule{
+			name:     "reviewer-filter",
+			action:   policySkip,
+			reason:   "no reviewers assigned",
+			skipCode: skipNoReviewers,
+			inputs:   []string{"requested_reviewers"},
+			match: func(f *prFacts) (bool, error) {
+				return len(f.requestedReviewers) == 0, nil
+			},
+		}, &policyRule{
+			name:     "reviewer-filter",
+			action:   policySkip,
+			reason:   p.currentUser + " is not a reviewer",
+			skipCode: skipNotReviewer,
+			inputs:   []string{"requested_reviewers", "current_user"},
+			match: func(f *prFacts) (bool, error) {
+				for _, reviewer := range f.requestedReviewers {
+					if reviewer == f.currentUser {
+						return false, nil
+					}
+				}
+				return true, nil
+			},
+		})
+	}
+
+	// Check skip pattern
+	if p.cfg.skipPattern != "" {
+		rules = append(rules, &policyRule{
+			name:     "skip-pattern",
+			action:   policySkip,
+			reason:   "title matches skip pattern " + p.cfg.skipPattern,
+			skipCode: skipTitlePattern,
+			inScope:  true,
+			inputs:   []string{"title"},
+			match: func(f *prFacts) (bool, error) {
+				matched, err := regexp.MatchString(p.cfg.skipPattern, f.title)
+				if err != nil {
+					return false, fmt.Errorf("error matching skip pattern: %v", err)
+				}
+				return matched, nil
+			},
+		})
+	}
+
+	// Check author pattern (if specified, only process PRs from matching authors)
+	if p.cfg.authorPattern != "" {
+		rules = append(rules, &policyRule{
+			name:     "author-pattern",
+			action:   policySkip,
+			reason:   "author does not match author pattern",
+			skipCode: skipAuthorPattern,
+			inputs:   []string{"author"},
+			match: func(f *prFacts) (bool, error) {
+				matched, err := regexp.MatchString(p.cfg.authorPattern, f.author)
+				if err != nil {
+					return false, fmt.Errorf("error matching author pattern: %v", err)
+				}
+				return !matched, nil
+			},
+		})
+	}
+
+	return rules
+}
+
+// inScope reports whether the PR is meant to be handled by this instance, i.e.
+// not filtered out by the reviewer and author filters. Unlike evaluatePolicy
+// it records nothing.
+func (p *PRProcessor) inScope(pr *github.PullRequest) (bool, error) {
+	facts := newPRFacts(pr, p.currentUser)
+	for _, rule := range p.builtinRules() {
+		if rule.inScope {
+			continue
+		}
+		matched, err := rule.match(facts)
+		if err != nil {
+			return false, fmt.Errorf("policy rule %s: %v", rule.name, err)
+		}
+		if matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// evaluatePolicy returns the decision of the first matching rule, downgraded
+// by the active merge freeze. Facts that need API calls are only gathered once
+// a rule needs them. Every evaluated rule is recorded in the decision trace.
+func (p *PRProcessor) evaluatePolicy(pr *github.PullRequest) (*policyDecision, error) {
+	rules := append(p.builtinRules(), p.dependencyRules()...)
+	defaultAction := policyMerge
+	if p.policy != nil {
+		rules = append(rules, p.policy.rules...)
+		defaultAction = p.policy.defaultAction
+	}
+
+	r := p.report(pr)
+	facts := newPRFacts(pr, p.currentUser)
+	for _, rule := range rules {
+		if err := p.loadFacts(pr, facts, rule.needs); err != nil {
+			return nil, err
+		}
+		matched, err := rule.match(facts)
+		if err != nil {
+			r.record(stagePolicy, rule.name, "error: "+err.Error(), rule.traceInputs(facts))
+			return nil, fmt.Errorf("policy rule %s: %v", rule.name, err)
+		}
+		if !matched {
+			r.record(stagePolicy, rule.name, "no match", rule.traceInputs(facts))
+			continue
+		}
+		r.record(stagePolicy, rule.name, "matched, "+string(rule.action), rule.traceInputs(facts))
+		return p.tracedFreezeDecision(r, &policyDecision{
+			rule:     rule.name,
+			action:   rule.action,
+			reason:   rule.reason,
+			skipCode: rule.skipCode,
+			inScope:  rule.inScope,
+		}), nil
+	}
+	r.record(stagePolicy, policyDefaultRule, "no rule matched, "+string(defaultAction), nil)
+	return p.tracedFreezeDecision(r, &policyDecision{
+		rule:     policyDefaultRule,
+		action:   defaultAction,
+		reason:   "no policy rule matched",
+		skipCode: "policy:" + policyDefaultRule,
+		inScope:  true,
+	}), nil
+}
+
+// tracedFreezeDecision applies the active merge freeze to the decision and
+// records a downgrade in the decision trace
+func (p *PRProcessor) tracedFreezeDecision(r *prReport, decision *policyDecision) *policyDecision {
+	frozen := p.freezeDecision(decision)
+	if frozen != decision {
+		r.record(stagePolicy, frozen.rule, string(decision.action)+" downgraded to "+string(frozen.action),
+			map[string]string{"window": p.activeFreeze.String()})
+	}
+	return frozen
+}
+
+// traceInputs returns the values of the variables the rule reads
+func (rule *policyRule) traceInputs(f *prFacts) map[string]string {
+	if len(rule.inputs) == 0 {
+		return nil
+	}
+	vars := f.vars()
+	inputs := make(map[string]string, len(rule.inputs))
+	for _, name := range rule.inputs {
+		inputs[name] = traceValue(vars[name])
+	}
+	return inputs
+}
+
+// newPRFacts returns the facts available without further API calls
+func newPRFacts(pr *github.PullRequest, currentUser string) *prFacts {
+	f := &prFacts{
+		number:      pr.GetNumber(),
+		title:       pr.GetTitle(),
+		body:        pr.GetBody(),
+		author:      pr.GetUser().GetLogin(),
+		base:        pr.GetBase().GetRef(),
+		head:        pr.GetHead().GetRef(),
+		draft:       pr.GetDraft(),
+		currentUser: currentUser,
+		createdAt:   pr.GetCreatedAt().Time,
+		dependency:  parseDependencyUpdate(pr),
+	}
+	for _, label := range pr.Labels {
+		f.labels = append(f.labels, label.GetName())
+	}
+	for _, reviewer := range pr.RequestedReviewers {
+		f.requestedReviewers = append(f.requestedReviewers, reviewer.GetLogin())
+	}
+	return f
+}
+
+// loadFacts gathers the fact groups in needs that are not loaded yet
+func (p *PRProcessor) loadFacts(pr *github.PullRequest, f *prFacts, needs factSet) error {
+	missing := needs &^ f.loaded
+	if missing&factFiles != 0 {
+		opts := &github.ListOptions{PerPage: 100}
+		for {
+			files, resp, err := p.client.PullRequests.ListFiles(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), opts)
+			if err != nil {
+				return fmt.Errorf("error listing files: %v", err)
+			}
+			for _, file := range files {
+				f.files = append(f.files, file.GetFilename())
+			}
+			if resp == nil || resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+	if missing&factSize != 0 {
+		// The list endpoint doesn't include the diff size
+		full, _, err := p.client.PullRequests.Get(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber())
+		if err != nil {
+			return fmt.Errorf("error getting PR details: %v", err)
+		}
+		f.additions, f.deletions, f.changedFiles = full.GetAdditions(), full.GetDeletions(), full.GetChangedFiles()
+	}
+	if missing&factChecks != 0 {
+		if err := p.loadCheckFacts(pr, f); err != nil {
+			return err
+		}
+	}
+	if missing&factReviews != 0 {
+		if err := p.loadReviewFacts(pr, f); err != nil {
+			return err
+		}
+	}
+	f.loaded |= missing
+	return nil
+}
+
+func (p *PRProcessor) loadCheckFacts(pr *github.PullRequest, f *prFacts) error {
+	combinedStatus, _, err := p.client.Repositories.GetCombinedStatus(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetHead().GetSHA(), nil)
+	if err != nil {
+		return fmt.Errorf("error getting status: %v", err)
+	}
+	runs, err := p.listCheckRuns(pr.GetHead().GetSHA())
+	if err != nil {
+		return err
+	}
+
+	f.checks = make(map[string]string)
+	for _, status := range combinedStatus.Statuses {
+		if status.GetContext() == lockStatusContext {
+			continue
+		}
+		switch status.GetState() {
+		case "failure", "error":
+			f.checks[status.GetContext()] = "failure"
+		case "pending":
+			f.checks[status.GetContext()] = "pending"
+		default:
+			f.checks[status.GetContext()] = "success"
+		}
+	}
+	for _, run := range runs {
+		f.checks[run.GetName()] = checkRunState(run)
+	}
+	for name, state := range f.checks {
+		switch state {
+		case "failure":
+			f.failedChecks = append(f.failedChecks, name)
+		case "pending":
+			f.pendingChecks = append(f.pendingChecks, name)
+		}
+	}
+	sort.Strings(f.failedChecks)
+	sort.Strings(f.pendingChecks)
+	return nil
+}
+
+func (p *PRProcessor) loadReviewFacts(pr *github.PullRequest, f *prFacts) error {
+	// Only the latest review of each reviewer counts
+	latest := make(map[string]string)
+	var order []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := p.client.PullRequests.ListReviews(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), opts)
+		if err != nil {
+			return fmt.Errorf("error listing reviews: %v", err)
+		}
+		for _, review := range reviews {
+			login := review.GetUser().GetLogin()
+			switch state := review.GetState(); state {
+			case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+				if _, ok := latest[login]; !ok {
+					order = append(order, login)
+				}
+				latest[login] = state
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, login := range order {
+		switch latest[login] {
+		case "APPROVED":
+			f.approvals++
+			f.approvedBy = append(f.approvedBy, login)
+		case "CHANGES_REQUESTED":
+			f.changesRequested++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestCompilePolicy_Errors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     policyFile
+		errorMsg string
+	}{
+		{
+			name:     "unknown action",
+			file:     policyFile{Rules: []policyRuleConfig{{Name: "r", When: "true", Action: "close"}}},
+			errorMsg: `unknown action "close"`,
+		},
+		{
+			name:     "syntax error",
+			file:     policyFile{Rules: []policyRuleConfig{{Name: "r", When: "author ==", Action: "merge"}}},
+			errorMsg: "r: invalid expression",
+		},
+		{
+			name:     "unknown variable",
+			file:     policyFile{Rules: []policyRuleConfig{{Name: "r", When: "owner == 'me'", Action: "merge"}}},
+			errorMsg: "r: invalid expression",
+		},
+		{
+			name:     "not a bool",
+			file:     policyFile{Rules: []policyRuleConfig{{Name: "r", When: "size", Action: "merge"}}},
+			errorMsg: "must evaluate to a bool",
+		},
+		{
+			name:     "invalid default",
+			file:     policyFile{Default: "close"},
+			errorMsg: "invalid default action",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compilePolicy(&tc.file)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestCompilePolicy_Needs(t *testing.T) {
+	pol, err := compilePolicy(&policyFile{Rules: []policyRuleConfig{
+		{Name: "cheap", When: "'dependencies' in labels && age > duration('1h')", Action: "merge"},
+		{Name: "files", When: "files.all(f, f.startsWith('docs/'))", Action: "merge"},
+		{Name: "reviews", When: "approvals >= 2 && size < 100", Action: "merge"},
+		{Name: "deps", When: "dependency_bot == 'renovate' && dependency_update_type != 'major'", Action: "merge"},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []factSet{0, factFiles, factReviews | factSize, 0}
+	for i, rule := range pol.rules {
+		if rule.needs != expected[i] {
+			t.Errorf("Rule %s: expected needs %b, got %b", rule.name, expected[i], rule.needs)
+		}
+	}
+}
+
+// newPolicyTestServer serves a Renovate PR touching files with passing checks
+func newPolicyTestServer(t *testing.T, title string, files []string) *recordingTransport {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.PullRequest{{
+			Number:    github.Ptr(1),
+			Title:     github.Ptr(title),
+			User:      &github.User{Login: github.Ptr("renovate[bot]"), Type: github.Ptr("Bot")},
+			Labels:    []*github.Label{{Name: github.Ptr("dependencies")}},
+			CreatedAt: &github.Timestamp{Time: time.Now().Add(-48 * time.Hour)},
+			Head:      &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+			Base:      &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1/files", func(w http.ResponseWriter, _ *http.Request) {
+		var commitFiles []*github.CommitFile
+		for _, file := range files {
+			commitFiles = append(commitFiles, &github.CommitFile{Filename: github.Ptr(file)})
+		}
+		writeJSON(t, w, commitFiles)
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr("success"), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	return &recordingTransport{base: &handlerTransport{handler: mux}}
+}
+
+func TestProcessPullRequests_Policy(t *testing.T) {
+	rules := &policyFile{
+		Default: "skip",
+		Rules: []policyRuleConfig{
+			{
+				Name:   "renovate-patch",
+				When:   "author == 'renovate[bot]' && title.contains('patch') && files.all(f, f in ['go.mod', 'go.sum'])",
+				Action: "merge",
+			},
+			{
+				Name:   "renovate-minor",
+				When:   "author == 'renovate[bot]' && title.contains('minor')",
+				Action: "approve",
+			},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		title           string
+		files           []string
+		expectedOutcome prOutcome
+		expectedRule    string
+		expectReview    bool
+		expectMerge     bool
+	}{
+		{
+			name:            "patch update touching go.mod is merged",
+			title:           "fix(deps): update module foo to v1.2.4 (patch)",
+			files:           []string{"go.mod", "go.sum"},
+			expectedOutcome: outcomeMerged,
+			expectedRule:    "renovate-patch",
+			expectMerge:     true, // -approve is off, so merge rules don't approve
+		},
+		{
+			name:            "minor update is only approved",
+			title:           "fix(deps): update module foo to v1.3.0 (minor)",
+			files:           []string{"go.mod"},
+			expectedOutcome: outcomeApproved,
+			expectedRule:    "renovate-minor",
+			expectReview:    true,
+		},
+		{
+			name:            "patch update touching code falls through to the default",
+			title:           "fix(deps): update module foo to v1.2.4 (patch)",
+			files:           []string{"go.mod", "main.go"},
+			expectedOutcome: outcomeSkipped,
+			expectedRule:    policyDefaultRule,
+		},
+	}
+
+	pol, err := compilePolicy(rules)
+	if err != nil {
+		t.Fatalf("Expected no error compiling policy, got %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := newPolicyTestServer(t, tc.title, tc.files)
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg:    &config{owner: testOwner, repo: testRepo, approve: false},
+				ctx:    context.Background(),
+				policy: pol,
+			}
+			if err := processor.ProcessPullRequests(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			r := processor.sortedReports()[0]
+			if r.outcome != tc.expectedOutcome || r.policyRule != tc.expectedRule {
+				t.Errorf("Expected %s by rule %s, got %s by rule %s (%s)", tc.expectedOutcome, tc.expectedRule, r.outcome, r.policyRule, r.reason)
+			}
+			if got := len(transport.find("POST", "/repos/test-owner/test-repo/pulls/1/reviews")) > 0; got != tc.expectReview {
+				t.Errorf("Expected review %v, got %v", tc.expectReview, got)
+			}
+			if got := len(transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge")) > 0; got != tc.expectMerge {
+				t.Errorf("Expected merge %v, got %v", tc.expectMerge, got)
+			}
+		})
+	}
+}
+
+func TestEvaluatePolicy_LazyFacts(t *testing.T) {
+	pol, err := compilePolicy(&policyFile{Rules: []policyRuleConfig{
+		{Name: "wip", When: "title.startsWith('WIP')", Action: "skip"},
+		{Name: "docs", When: "files.all(f, f.startsWith('docs/'))", Action: "merge"},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transport := newPolicyTestServer(t, "WIP: not ready", []string{"docs/README.md"})
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg:    &config{owner: testOwner, repo: testRepo},
+		ctx:    context.Background(),
+		policy: pol,
+	}
+	pr := &github.PullRequest{Number: github.Ptr(1), Title: github.Ptr("WIP: not ready")}
+	decision, err := processor.evaluatePolicy(pr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decision.rule != "wip" || decision.action != policySkip {
+		t.Errorf("Expected wip rule to skip, got %+v", decision)
+	}
+	if calls := transport.find("GET", "/repos/test-owner/test-repo/pulls/1/files"); len(calls) != 0 {
+		t.Errorf("Expected files not to be listed when an earlier rule matched, got %d calls", len(calls))
+	}
+}
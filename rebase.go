@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Supported branch update strategies
+const (
+	updateStrategyMerge  = "merge"  // GitHub "Update branch" API, adds a merge commit
+	updateStrategyRebase = "rebase" // Local rebase onto the base branch and force-push
+)
+
+// Identity used for the committer of rebased commits; authors are preserved
+const (
+	rebaseCommitterName  = "pr-status-checker"
+	rebaseCommitterEmail = "pr-status-checker@users.noreply.github.com"
+)
+
+// rebaseConflictError is returned when a PR branch cannot be rebased onto its
+// base branch without manual conflict resolution.
+type rebaseConflictError struct {
+	number int
+	files  []string
+}
+
+func (e *rebaseConflictError) Error() string {
+	if len(e.files) == 0 {
+		return fmt.Sprintf("PR #%d: rebase hit conflicts, manual rebase required", e.number)
+	}
+	return fmt.Sprintf("PR #%d: rebase hit conflicts in %s, manual rebase required", e.number, strings.Join(e.files, ", "))
+}
+
+// runGit runs git in dir and returns its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	return runGitEnv(dir, nil, args...)
+}
+
+// runGitEnv runs git in dir with additional environment variables, such as
+// the authorization header from gitAuthEnv, which are kept out of errors.
+func runGitEnv(dir string, env []string, args ...string) (string, error) {
+	cmd := execCommand("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(append(cmd.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", gitSubcommand(args), err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitSubcommand returns the git command of args, skipping the "-c name=value"
+// options in front of it
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c":
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			return args[i]
+		}
+	}
+	return "command"
+}
+
+// gitAuthEnv returns environment variables authenticating HTTPS fetches and
+// pushes with the configured token. The header is passed as configuration in
+// the environment rather than on the command line, where any user of the host
+// could read it.
+func (p *PRProcessor) gitAuthEnv() []string {
+	if p.cfg.token == "" {
+		return nil
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + p.cfg.token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: basic " + credentials,
+	}
+}
+
+// rebasePRBranch fetches the PR head and base branches into a temporary
+// repository, rebases the head onto the base and force-pushes the result with
+// a lease on the head SHA the decision was based on, so that commits pushed in
+// the meantime are never overwritten.
+func (p *PRProcessor) rebasePRBranch(pr *github.PullRequest) error {
+	headRepo := pr.GetHead().GetRepo()
+	baseRepo := pr.GetBase().GetRepo()
+	if headRepo.GetCloneURL() == "" || baseRepo.GetCloneURL() == "" {
+		return fmt.Errorf("PR #%d: missing repository information for local rebase", pr.GetNumber())
+	}
+	if headRepo.GetFullName() != baseRepo.GetFullName() && !pr.GetMaintainerCanModify() {
+		return fmt.Errorf("PR #%d: head branch is in a fork that does not allow maintainer edits, cannot force-push", pr.GetNumber())
+	}
+
+	dir, err := os.MkdirTemp("", "pr-status-checker-rebase-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	headRef := pr.GetHead().GetRef()
+	baseRef := pr.GetBase().GetRef()
+	auth := p.gitAuthEnv()
+
+	if _, err := runGit(dir, "init", "--quiet"); err != nil {
+		return err
+	}
+	_, err = runGitEnv(dir, auth, "fetch", "--quiet", "--no-tags", headRepo.GetCloneURL(),
+		"+refs/heads/"+headRef+":refs/remotes/head/"+headRef)
+	if err != nil {
+		return fmt.Errorf("error fetching head branch: %v", err)
+	}
+	_, err = runGitEnv(dir, auth, "fetch", "--quiet", "--no-tags", baseRepo.GetCloneURL(),
+		"+refs/heads/"+baseRef+":refs/remotes/base/"+baseRef)
+	if err != nil {
+		return fmt.Errorf("error fetching base branch: %v", err)
+	}
+
+	if _, err := runGit(dir, "checkout", "--quiet", "-B", headRef, "refs/remotes/head/"+headRef); err != nil {
+		return err
+	}
+
+	_, err = runGit(dir, "-c", "user.name="+rebaseCommitterName, "-c", "user.email="+rebaseCommitterEmail,
+		"rebase", "--quiet", "refs/remotes/base/"+baseRef)
+	if err != nil {
+		conflicts, _ := runGit(dir, "diff", "--name-only", "--diff-filter=U")
+		_, _ = runGit(dir, "rebase", "--abort")
+		if conflicts == "" {
+			return fmt.Errorf("PR #%d: error rebasing branch: %v", pr.GetNumber(), err)
+		}
+		r := p.report(pr)
+		r.decide(outcomeConflict, "rebase onto "+baseRef+" hit conflicts")
+		r.conflictFiles = strings.Split(conflicts, "\n")
+		return &rebaseConflictError{number: pr.GetNumber(), files: r.conflictFiles}
+	}
+
+	newSHA, err := runGit(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	lease := fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", headRef, pr.GetHead().GetSHA())
+	_, err = runGitEnv(dir, auth, "push", "--quiet", lease, headRepo.GetCloneURL(), "HEAD:refs/heads/"+headRef)
+	if err != nil {
+		return fmt.Errorf("error force-pushing rebased branch: %v", err)
+	}
+
+	fmt.Printf("PR #%d: Rebased onto %s and force-pushed, new head %s\n", pr.GetNumber(), baseRef, newSHA)
+	r := p.report(pr)
+	r.rebase = fmt.Sprintf("rebased onto `%s` and force-pushed", baseRef)
+	r.decide(outcomeUpdated, "branch was behind the base branch")
+	return nil
+}
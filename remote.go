@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// remoteInfo describes the GitHub repository a git remote points at
+type remoteInfo struct {
+	host  string
+	owner string
+	repo  string
+}
+
+// scpLikeURL matches scp-style remotes such as git@github.com:owner/repo.git
+var scpLikeURL = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// parseRemoteURL extracts host, owner and repository name from a git remote
+// URL. It supports scp-style (user@host:path), ssh://, git:// and http(s)://
+// URLs including credentials, ports, trailing slashes and path prefixes as
+// used by some GitHub Enterprise Server installations. The owner and repo are
+// always taken from the last two path segments.
+func parseRemoteURL(remoteURL string) (*remoteInfo, error) {
+	remoteURL = strings.TrimSpace(remoteURL)
+	if remoteURL == "" {
+		return nil, fmt.Errorf("empty remote URL")
+	}
+
+	var host, path string
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote URL %s: %v", remoteURL, err)
+		}
+		host = u.Hostname()
+		path = u.Path
+	} else if m := scpLikeURL.FindStringSubmatch(remoteURL); m != nil {
+		host = m[1]
+		path = m[2]
+	} else {
+		return nil, fmt.Errorf("invalid remote URL format: %s", remoteURL)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if host == "" || len(segments) < 2 {
+		return nil, fmt.Errorf("invalid remote URL format: %s", remoteURL)
+	}
+
+	repo := strings.TrimSuffix(segments[len(segments)-1], ".git")
+	owner := segments[len(segments)-2]
+	if owner == "" || repo == "" {
+		return nil, fmt.Errorf("invalid remote URL format: %s", remoteURL)
+	}
+
+	return &remoteInfo{host: host, owner: owner, repo: repo}, nil
+}
+
+// normalizeGitHubHost maps the hosts github.com is reached at over SSH and
+// HTTPS to github.com
+func normalizeGitHubHost(host string) string {
+	host = strings.ToLower(host)
+	switch host {
+	case "ssh.github.com", "www.github.com":
+		return defaultGitHubHost
+	}
+	return host
+}
+
+// githubAPIURL returns the REST API root of a GitHub host, or an empty
+// string for github.com. GHE.com subdomains serve the API at api.<host>,
+// GitHub Enterprise Server at <host>/api/v3.
+func githubAPIURL(host string) string {
+	switch {
+	case host == "" || host == defaultGitHubHost:
+		return ""
+	case strings.HasSuffix(host, ".ghe.com"):
+		return "https://api." + host + "/"
+	default:
+		return "https://" + host + "/api/v3/"
+	}
+}
+
+// selectRemote returns the remote to read the repository from. An explicitly
+// requested remote is used as is; otherwise "upstream" is preferred when it
+// exists, since in a fork it points at the repository the PRs are opened
+// against, and "origin" is used as the default.
+func selectRemote(requested string) string {
+	if requested != "" {
+		return requested
+	}
+
+	output, err := execCommand("git", "remote").Output()
+	if err == nil {
+		for _, name := range strings.Fields(string(output)) {
+			if name == "upstream" {
+				return "upstream"
+			}
+		}
+	}
+	return "origin"
+}
+
+// getRemoteURL returns the URL of the given remote. `git remote get-url`
+// applies url.<base>.insteadOf rewrites, so it is preferred over reading the
+// raw remote.<name>.url setting.
+func getRemoteURL(remote string) (string, error) {
+	output, err := execCommand("git", "remote", "get-url", remote).Output()
+	if err == nil && strings.TrimSpace(string(output)) != "" {
+		return strings.TrimSpace(string(output)), nil
+	}
+	return getGitConfig("remote." + remote + ".url")
+}
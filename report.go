@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// prOutcome is the decision taken for a PR during a run
+type prOutcome string
+
+const (
+	outcomeSkipped  prOutcome = "skipped"  // Not processed because of a filter or skip rule
+	outcomeMerged   prOutcome = "merged"   // Approved (if configured) and merged
+	outcomeApproved prOutcome = "approved" // Approved, merging is left to a human
+	outcomeBlocked  prOutcome = "blocked"  // Checks failed and nothing could be done automatically
+	outcomePending  prOutcome = "pending"  // Checks still running
+	outcomeUpdated  prOutcome = "updated"  // Branch was updated or rebased, checks will re-run
+	outcomeConflict prOutcome = "conflict" // Branch could not be updated without manual conflict resolution
+	outcomeRetried  prOutcome = "retried"  // Failed jobs were re-run
+	outcomeError    prOutcome = "error"    // Processing failed
+	outcomeClosed   prOutcome = "closed"   // Closed after staying stale for too long
+)
+
+// prReport collects what happened to a single PR during a run. It is written
+// only by the goroutine processing the PR and read once processing is done.
+type prReport struct {
+	number  int
+	title   string
+	author  string
+	url     string
+	baseRef string
+	headRef string
+	headSHA string
+
+	outcome  prOutcome
+	reason   string
+	skipCode string // Stable identifier of the skip rule, used as metric label
+	// inScope is false for PRs skipped because they are not meant to be
+	// handled by this instance at all (drafts, reviewer and author filters)
+	inScope bool
+
+	failedChecks  []string
+	pendingChecks []string
+	behindBy      int
+	rebase        string   // Result of the branch update attempt, if any
+	retriedJobs   []string // Jobs re-run during this run
+
+	previous        *prState // State recorded by earlier runs, nil if unknown or no state store
+	stateLoaded     bool     // Whether previous was read, state is only saved then
+	unchanged       bool     // Skipped because nothing changed since the previous decision
+	approvedSHA     string   // Head approved during this run
+	rebaseAttempted bool     // Whether the branch was updated during this run
+	notified        []string // Per-PR events sent for the head during this run
+
+	policyRule   string       // Name of the policy rule that decided what to do
+	policyAction policyAction // Action of that rule
+	policySHA    string       // Head the policy was evaluated at
+
+	baseHead string // Head of the base branch the decision was based on, if fetched
+
+	dependency *dependencyUpdate // Update proposed by Renovate or Dependabot, nil for other PRs
+
+	mergeable           *bool    // Mergeability reported by GitHub, nil if not fetched or not computed yet
+	conflictFiles       []string // Files conflicting with the base branch, if known
+	conflictFilesLikely bool     // Whether conflictFiles are only files changed on both sides
+
+	queued bool // Green and waiting for the serial merge phase
+
+	branchDeleted  bool   // Whether the head branch was deleted after merging
+	branchDeletion string // Result of deleting the head branch, if attempted
+
+	trace []decisionStep // Evaluations the decision was based on, in order
+
+	locked *github.PullRequest // PR as locked by this instance, nil if not locked
+}
+
+// prReports holds the reports of the current run. It is shared by the
+// per-PR copies of the processor.
+type prReports struct {
+	mu        sync.Mutex
+	byNumber  map[int]*prReport
+	baseHeads map[string]string // Head of each base branch, fetched once per run
+}
+
+// report returns the report of the given PR, creating it on first use
+func (p *PRProcessor) report(pr *github.PullRequest) *prReport {
+	if p.reports == nil {
+		p.reports = &prReports{}
+	}
+	p.reports.mu.Lock()
+	defer p.reports.mu.Unlock()
+	if p.reports.byNumber == nil {
+		p.reports.byNumber = make(map[int]*prReport)
+	}
+	r, ok := p.reports.byNumber[pr.GetNumber()]
+	if !ok {
+		r = &prReport{
+			number:  pr.GetNumber(),
+			inScope: true,
+		}
+		p.reports.byNumber[pr.GetNumber()] = r
+	}
+	r.title = pr.GetTitle()
+	r.author = pr.GetUser().GetLogin()
+	r.url = pr.GetHTMLURL()
+	r.baseRef = pr.GetBase().GetRef()
+	r.headRef = pr.GetHead().GetRef()
+	r.headSHA = pr.GetHead().GetSHA()
+	return r
+}
+
+// reasonChecksFailed is the reason of PRs blocked by failing checks
+const reasonChecksFailed = "status checks failed"
+
+// decide records the outcome for the PR together with a human readable reason
+func (r *prReport) decide(outcome prOutcome, reason string) {
+	r.outcome = outcome
+	r.reason = reason
+}
+
+// Skip rule identifiers
+const (
+	skipDraft         = "draft"
+	skipNoReviewers   = "no_reviewers"
+	skipNotReviewer   = "not_reviewer"
+	skipTitlePattern  = "skip_pattern"
+	skipAuthorPattern = "author_pattern"
+	skipStale         = "stale"
+	skipFreeze        = "freeze"
+	skipLocked        = "locked"
+	skipNotOpen       = "not_open"
+)
+
+// skip records that the PR was skipped by the given rule. Out of scope PRs
+// are not commented on.
+func (r *prReport) skip(code, reason string, inScope bool) {
+	r.decide(outcomeSkipped, reason)
+	r.skipCode = code
+	r.inScope = inScope
+}
+
+// sortedReports returns the reports of all PRs seen during the run ordered by
+// PR number
+func (p *PRProcessor) sortedReports() []*prReport {
+	if p.reports == nil {
+		return nil
+	}
+	p.reports.mu.Lock()
+	defer p.reports.mu.Unlock()
+	reports := make([]*prReport, 0, len(p.reports.byNumber))
+	for _, r := range p.reports.byNumber {
+		reports = append(reports, r)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].number < reports[j].number })
+	return reports
+}
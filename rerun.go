@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// rerunCommentKind identifies the comment tracking re-run attempts of a PR
+const rerunCommentKind = "rerun"
+
+var rerunAttemptsPattern = regexp.MustCompile(`<!-- pr-status-checker:rerun attempts=(\d+) -->`)
+
+// rerunAttempts returns the number of re-runs already spent on the PR
+// together with the comment tracking them, if any.
+func (p *PRProcessor) rerunAttempts(pr *github.PullRequest) (int, *github.IssueComment, error) {
+	comment, err := p.findMarkedComment(pr, rerunCommentKind)
+	if err != nil || comment == nil {
+		return 0, comment, err
+	}
+	m := rerunAttemptsPattern.FindStringSubmatch(comment.GetBody())
+	if m == nil {
+		return 0, comment, nil
+	}
+	attempts, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, comment, nil
+	}
+	return attempts, comment, nil
+}
+
+// failedWorkflowRuns returns the completed GitHub Actions runs for the PR
+// head that failed
+func (p *PRProcessor) failedWorkflowRuns(pr *github.PullRequest) ([]*github.WorkflowRun, error) {
+	opts := &github.ListWorkflowRunsOptions{
+		HeadSHA:     pr.GetHead().GetSHA(),
+		Status:      "completed",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	var failed []*github.WorkflowRun
+	for {
+		runs, resp, err := p.client.Actions.ListRepositoryWorkflowRuns(p.ctx, p.cfg.owner, p.cfg.repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing workflow runs: %v", err)
+		}
+		for _, run := range runs.WorkflowRuns {
+			switch run.GetConclusion() {
+			case "failure", "timed_out":
+				failed = append(failed, run)
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return failed, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// failedJobNames returns the names of the failed jobs of a workflow run
+func (p *PRProcessor) failedJobNames(run *github.WorkflowRun) ([]string, error) {
+	jobs, _, err := p.client.Actions.ListWorkflowJobs(p.ctx, p.cfg.owner, p.cfg.repo, run.GetID(), &github.ListWorkflowJobsOptions{
+		Filter:      "latest",
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing jobs of workflow run %d: %v", run.GetID(), err)
+	}
+
+	var names []string
+	for _, job := range jobs.Jobs {
+		switch job.GetConclusion() {
+		case "failure", "timed_out":
+			names = append(names, job.GetName())
+		}
+	}
+	return names, nil
+}
+
+// rerunFailedJobs re-runs the failed jobs of the GitHub Actions runs for the
+// PR head as long as the PR's retry budget allows. The number of attempts is
+// stored in a hidden marker in a PR comment so that it survives across runs.
+// It reports whether anything was re-run.
+func (p *PRProcessor) rerunFailedJobs(pr *github.PullRequest) (bool, error) {
+	attempts, comment, err := p.rerunAttempts(pr)
+	if err != nil {
+		return false, err
+	}
+	r := p.report(pr)
+	inputs := map[string]string{"attempts": fmt.Sprintf("%d/%d", attempts, p.cfg.rerunFailedJobs)}
+	if attempts >= p.cfg.rerunFailedJobs {
+		fmt.Printf("PR #%d: Re-run budget exhausted (%d/%d attempts)\n", pr.GetNumber(), attempts, p.cfg.rerunFailedJobs)
+		r.record(stageRetry, "rerun-failed-jobs", "budget exhausted", inputs)
+		return false, nil
+	}
+
+	retried, err := p.rerunFailedRuns(pr)
+	if err != nil || len(retried) == 0 {
+		if err == nil {
+			r.record(stageRetry, "rerun-failed-jobs", "no failed GitHub Actions runs", inputs)
+		}
+		return false, err
+	}
+
+	attempts++
+	r.record(stageRetry, "rerun-failed-jobs", "re-ran "+strings.Join(retried, ", "), inputs)
+	r.retriedJobs = retried
+	r.decide(outcomeRetried, fmt.Sprintf("attempt %d of %d", attempts, p.cfg.rerunFailedJobs))
+	fmt.Printf("PR #%d: Re-ran failed jobs (attempt %d/%d): %s\n", pr.GetNumber(), attempts, p.cfg.rerunFailedJobs, strings.Join(retried, ", "))
+
+	body := fmt.Sprintf("%s attempts=%d -->\nRe-ran failed jobs (attempt %d of %d):\n", commentMarker(rerunCommentKind), attempts, attempts, p.cfg.rerunFailedJobs)
+	for _, name := range retried {
+		body += fmt.Sprintf("- %s\n", name)
+	}
+	if err := p.upsertMarkedComment(pr, comment, body); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// rerunFailedRuns re-runs the failed jobs of the GitHub Actions runs for the
+// PR head, regardless of the retry budget, and returns the names of the
+// re-run jobs
+func (p *PRProcessor) rerunFailedRuns(pr *github.PullRequest) ([]string, error) {
+	runs, err := p.failedWorkflowRuns(pr)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		fmt.Printf("PR #%d: No failed GitHub Actions runs to re-run\n", pr.GetNumber())
+		return nil, nil
+	}
+
+	var retried []string
+	for _, run := range runs {
+		jobs, err := p.failedJobNames(run)
+		if err != nil {
+			return nil, err
+		}
+		if !p.dryRun(pr, "re-run failed jobs of %s", run.GetName()) {
+			if _, err := p.client.Actions.RerunFailedJobsByID(p.ctx, p.cfg.owner, p.cfg.repo, run.GetID()); err != nil {
+				return nil, fmt.Errorf("error re-running workflow run %d: %v", run.GetID(), err)
+			}
+		}
+		if len(jobs) == 0 {
+			retried = append(retried, run.GetName())
+		}
+		for _, job := range jobs {
+			retried = append(retried, run.GetName()+" / "+job)
+		}
+	}
+	return retried, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// fakeRerunAPI serves the workflow run, job and comment endpoints used when
+// re-running failed jobs and records what was re-run and commented
+type fakeRerunAPI struct {
+	mu       sync.Mutex
+	runs     []*github.WorkflowRun
+	jobs     map[string][]*github.WorkflowJob
+	comments commentStore
+	reruns   []string
+}
+
+func (f *fakeRerunAPI) transport(t *testing.T) http.RoundTripper {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/actions/runs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("head_sha") != "test-sha" {
+			t.Errorf("Expected runs to be listed for test-sha, got %s", r.URL.RawQuery)
+		}
+		writeJSON(t, w, &github.WorkflowRuns{TotalCount: github.Ptr(len(f.runs)), WorkflowRuns: f.runs})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/actions/runs/{id}/jobs", func(w http.ResponseWriter, r *http.Request) {
+		jobs := f.jobs[r.PathValue("id")]
+		writeJSON(t, w, &github.Jobs{TotalCount: github.Ptr(len(jobs)), Jobs: jobs})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/actions/runs/{id}/rerun-failed-jobs", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.reruns = append(f.reruns, r.PathValue("id"))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	f.comments.register(t, mux)
+	return &handlerTransport{handler: mux}
+}
+
+func TestRerunFailedJobs(t *testing.T) {
+	failedRuns := []*github.WorkflowRun{
+		{ID: github.Ptr[int64](10), Name: github.Ptr("CI"), Status: github.Ptr("completed"), Conclusion: github.Ptr("failure")},
+		{ID: github.Ptr[int64](11), Name: github.Ptr("Lint"), Status: github.Ptr("completed"), Conclusion: github.Ptr("success")},
+	}
+	jobs := map[string][]*github.WorkflowJob{
+		"10": {
+			{Name: github.Ptr("test"), Conclusion: github.Ptr("failure")},
+			{Name: github.Ptr("build"), Conclusion: github.Ptr("success")},
+		},
+	}
+
+	testCases := []struct {
+		name             string
+		runs             []*github.WorkflowRun
+		existingComment  string
+		budget           int
+		expectedRetried  bool
+		expectedReruns   []string
+		expectedComment  string
+		expectedComments int
+		expectedEdits    int
+	}{
+		{
+			name:             "first attempt creates tracking comment",
+			runs:             failedRuns,
+			budget:           2,
+			expectedRetried:  true,
+			expectedReruns:   []string{"10"},
+			expectedComment:  "<!-- pr-status-checker:rerun attempts=1 -->",
+			expectedComments: 1,
+		},
+		{
+			name:             "second attempt edits tracking comment",
+			runs:             failedRuns,
+			existingComment:  "<!-- pr-status-checker:rerun attempts=1 -->\nRe-ran failed jobs",
+			budget:           2,
+			expectedRetried:  true,
+			expectedReruns:   []string{"10"},
+			expectedComment:  "<!-- pr-status-checker:rerun attempts=2 -->",
+			expectedComments: 1,
+			expectedEdits:    1,
+		},
+		{
+			name:             "budget exhausted",
+			runs:             failedRuns,
+			existingComment:  "<!-- pr-status-checker:rerun attempts=2 -->\nRe-ran failed jobs",
+			budget:           2,
+			expectedRetried:  false,
+			expectedComment:  "<!-- pr-status-checker:rerun attempts=2 -->",
+			expectedComments: 1,
+		},
+		{
+			name:             "no failed workflow runs",
+			runs:             failedRuns[1:],
+			budget:           2,
+			expectedRetried:  false,
+			expectedComments: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeRerunAPI{runs: tc.runs, jobs: jobs}
+			if tc.existingComment != "" {
+				api.comments.comments = []*github.IssueComment{
+					{ID: github.Ptr[int64](2), Body: github.Ptr(tc.existingComment), User: commentAuthor},
+				}
+			}
+
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: api.transport(t)}),
+				cfg:    &config{owner: testOwner, repo: testRepo, botLogin: commentAuthor.GetLogin(), rerunFailedJobs: tc.budget},
+				ctx:    context.Background(),
+			}
+			pr := &github.PullRequest{Number: github.Ptr(1), Head: &github.PullRequestBranch{SHA: github.Ptr("test-sha")}}
+
+			retried, err := processor.rerunFailedJobs(pr)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if retried != tc.expectedRetried {
+				t.Errorf("Expected retried to be %v, got %v", tc.expectedRetried, retried)
+			}
+			if strings.Join(api.reruns, ",") != strings.Join(tc.expectedReruns, ",") {
+				t.Errorf("Expected reruns %v, got %v", tc.expectedReruns, api.reruns)
+			}
+			comments := api.comments.list()
+			if len(comments) != tc.expectedComments {
+				t.Fatalf("Expected %d comments, got %d", tc.expectedComments, len(comments))
+			}
+			if api.comments.edits != tc.expectedEdits {
+				t.Errorf("Expected %d edits, got %d", tc.expectedEdits, api.comments.edits)
+			}
+			if tc.expectedComment != "" && !strings.Contains(comments[0].GetBody(), tc.expectedComment) {
+				t.Errorf("Expected comment to contain %q, got %q", tc.expectedComment, comments[0].GetBody())
+			}
+			if tc.expectedRetried && !strings.Contains(comments[0].GetBody(), "CI / test") {
+				t.Errorf("Expected comment to list the retried job, got %q", comments[0].GetBody())
+			}
+		})
+	}
+}
+
+func TestHandleFailedChecks_RerunBeforeRebase(t *testing.T) {
+	api := &fakeRerunAPI{
+		runs: []*github.WorkflowRun{
+			{ID: github.Ptr[int64](10), Name: github.Ptr("CI"), Status: github.Ptr("completed"), Conclusion: github.Ptr("failure")},
+		},
+	}
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: api.transport(t)}),
+		cfg:    &config{owner: testOwner, repo: testRepo, botLogin: commentAuthor.GetLogin(), rerunFailedJobs: 1, autoRebase: true},
+		ctx:    context.Background(),
+	}
+	pr := &github.PullRequest{
+		Number: github.Ptr(1),
+		Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+		Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha")},
+	}
+
+	// The compare endpoint is not served, so reaching the rebase step would fail
+	if err := processor.handleFailedChecks(pr, []string{"CI / test"}, nil); err != nil {
+		t.Fatalf("Expected rebase to be skipped after a re-run, got %v", err)
+	}
+	if len(api.reruns) != 1 {
+		t.Errorf("Expected one re-run, got %d", len(api.reruns))
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// staleCommentKind identifies the warning comment posted on stale PRs
+const staleCommentKind = "stale"
+
+const defaultStaleLabel = "stale"
+
+// staleActivitySlack is how much later than the warning comment a PR may be
+// updated without it counting as new activity. Adding the comment itself
+// bumps the update time of the PR.
+const staleActivitySlack = time.Minute
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// staleExempt reports whether the PR is never marked stale because of its
+// labels or author
+func (p *PRProcessor) staleExempt(pr *github.PullRequest) bool {
+	for _, label := range splitList(p.cfg.staleExemptLabels) {
+		if hasLabel(pr, label) {
+			return true
+		}
+	}
+	for _, author := range splitList(p.cfg.staleExemptAuthors) {
+		if strings.EqualFold(pr.GetUser().GetLogin(), author) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PRProcessor) staleLabel() string {
+	if p.cfg.staleLabel == "" {
+		return defaultStaleLabel
+	}
+	return p.cfg.staleLabel
+}
+
+// handleStalePRs labels and warns PRs without activity for -stale-days, and
+// closes them once the warning is -stale-close-days old. PRs outside the
+// reviewer and author filters are left alone. It returns the PRs that still
+// need processing; stale and closed PRs are reported as such. Failing PRs are
+// reported as errors and don't stop the others.
+func (p *PRProcessor) handleStalePRs(prs []*github.PullRequest) ([]*github.PullRequest, []error) {
+	var active []*github.PullRequest
+	var errs []error
+	for _, pr := range prs {
+		inScope, err := p.inScope(pr)
+		if err == nil && !inScope {
+			active = append(active, pr)
+			continue
+		}
+		stale := false
+		if err == nil {
+			stale, err = p.handleStalePR(pr)
+		}
+		if err != nil {
+			log.Printf("Error handling stale PR #%d: %v", pr.GetNumber(), err)
+			errs = append(errs, fmt.Errorf("PR #%d: %w", pr.GetNumber(), err))
+			p.report(pr).decide(outcomeError, err.Error())
+			continue
+		}
+		if !stale {
+			active = append(active, pr)
+		}
+	}
+	return active, errs
+}
+
+// handleStalePR applies the stale rules to a single PR and reports whether
+// it is stale
+func (p *PRProcessor) handleStalePR(pr *github.PullRequest) (bool, error) {
+	label := p.staleLabel()
+	if p.staleExempt(pr) {
+		// Exempted after being marked stale, it must not be closed later
+		if hasLabel(pr, label) {
+			return false, p.unmarkStale(pr, label)
+		}
+		return false, nil
+	}
+	staleAfter := time.Duration(p.cfg.staleDays) * 24 * time.Hour
+	closeAfter := time.Duration(p.cfg.staleCloseDays) * 24 * time.Hour
+	r := p.report(pr)
+
+	if hasLabel(pr, label) {
+		warning, err := p.findMarkedComment(pr, staleCommentKind)
+		if err != nil {
+			return false, err
+		}
+		warnedAt := pr.GetUpdatedAt().Time
+		if warning != nil {
+			warnedAt = warning.GetCreatedAt().Time
+		}
+
+		// Any activity since the warning makes the PR active again
+		if pr.GetUpdatedAt().Time.After(warnedAt.Add(staleActivitySlack)) {
+			fmt.Printf("PR #%d: Active again, removing label %s\n", pr.GetNumber(), label)
+			if !p.dryRun(pr, "remove label %s", label) {
+				if _, err := p.client.Issues.RemoveLabelForIssue(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), label); err != nil {
+					return false, fmt.Errorf("error removing label: %v", err)
+				}
+			}
+			return false, nil
+		}
+
+		if closeAfter > 0 && time.Since(warnedAt) >= closeAfter {
+			fmt.Printf("PR #%d: Closing, stale for %d days since the warning\n", pr.GetNumber(), p.cfg.staleCloseDays)
+			reason := fmt.Sprintf("no activity for %d days after being marked stale", p.cfg.staleCloseDays)
+			if p.dryRun(pr, "close the PR") {
+				r.decide(outcomeClosed, reason+" (dry run)")
+				return true, nil
+			}
+			// Another instance may be merging the PR right now
+			locked, err := p.lockPR(pr)
+			if err != nil {
+				return false, err
+			}
+			if !locked {
+				// Reported as locked, the holder takes care of the PR
+				return true, nil
+			}
+			defer p.unlockPR(pr)
+			body := fmt.Sprintf("%s -->\nClosing this PR after %d more days without activity. Feel free to reopen it.\n",
+				commentMarker(staleCommentKind+"-closed"), p.cfg.staleCloseDays)
+			if err := p.upsertMarkedComment(pr, nil, body); err != nil {
+				return false, err
+			}
+			if _, _, err := p.client.PullRequests.Edit(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), &github.PullRequest{State: github.Ptr("closed")}); err != nil {
+				return false, fmt.Errorf("error closing PR: %v", err)
+			}
+			r.decide(outcomeClosed, reason)
+			return true, nil
+		}
+
+		r.skip(skipStale, "stale, waiting for activity", false)
+		return true, nil
+	}
+
+	inactive := time.Since(pr.GetUpdatedAt().Time)
+	if inactive < staleAfter {
+		return false, nil
+	}
+
+	fmt.Printf("PR #%d: No activity for %d days, marking as stale\n", pr.GetNumber(), int(inactive.Hours()/24))
+	reason := fmt.Sprintf("marked stale after %d days without activity", p.cfg.staleDays)
+	if p.dryRun(pr, "add label %s and warn the author", label) {
+		r.skip(skipStale, reason+" (dry run)", false)
+		return true, nil
+	}
+	if _, _, err := p.client.Issues.AddLabelsToIssue(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), []string{label}); err != nil {
+		return false, fmt.Errorf("error adding label: %v", err)
+	}
+
+	// The comment is posted last so that its creation time marks the warning
+	body := fmt.Sprintf("%s -->\n@%s this PR has had no activity for %d days and has been marked as `%s`.",
+		commentMarker(staleCommentKind), pr.GetUser().GetLogin(), p.cfg.staleDays, label)
+	if closeAfter > 0 {
+		body += fmt.Sprintf(" It will be closed in %d days unless there is new activity.", p.cfg.staleCloseDays)
+	} else {
+		body += " Push, comment or remove the label to keep it open."
+	}
+	existing, err := p.findMarkedComment(pr, staleCommentKind)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		// An old warning from an earlier stale period; post a fresh one
+		// so that its creation time marks this warning
+		if _, err := p.client.Issues.DeleteComment(p.ctx, p.cfg.owner, p.cfg.repo, existing.GetID()); err != nil {
+			return false, fmt.Errorf("error deleting comment: %v", err)
+		}
+	}
+	if err := p.upsertMarkedComment(pr, nil, body+"\n"); err != nil {
+		return false, err
+	}
+	r.skip(skipStale, reason, false)
+	return true, nil
+}
+
+// unmarkStale removes the stale label and the warning comment from a PR that
+// is exempt from the stale rules
+func (p *PRProcessor) unmarkStale(pr *github.PullRequest, label string) error {
+	fmt.Printf("PR #%d: Exempt from the stale rules, removing label %s\n", pr.GetNumber(), label)
+	if p.dryRun(pr, "remove label %s and the stale warning", label) {
+		return nil
+	}
+	if _, err := p.client.Issues.RemoveLabelForIssue(p.ctx, p.cfg.owner, p.cfg.repo, pr.GetNumber(), label); err != nil {
+		return fmt.Errorf("error removing label: %v", err)
+	}
+	warning, err := p.findMarkedComment(pr, staleCommentKind)
+	if err != nil {
+		return err
+	}
+	if warning != nil {
+		if _, err := p.client.Issues.DeleteComment(p.ctx, p.cfg.owner, p.cfg.repo, warning.GetID()); err != nil {
+			return fmt.Errorf("error deleting comment: %v", err)
+		}
+	}
+	return nil
+}
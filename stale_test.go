@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestHandleStalePR(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name            string
+		labels          []string
+		author          string
+		updatedAt       time.Time
+		warnedAt        time.Time // Zero if there is no warning comment
+		dryRun          bool
+		expectStale     bool
+		expectedOutcome prOutcome
+		expectedCalls   []string // "METHOD path" of the changes made
+	}{
+		{
+			name:            "inactive PR is labeled and warned",
+			updatedAt:       now.Add(-40 * 24 * time.Hour),
+			expectStale:     true,
+			expectedOutcome: outcomeSkipped,
+			expectedCalls:   []string{"POST /repos/test-owner/test-repo/issues/1/labels", "POST /repos/test-owner/test-repo/issues/1/comments"},
+		},
+		{
+			name:      "recently active PR is left alone",
+			updatedAt: now.Add(-2 * 24 * time.Hour),
+		},
+		{
+			name:      "exempt label",
+			labels:    []string{"pinned"},
+			updatedAt: now.Add(-40 * 24 * time.Hour),
+		},
+		{
+			name:      "exempt author",
+			author:    "dependabot[bot]",
+			updatedAt: now.Add(-40 * 24 * time.Hour),
+		},
+		{
+			name:      "exempt PR loses the stale label and warning",
+			labels:    []string{"stale", "pinned"},
+			updatedAt: now.Add(-10 * 24 * time.Hour),
+			warnedAt:  now.Add(-10 * 24 * time.Hour),
+			expectedCalls: []string{
+				"DELETE /repos/test-owner/test-repo/issues/1/labels/stale",
+				"DELETE /repos/test-owner/test-repo/issues/comments/1",
+			},
+		},
+		{
+			name:      "dry run keeps the stale label of an exempt PR",
+			labels:    []string{"stale", "pinned"},
+			updatedAt: now.Add(-10 * 24 * time.Hour),
+			warnedAt:  now.Add(-10 * 24 * time.Hour),
+			dryRun:    true,
+		},
+		{
+			name:            "stale PR is closed after the grace period",
+			labels:          []string{"stale"},
+			updatedAt:       now.Add(-10 * 24 * time.Hour),
+			warnedAt:        now.Add(-10 * 24 * time.Hour),
+			expectStale:     true,
+			expectedOutcome: outcomeClosed,
+			expectedCalls:   []string{"POST /repos/test-owner/test-repo/issues/1/comments", "PATCH /repos/test-owner/test-repo/pulls/1"},
+		},
+		{
+			name:            "stale PR within the grace period stays skipped",
+			labels:          []string{"stale"},
+			updatedAt:       now.Add(-3 * 24 * time.Hour),
+			warnedAt:        now.Add(-3 * 24 * time.Hour),
+			expectStale:     true,
+			expectedOutcome: outcomeSkipped,
+		},
+		{
+			name:          "activity after the warning removes the label",
+			labels:        []string{"stale"},
+			updatedAt:     now.Add(-time.Hour),
+			warnedAt:      now.Add(-3 * 24 * time.Hour),
+			expectedCalls: []string{"DELETE /repos/test-owner/test-repo/issues/1/labels/stale"},
+		},
+		{
+			name:            "dry run changes nothing",
+			updatedAt:       now.Add(-40 * 24 * time.Hour),
+			dryRun:          true,
+			expectStale:     true,
+			expectedOutcome: outcomeSkipped,
+		},
+		{
+			name:            "dry run does not close",
+			labels:          []string{"stale"},
+			updatedAt:       now.Add(-10 * 24 * time.Hour),
+			warnedAt:        now.Add(-10 * 24 * time.Hour),
+			dryRun:          true,
+			expectStale:     true,
+			expectedOutcome: outcomeClosed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var comments []string
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /repos/test-owner/test-repo/issues/1/comments", func(w http.ResponseWriter, _ *http.Request) {
+				var existing []*github.IssueComment
+				if !tc.warnedAt.IsZero() {
+					existing = append(existing, &github.IssueComment{
+						ID:        github.Ptr(int64(1)),
+						Body:      github.Ptr(commentMarker(staleCommentKind) + " -->\nStale"),
+						CreatedAt: &github.Timestamp{Time: tc.warnedAt},
+						User:      commentAuthor,
+					})
+				}
+				writeJSON(t, w, existing)
+			})
+			mux.HandleFunc("POST /repos/test-owner/test-repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+				var comment github.IssueComment
+				if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+					t.Errorf("Failed to decode comment: %v", err)
+				}
+				comments = append(comments, comment.GetBody())
+				writeJSON(t, w, &comment)
+			})
+			mux.HandleFunc("POST /repos/test-owner/test-repo/issues/1/labels", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, []*github.Label{{Name: github.Ptr("stale")}})
+			})
+			mux.HandleFunc("DELETE /repos/test-owner/test-repo/issues/1/labels/stale", func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			mux.HandleFunc("DELETE /repos/test-owner/test-repo/issues/comments/1", func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc("PATCH /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.PullRequest{Number: github.Ptr(1), State: github.Ptr("closed")})
+			})
+			transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg: &config{
+					owner:              testOwner,
+					repo:               testRepo,
+					botLogin:           commentAuthor.GetLogin(),
+					staleDays:          30,
+					staleCloseDays:     7,
+					staleLabel:         "stale",
+					staleExemptLabels:  "pinned, security",
+					staleExemptAuthors: "dependabot[bot]",
+					dryRun:             tc.dryRun,
+				},
+				ctx: context.Background(),
+			}
+			author := tc.author
+			if author == "" {
+				author = "octocat"
+			}
+			pr := &github.PullRequest{
+				Number:    github.Ptr(1),
+				User:      &github.User{Login: github.Ptr(author)},
+				UpdatedAt: &github.Timestamp{Time: tc.updatedAt},
+			}
+			for _, label := range tc.labels {
+				pr.Labels = append(pr.Labels, &github.Label{Name: github.Ptr(label)})
+			}
+
+			stale, err := processor.handleStalePR(pr)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if stale != tc.expectStale {
+				t.Errorf("Expected stale %v, got %v", tc.expectStale, stale)
+			}
+			if r := processor.report(pr); r.outcome != tc.expectedOutcome {
+				t.Errorf("Expected outcome %q, got %q (%s)", tc.expectedOutcome, r.outcome, r.reason)
+			}
+
+			var changes []string
+			for _, req := range transport.requests {
+				if req.method != http.MethodGet {
+					changes = append(changes, req.method+" "+req.path)
+				}
+			}
+			if strings.Join(changes, ", ") != strings.Join(tc.expectedCalls, ", ") {
+				t.Errorf("Expected changes %v, got %v", tc.expectedCalls, changes)
+			}
+			if tc.expectedOutcome == outcomeSkipped && len(comments) > 0 && !strings.Contains(comments[0], "closed in 7 days") {
+				t.Errorf("Expected warning to mention the grace period, got %q", comments[0])
+			}
+		})
+	}
+}
+
+func TestHandleStalePRs(t *testing.T) {
+	longAgo := &github.Timestamp{Time: time.Now().Add(-40 * 24 * time.Hour)}
+	newPR := func(number int, author string, labels ...string) *github.PullRequest {
+		pr := &github.PullRequest{
+			Number:    github.Ptr(number),
+			User:      &github.User{Login: github.Ptr(author)},
+			UpdatedAt: longAgo,
+			Head:      &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+		}
+		for _, label := range labels {
+			pr.Labels = append(pr.Labels, &github.Label{Name: github.Ptr(label)})
+		}
+		return pr
+	}
+	prs := []*github.PullRequest{
+		newPR(1, "octocat", "stale"), // Due for closing, but locked by another instance
+		newPR(2, "outsider"),         // Outside the author filter
+		newPR(3, "octocat"),          // Labeling fails
+		newPR(4, "octocat"),          // Marked stale
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/issues/{number}/comments", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.IssueComment{})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/issues/{number}/comments", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.IssueComment{})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/issues/{number}/labels", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("number") == "3" {
+			http.Error(w, `{"message": "Server Error"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(t, w, []*github.Label{{Name: github.Ptr("stale")}})
+	})
+	transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+	dir := t.TempDir()
+	other := &fileLocker{dir: dir, repo: testOwner + "/" + testRepo}
+	if acquired, _, err := other.Acquire(context.Background(), prs[0], "cron", time.Minute); err != nil || !acquired {
+		t.Fatalf("Failed to lock the PR: %v", err)
+	}
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg: &config{
+			owner:          testOwner,
+			repo:           testRepo,
+			botLogin:       commentAuthor.GetLogin(),
+			authorPattern:  "^octocat$",
+			staleDays:      30,
+			staleCloseDays: 7,
+			lockBackend:    lockBackendFile,
+			lockTTL:        time.Minute,
+		},
+		ctx:       context.Background(),
+		locker:    &fileLocker{dir: dir, repo: testOwner + "/" + testRepo},
+		lockOwner: "actions",
+	}
+
+	active, errs := processor.handleStalePRs(prs)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "PR #3:") {
+		t.Errorf("Expected an error for PR #3 only, got %v", errs)
+	}
+	if len(active) != 1 || active[0].GetNumber() != 2 {
+		t.Errorf("Expected only the PR outside the author filter to stay active, got %v", active)
+	}
+
+	expected := map[int]prOutcome{1: outcomeSkipped, 2: "", 3: outcomeError, 4: outcomeSkipped}
+	for _, pr := range prs {
+		if r := processor.report(pr); r.outcome != expected[pr.GetNumber()] {
+			t.Errorf("PR #%d: expected %q, got %q (%s)", pr.GetNumber(), expected[pr.GetNumber()], r.outcome, r.reason)
+		}
+	}
+	if r := processor.report(prs[0]); r.skipCode != skipLocked {
+		t.Errorf("Expected the locked PR to be skipped as locked, got %s", r.skipCode)
+	}
+	if calls := transport.find("PATCH", "/repos/test-owner/test-repo/pulls/1"); len(calls) != 0 {
+		t.Errorf("Expected the locked PR not to be closed, got %d calls", len(calls))
+	}
+	for _, req := range transport.requests {
+		if strings.Contains(req.path, "/issues/2/") {
+			t.Errorf("Expected the PR outside the author filter to be left alone, got %s %s", req.method, req.path)
+		}
+	}
+	if calls := transport.find("POST", "/repos/test-owner/test-repo/issues/4/labels"); len(calls) != 1 {
+		t.Errorf("Expected the PR after the failing one to be labeled, got %d calls", len(calls))
+	}
+}
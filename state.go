@@ -0,0 +1,401 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+	_ "modernc.org/sqlite" // SQLite driver for the sqlite state backend
+)
+
+// Supported state store backends
+const (
+	stateBackendJSON   = "json"   // Single JSON file rewritten on every save
+	stateBackendSQLite = "sqlite" // SQLite database, safe to share between concurrent runs
+)
+
+const defaultStateRecheckAfter = time.Hour
+
+// prState is what is remembered about a PR across runs
+type prState struct {
+	Repo           string    `json:"repo"`
+	Number         int       `json:"number"`
+	HeadSHA        string    `json:"head_sha"`        // Head the last decision was based on
+	BaseSHA        string    `json:"base_sha"`        // Head of the base branch the last decision was based on
+	Decision       string    `json:"decision"`        // Outcome of the last evaluation
+	Reason         string    `json:"reason"`          // Reason given for the last decision
+	ApprovedSHA    string    `json:"approved_sha"`    // Head that was last approved
+	RebaseAttempts int       `json:"rebase_attempts"` // Branch updates performed so far
+	FirstSeen      time.Time `json:"first_seen"`      // First run that saw the PR
+	LastSeen       time.Time `json:"last_seen"`       // Last run that saw the PR
+	DecidedAt      time.Time `json:"decided_at"`      // When the last decision was taken
+	LastRebaseAt   time.Time `json:"last_rebase_at"`  // When the branch was last updated
+	NotifiedSHA    string    `json:"notified_sha"`    // Head the notified events were sent for
+	Notified       []string  `json:"notified"`        // Per-PR events sent for NotifiedSHA
+}
+
+// stateStore persists per-PR state between runs
+type stateStore interface {
+	// Load returns the state of the PR, or nil if it was never seen
+	Load(repo string, number int) (*prState, error)
+	Save(state *prState) error
+	Close() error
+}
+
+// openStateStore opens the state store of the given backend at path
+func openStateStore(backend, path string) (stateStore, error) {
+	switch backend {
+	case stateBackendJSON:
+		return openJSONStateStore(path)
+	case stateBackendSQLite:
+		return openSQLiteStateStore(path)
+	default:
+		return nil, fmt.Errorf("invalid state backend %q: must be %q or %q", backend, stateBackendJSON, stateBackendSQLite)
+	}
+}
+
+func stateKey(repo string, number int) string {
+	return repo + "#" + strconv.Itoa(number)
+}
+
+// jsonStateStore keeps all states in memory and rewrites the file on save
+type jsonStateStore struct {
+	mu     sync.Mutex
+	path   string
+	states map[string]*prState
+}
+
+func openJSONStateStore(path string) (*jsonStateStore, error) {
+	s := &jsonStateStore{path: path, states: make(map[string]*prState)}
+	data, err := os.ReadFile(path) // #nosec G304 -- path is supplied by the user on purpose
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var states []*prState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	for _, state := range states {
+		s.states[stateKey(state.Repo, state.Number)] = state
+	}
+	return s, nil
+}
+
+func (s *jsonStateStore) Load(repo string, number int) (*prState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[stateKey(repo, number)]
+	if !ok {
+		return nil, nil
+	}
+	loaded := *state
+	return &loaded, nil
+}
+
+func (s *jsonStateStore) Save(state *prState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *state
+	s.states[stateKey(state.Repo, state.Number)] = &saved
+
+	states := make([]*prState, 0, len(s.states))
+	for _, st := range s.states {
+		states = append(states, st)
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a
+	// truncated state file behind
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+func (s *jsonStateStore) Close() error {
+	return nil
+}
+
+// sqliteStateStore keeps one row per PR in a SQLite database
+type sqliteStateStore struct {
+	db *sql.DB
+}
+
+const sqliteStateSchema = `CREATE TABLE IF NOT EXISTS pr_state (
+	repo            TEXT    NOT NULL,
+	number          INTEGER NOT NULL,
+	head_sha        TEXT    NOT NULL,
+	base_sha        TEXT    NOT NULL,
+	decision        TEXT    NOT NULL,
+	reason          TEXT    NOT NULL,
+	approved_sha    TEXT    NOT NULL,
+	rebase_attempts INTEGER NOT NULL,
+	first_seen      TEXT    NOT NULL,
+	last_seen       TEXT    NOT NULL,
+	decided_at      TEXT    NOT NULL,
+	last_rebase_at  TEXT    NOT NULL,
+	notified_sha    TEXT    NOT NULL DEFAULT '',
+	notified        TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (repo, number)
+)`
+
+// sqliteStateColumns are the columns added to pr_state after its first
+// release, with their definitions, for databases created before
+var sqliteStateColumns = []struct{ name, definition string }{
+	{"notified_sha", "TEXT NOT NULL DEFAULT ''"},
+	{"notified", "TEXT NOT NULL DEFAULT ''"},
+}
+
+func openSQLiteStateStore(path string) (*sqliteStateStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database: %v", err)
+	}
+	// PRs are processed concurrently; let SQLite wait for the write lock
+	// instead of failing with SQLITE_BUSY
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to configure state database: %v", err)
+	}
+	if _, err := db.Exec(sqliteStateSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create state table: %v", err)
+	}
+	if err := migrateSQLiteState(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &sqliteStateStore{db: db}, nil
+}
+
+// migrateSQLiteState adds the columns missing from a pr_state table created
+// by an older release
+func migrateSQLiteState(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('pr_state')")
+	if err != nil {
+		return fmt.Errorf("failed to read state table: %v", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to read state table: %v", err)
+		}
+		existing[name] = true
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read state table: %v", err)
+	}
+
+	for _, column := range sqliteStateColumns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE pr_state ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			return fmt.Errorf("failed to migrate state table: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStateStore) Load(repo string, number int) (*prState, error) {
+	state := &prState{Repo: repo, Number: number}
+	var firstSeen, lastSeen, decidedAt, lastRebaseAt, notified string
+	err := s.db.QueryRow(`SELECT head_sha, base_sha, decision, reason, approved_sha, rebase_attempts,
+		first_seen, last_seen, decided_at, last_rebase_at, notified_sha, notified FROM pr_state WHERE repo = ? AND number = ?`, repo, number).
+		Scan(&state.HeadSHA, &state.BaseSHA, &state.Decision, &state.Reason, &state.ApprovedSHA, &state.RebaseAttempts,
+			&firstSeen, &lastSeen, &decidedAt, &lastRebaseAt, &state.NotifiedSHA, &notified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state of PR #%d: %v", number, err)
+	}
+
+	for _, field := range []struct {
+		raw   string
+		value *time.Time
+	}{
+		{firstSeen, &state.FirstSeen},
+		{lastSeen, &state.LastSeen},
+		{decidedAt, &state.DecidedAt},
+		{lastRebaseAt, &state.LastRebaseAt},
+	} {
+		if field.raw == "" {
+			continue
+		}
+		if *field.value, err = time.Parse(time.RFC3339Nano, field.raw); err != nil {
+			return nil, fmt.Errorf("invalid timestamp in state of PR #%d: %v", number, err)
+		}
+	}
+	if notified != "" {
+		state.Notified = strings.Split(notified, ",")
+	}
+	return state, nil
+}
+
+func (s *sqliteStateStore) Save(state *prState) error {
+	_, err := s.db.Exec(`INSERT INTO pr_state (repo, number, head_sha, base_sha, decision, reason, approved_sha,
+		rebase_attempts, first_seen, last_seen, decided_at, last_rebase_at, notified_sha, notified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (repo, number) DO UPDATE SET
+			head_sha = excluded.head_sha, base_sha = excluded.base_sha, decision = excluded.decision,
+			reason = excluded.reason, approved_sha = excluded.approved_sha, rebase_attempts = excluded.rebase_attempts,
+			first_seen = excluded.first_seen, last_seen = excluded.last_seen, decided_at = excluded.decided_at,
+			last_rebase_at = excluded.last_rebase_at, notified_sha = excluded.notified_sha, notified = excluded.notified`,
+		state.Repo, state.Number, state.HeadSHA, state.BaseSHA, state.Decision, state.Reason, state.ApprovedSHA,
+		state.RebaseAttempts, formatStateTime(state.FirstSeen), formatStateTime(state.LastSeen),
+		formatStateTime(state.DecidedAt), formatStateTime(state.LastRebaseAt), state.NotifiedSHA, strings.Join(state.Notified, ","))
+	if err != nil {
+		return fmt.Errorf("failed to save state of PR #%d: %v", state.Number, err)
+	}
+	return nil
+}
+
+func (s *sqliteStateStore) Close() error {
+	return s.db.Close()
+}
+
+func formatStateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// loadState reads what earlier runs recorded about the PR into its report
+func (p *PRProcessor) loadState(pr *github.PullRequest) (*prState, error) {
+	if p.state == nil {
+		return nil, nil
+	}
+	state, err := p.state.Load(p.cfg.owner+"/"+p.cfg.repo, pr.GetNumber())
+	if err != nil {
+		return nil, err
+	}
+	r := p.report(pr)
+	r.previous, r.stateLoaded = state, true
+	return state, nil
+}
+
+// baseBranchHead returns the current head of the base branch. It is fetched
+// once per run and base branch, the PR only records where it branched off.
+func (p *PRProcessor) baseBranchHead(base string) (string, error) {
+	if p.reports == nil {
+		p.reports = &prReports{}
+	}
+	p.reports.mu.Lock()
+	head, ok := p.reports.baseHeads[base]
+	p.reports.mu.Unlock()
+	if ok {
+		return head, nil
+	}
+
+	branch, _, err := p.client.Repositories.GetBranch(p.ctx, p.cfg.owner, p.cfg.repo, base, 0)
+	if err != nil {
+		return "", fmt.Errorf("error getting base branch %s: %v", base, err)
+	}
+	head = branch.GetCommit().GetSHA()
+	p.reports.mu.Lock()
+	defer p.reports.mu.Unlock()
+	if p.reports.baseHeads == nil {
+		p.reports.baseHeads = make(map[string]string)
+	}
+	p.reports.baseHeads[base] = head
+	return head, nil
+}
+
+// unchangedSinceLastRun reports whether the PR can be skipped because neither
+// its head nor the head of its base branch moved since a decision that only a
+// push can change (failing checks, conflicts) was taken. Such PRs are still
+// re-evaluated once stateRecheckAfter has passed, e.g. to notice checks that
+// were re-run by hand.
+func (p *PRProcessor) unchangedSinceLastRun(pr *github.PullRequest, state *prState) bool {
+	baseHead := p.report(pr).baseHead
+	if state == nil || state.HeadSHA != pr.GetHead().GetSHA() || baseHead == "" || state.BaseSHA != baseHead {
+		return false
+	}
+	checksFailed := prOutcome(state.Decision) == outcomeBlocked && state.Reason == reasonChecksFailed
+	if !checksFailed && prOutcome(state.Decision) != outcomeConflict {
+		return false
+	}
+	recheckAfter := p.cfg.stateRecheckAfter
+	if recheckAfter <= 0 {
+		recheckAfter = defaultStateRecheckAfter
+	}
+	return time.Since(state.DecidedAt) < recheckAfter
+}
+
+// saveState records the outcome of this run for the PR. PRs whose state was
+// never loaded are not saved, as that would replace what earlier runs
+// recorded.
+func (p *PRProcessor) saveState(pr *github.PullRequest) error {
+	// Dry runs don't take decisions worth remembering
+	if p.state == nil || p.cfg.dryRun {
+		return nil
+	}
+	r := p.report(pr)
+	if !r.stateLoaded {
+		return nil
+	}
+	now := time.Now()
+
+	state := prState{Repo: p.cfg.owner + "/" + p.cfg.repo, Number: r.number}
+	if r.previous != nil {
+		state = *r.previous
+	}
+	if state.FirstSeen.IsZero() {
+		state.FirstSeen = now
+	}
+	state.LastSeen = now
+	if !r.unchanged {
+		state.HeadSHA = r.headSHA
+		state.BaseSHA = r.baseHead
+		state.Decision = string(r.outcome)
+		state.Reason = r.reason
+		state.DecidedAt = now
+	}
+	if r.approvedSHA != "" {
+		state.ApprovedSHA = r.approvedSHA
+	}
+	if r.rebaseAttempted {
+		state.RebaseAttempts++
+		state.LastRebaseAt = now
+	}
+	if len(r.notified) > 0 {
+		if state.NotifiedSHA != r.headSHA {
+			state.NotifiedSHA, state.Notified = r.headSHA, nil
+		}
+		state.Notified = append(slices.Clone(state.Notified), r.notified...)
+	}
+	return p.state.Save(&state)
+}
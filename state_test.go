@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestStateStores(t *testing.T) {
+	for _, backend := range []string{stateBackendJSON, stateBackendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state")
+			store, err := openStateStore(backend, path)
+			if err != nil {
+				t.Fatalf("Expected no error opening store, got %v", err)
+			}
+
+			state, err := store.Load("octo/repo", 1)
+			if err != nil || state != nil {
+				t.Fatalf("Expected no state for an unknown PR, got %v, %v", state, err)
+			}
+
+			now := time.Now().UTC().Truncate(time.Second)
+			saved := &prState{
+				Repo:           "octo/repo",
+				Number:         1,
+				HeadSHA:        "head",
+				BaseSHA:        "base",
+				Decision:       string(outcomeBlocked),
+				Reason:         reasonChecksFailed,
+				RebaseAttempts: 2,
+				FirstSeen:      now.Add(-time.Hour),
+				LastSeen:       now,
+				DecidedAt:      now,
+				NotifiedSHA:    "head",
+				Notified:       []string{string(eventConflict), string(eventFailing)},
+			}
+			if err := store.Save(saved); err != nil {
+				t.Fatalf("Expected no error saving, got %v", err)
+			}
+			if err := store.Save(&prState{Repo: "octo/other", Number: 1, HeadSHA: "other"}); err != nil {
+				t.Fatalf("Expected no error saving, got %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("Expected no error closing, got %v", err)
+			}
+
+			// State survives reopening the store
+			store, err = openStateStore(backend, path)
+			if err != nil {
+				t.Fatalf("Expected no error reopening store, got %v", err)
+			}
+			defer func() { _ = store.Close() }()
+			state, err = store.Load("octo/repo", 1)
+			if err != nil {
+				t.Fatalf("Expected no error loading, got %v", err)
+			}
+			if state == nil {
+				t.Fatal("Expected saved state to be loaded")
+			}
+			if state.HeadSHA != "head" || state.Decision != string(outcomeBlocked) || state.RebaseAttempts != 2 ||
+				!state.FirstSeen.Equal(saved.FirstSeen) || !state.DecidedAt.Equal(saved.DecidedAt) || !state.LastRebaseAt.IsZero() ||
+				state.NotifiedSHA != "head" || !slices.Equal(state.Notified, saved.Notified) {
+				t.Errorf("Expected %+v, got %+v", saved, state)
+			}
+		})
+	}
+}
+
+func TestSQLiteStateStore_Migrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Schema of the first release, without the notified columns
+	_, err = db.Exec(`CREATE TABLE pr_state (repo TEXT NOT NULL, number INTEGER NOT NULL, head_sha TEXT NOT NULL,
+		base_sha TEXT NOT NULL, decision TEXT NOT NULL, reason TEXT NOT NULL, approved_sha TEXT NOT NULL,
+		rebase_attempts INTEGER NOT NULL, first_seen TEXT NOT NULL, last_seen TEXT NOT NULL, decided_at TEXT NOT NULL,
+		last_rebase_at TEXT NOT NULL, PRIMARY KEY (repo, number));
+		INSERT INTO pr_state VALUES ('octo/repo', 1, 'head', 'base', 'blocked', '', '', 0, '', '', '', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	store, err := openStateStore(stateBackendSQLite, path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	state, err := store.Load("octo/repo", 1)
+	if err != nil || state.HeadSHA != "head" || state.Notified != nil {
+		t.Fatalf("Expected the old state to load, got %+v (%v)", state, err)
+	}
+	state.NotifiedSHA, state.Notified = "head", []string{string(eventConflict)}
+	if err := store.Save(state); err != nil {
+		t.Errorf("Expected no error saving into the migrated table, got %v", err)
+	}
+}
+
+func TestOpenStateStore_InvalidBackend(t *testing.T) {
+	if _, err := openStateStore("redis", filepath.Join(t.TempDir(), "state")); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+}
+
+// newStateTestServer serves a single open PR whose checks are in checkState
+// and whose merge is refused. Its base branch is at *baseHead.
+func newStateTestServer(t *testing.T, checkState string, behindBy int, baseHead *string) *recordingTransport {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/branches/main", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.Branch{Name: github.Ptr("main"), Commit: &github.RepositoryCommit{SHA: github.Ptr(*baseHead)}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.PullRequest{{
+			Number: github.Ptr(1),
+			Title:  github.Ptr("Test PR"),
+			Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+			Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr(checkState), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/compare/main...test-sha", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(behindBy)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/update-branch", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestBranchUpdateResponse{Message: github.Ptr("Updated.")})
+	})
+	mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(false), Message: github.Ptr("Required reviews missing")})
+	})
+	return &recordingTransport{base: &handlerTransport{handler: mux}}
+}
+
+func TestProcessPullRequests_State(t *testing.T) {
+	testCases := []struct {
+		name              string
+		checkState        string
+		behindBy          int
+		maxRebaseAttempts int
+		runs              int
+		skippedRun        int            // Run in which the skip pattern matches the PR, 0 for none
+		baseMovedRun      int            // Run from which the base branch has a new head, 0 for none
+		expectedCalls     map[string]int // "METHOD path" -> number of calls over all runs
+	}{
+		{
+			name:       "unchanged blocked PR is evaluated once",
+			checkState: "failure",
+			runs:       3,
+			expectedCalls: map[string]int{
+				"GET /repos/test-owner/test-repo/commits/test-sha/status": 1,
+			},
+		},
+		{
+			name:         "blocked PR is evaluated again once its base branch moved",
+			checkState:   "failure",
+			runs:         3,
+			baseMovedRun: 2,
+			expectedCalls: map[string]int{
+				"GET /repos/test-owner/test-repo/commits/test-sha/status": 2,
+			},
+		},
+		{
+			name:       "head is approved only once",
+			checkState: "success",
+			runs:       2,
+			expectedCalls: map[string]int{
+				"POST /repos/test-owner/test-repo/pulls/1/reviews": 1,
+				"PUT /repos/test-owner/test-repo/pulls/1/merge":    2,
+			},
+		},
+		{
+			name:              "branch updates stop when the budget is spent",
+			checkState:        "pending",
+			behindBy:          3,
+			maxRebaseAttempts: 2,
+			runs:              4,
+			expectedCalls: map[string]int{
+				"PUT /repos/test-owner/test-repo/pulls/1/update-branch": 2,
+			},
+		},
+		{
+			name:              "skipped PRs keep their state",
+			checkState:        "pending",
+			behindBy:          3,
+			maxRebaseAttempts: 2,
+			runs:              5,
+			skippedRun:        3,
+			expectedCalls: map[string]int{
+				"PUT /repos/test-owner/test-repo/pulls/1/update-branch": 2,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseHead := "base-head-1"
+			transport := newStateTestServer(t, tc.checkState, tc.behindBy, &baseHead)
+			path := filepath.Join(t.TempDir(), "state.json")
+
+			for i := 0; i < tc.runs; i++ {
+				// Every run opens the store again, as separate invocations would
+				store, err := openStateStore(stateBackendJSON, path)
+				if err != nil {
+					t.Fatalf("Expected no error opening store, got %v", err)
+				}
+				if i+1 == tc.baseMovedRun {
+					baseHead = "base-head-2"
+				}
+				skipPattern := ""
+				if i+1 == tc.skippedRun {
+					skipPattern = "^Test"
+				}
+				processor := &PRProcessor{
+					client: github.NewClient(&http.Client{Transport: transport}),
+					cfg: &config{
+						owner:             testOwner,
+						repo:              testRepo,
+						approve:           true,
+						autoRebase:        true,
+						updateStrategy:    updateStrategyMerge,
+						maxRebaseAttempts: tc.maxRebaseAttempts,
+						skipPattern:       skipPattern,
+					},
+					ctx:   context.Background(),
+					state: store,
+				}
+				if err := processor.ProcessPullRequests(); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+			}
+
+			for call, expected := range tc.expectedCalls {
+				method, path, _ := strings.Cut(call, " ")
+				if got := len(transport.find(method, path)); got != expected {
+					t.Errorf("Expected %d calls to %s, got %d", expected, call, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// statusCommentKind identifies the sticky comment summarizing the decision
+const statusCommentKind = "status"
+
+var outcomeTitles = map[prOutcome]string{
+	outcomeSkipped:  "Skipped",
+	outcomeMerged:   "Merged",
+	outcomeApproved: "Approved",
+	outcomeBlocked:  "Blocked",
+	outcomePending:  "Waiting for checks",
+	outcomeUpdated:  "Branch updated",
+	outcomeConflict: "Conflicts",
+	outcomeRetried:  "Re-running failed jobs",
+	outcomeError:    "Error",
+	outcomeClosed:   "Closed",
+}
+
+// nextSteps explains what has to happen for the PR to make progress
+func (r *prReport) nextSteps() string {
+	switch r.outcome {
+	case outcomeSkipped:
+		return "The PR will be processed once the skip reason no longer applies."
+	case outcomeMerged:
+		return "Nothing to do."
+	case outcomeApproved:
+		return "Merge the PR once you are happy with it."
+	case outcomeBlocked:
+		return "Fix the failing checks and push; the PR will be re-evaluated on the next run."
+	case outcomePending:
+		return "Checks are still running; the PR will be re-evaluated on the next run."
+	case outcomeUpdated:
+		return "Checks will re-run on the updated branch; the PR will be re-evaluated on the next run."
+	case outcomeConflict:
+		return fmt.Sprintf("Rebase the branch onto `%s` and resolve the conflicts.", r.baseRef)
+	case outcomeClosed:
+		return "Reopen the PR to continue working on it."
+	case outcomeRetried:
+		return "Failed jobs were re-run; the PR will be re-evaluated once they finish."
+	default:
+		return "The PR will be retried on the next run."
+	}
+}
+
+func formatChecks(checks []string) string {
+	quoted := make([]string, len(checks))
+	for i, check := range checks {
+		quoted[i] = "`" + check + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// statusCommentBody renders the sticky status comment for a report. It
+// contains no timestamps so that an unchanged decision doesn't edit the comment.
+func statusCommentBody(r *prReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s -->\n", commentMarker(statusCommentKind))
+	b.WriteString("### PR status checker\n\n")
+	fmt.Fprintf(&b, "**Decision:** %s", outcomeTitles[r.outcome])
+	if r.reason != "" {
+		fmt.Fprintf(&b, " (%s)", r.reason)
+	}
+	b.WriteString("\n\n")
+	if d := r.dependency; d != nil {
+		fmt.Fprintf(&b, "**Dependency update:** `%s` %s (%s)\n\n", d.pkg, d.versions(), d.updateType)
+	}
+	if len(r.failedChecks) > 0 {
+		fmt.Fprintf(&b, "**Failing checks:** %s\n\n", formatChecks(r.failedChecks))
+	}
+	if len(r.pendingChecks) > 0 {
+		fmt.Fprintf(&b, "**Pending checks:** %s\n\n", formatChecks(r.pendingChecks))
+	}
+	if len(r.retriedJobs) > 0 {
+		fmt.Fprintf(&b, "**Re-run jobs:** %s\n\n", formatChecks(r.retriedJobs))
+	}
+	if len(r.conflictFiles) > 0 {
+		fmt.Fprintf(&b, "**Conflicting files:** %s\n\n", formatChecks(r.conflictFiles))
+	}
+	if r.rebase != "" {
+		fmt.Fprintf(&b, "**Branch update:** %s\n\n", r.rebase)
+	}
+	if r.branchDeletion != "" {
+		fmt.Fprintf(&b, "**Head branch:** %s\n\n", r.branchDeletion)
+	}
+	fmt.Fprintf(&b, "**Next steps:** %s\n\n", r.nextSteps())
+	if r.headSHA != "" {
+		sha := r.headSHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		fmt.Fprintf(&b, "<sub>Evaluated at commit %s.</sub>\n", sha)
+	}
+	return b.String()
+}
+
+// updateStatusComment creates or edits the sticky status comment of the PR
+func (p *PRProcessor) updateStatusComment(pr *github.PullRequest) error {
+	r := p.report(pr)
+	// Unchanged PRs keep the comment written when the decision was taken
+	if !r.inScope || r.outcome == "" || r.unchanged {
+		return nil
+	}
+
+	existing, err := p.findMarkedComment(pr, statusCommentKind)
+	if err != nil {
+		return err
+	}
+	return p.upsertMarkedComment(pr, existing, statusCommentBody(r))
+}
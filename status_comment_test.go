@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestStatusCommentBody(t *testing.T) {
+	testCases := []struct {
+		name     string
+		report   prReport
+		contains []string
+	}{
+		{
+			name: "blocked with failing checks",
+			report: prReport{
+				outcome:      outcomeBlocked,
+				reason:       "status checks failed",
+				failedChecks: []string{"lint", "test"},
+				headSHA:      "0123456789abcdef",
+			},
+			contains: []string{
+				"<!-- pr-status-checker:status -->",
+				"**Decision:** Blocked (status checks failed)",
+				"**Failing checks:** `lint`, `test`",
+				"Fix the failing checks",
+				"commit 0123456",
+			},
+		},
+		{
+			name: "conflict",
+			report: prReport{
+				outcome: outcomeConflict,
+				reason:  "rebase onto main hit conflicts",
+				baseRef: "main",
+			},
+			contains: []string{"**Decision:** Conflicts", "Rebase the branch onto `main`"},
+		},
+		{
+			name: "updated",
+			report: prReport{
+				outcome:       outcomeUpdated,
+				pendingChecks: []string{"build"},
+				rebase:        "rebased onto `main` and force-pushed",
+			},
+			contains: []string{"**Pending checks:** `build`", "**Branch update:** rebased onto `main`"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := statusCommentBody(&tc.report)
+			for _, want := range tc.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("Expected body to contain %q, got:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessPullRequests_StatusComment(t *testing.T) {
+	testCases := []struct {
+		name             string
+		title            string
+		filterByReviewer bool
+		checkState       string
+		runs             int
+		expectedComments int
+		expectedDecision string
+	}{
+		{
+			name:             "failing PR gets a single comment across runs",
+			title:            "Test PR",
+			checkState:       "failure",
+			runs:             2,
+			expectedComments: 1,
+			expectedDecision: "**Decision:** Blocked",
+		},
+		{
+			name:             "skipped PR is told why",
+			title:            "WIP: Test PR",
+			checkState:       "success",
+			runs:             1,
+			expectedComments: 1,
+			expectedDecision: "**Decision:** Skipped (title matches skip pattern ^WIP:)",
+		},
+		{
+			name:             "out of scope PR is not commented on",
+			title:            "Test PR",
+			filterByReviewer: true,
+			checkState:       "success",
+			runs:             1,
+			expectedComments: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			comments := &commentStore{author: &github.User{Login: github.Ptr("test-reviewer"), Type: github.Ptr("User")}}
+			mux := http.NewServeMux()
+			comments.register(t, mux)
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, []*github.PullRequest{{
+					Number: github.Ptr(1),
+					Title:  github.Ptr(tc.title),
+					User:   &github.User{Login: github.Ptr("test-user")},
+					Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+					Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+				}})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+					{State: github.Ptr(tc.checkState), Context: github.Ptr("test-check")},
+				}})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+			})
+
+			for i := 0; i < tc.runs; i++ {
+				processor := &PRProcessor{
+					client: github.NewClient(&http.Client{Transport: &handlerTransport{handler: mux}}),
+					cfg: &config{
+						owner:            testOwner,
+						repo:             testRepo,
+						skipPattern:      "^WIP:",
+						filterByReviewer: tc.filterByReviewer,
+						statusComment:    true,
+					},
+					ctx:         context.Background(),
+					currentUser: "test-reviewer",
+				}
+				if err := processor.ProcessPullRequests(); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+			}
+
+			stored := comments.list()
+			if len(stored) != tc.expectedComments {
+				t.Fatalf("Expected %d comments, got %d", tc.expectedComments, len(stored))
+			}
+			if tc.expectedComments > 0 && !strings.Contains(stored[0].GetBody(), tc.expectedDecision) {
+				t.Errorf("Expected comment to contain %q, got:\n%s", tc.expectedDecision, stored[0].GetBody())
+			}
+			if comments.edits != 0 {
+				t.Errorf("Expected unchanged decision not to edit the comment, got %d edits", comments.edits)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// summaryOutcomes is the order outcomes are counted in by the run summary
+var summaryOutcomes = []prOutcome{
+	outcomeMerged, outcomeApproved, outcomeUpdated, outcomeRetried, outcomePending,
+	outcomeBlocked, outcomeConflict, outcomeSkipped, outcomeClosed, outcomeError,
+}
+
+// printSummary prints what happened to every PR seen during the run
+func (p *PRProcessor) printSummary(w io.Writer) {
+	reports := p.sortedReports()
+	counts := make(map[prOutcome]int)
+	var deletedBranches []string
+	for _, r := range reports {
+		counts[r.outcome]++
+		if r.branchDeleted {
+			deletedBranches = append(deletedBranches, r.headRef)
+		}
+	}
+
+	var parts []string
+	for _, outcome := range summaryOutcomes {
+		if counts[outcome] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[outcome], outcome))
+		}
+	}
+	fmt.Fprintf(w, "\nSummary: %d PRs", len(reports))
+	if len(parts) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(parts, ", "))
+	}
+	if p.cfg.dryRun {
+		fmt.Fprint(w, ", dry run: nothing was changed")
+	}
+	fmt.Fprintln(w)
+
+	for _, r := range reports {
+		fmt.Fprintf(w, "  #%d %s: %s", r.number, r.title, r.outcome)
+		if r.reason != "" {
+			fmt.Fprintf(w, " (%s)", r.reason)
+		}
+		if r.branchDeletion != "" {
+			fmt.Fprintf(w, ", branch %s", r.branchDeletion)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(deletedBranches) > 0 {
+		fmt.Fprintf(w, "Deleted branches: %s\n", strings.Join(deletedBranches, ", "))
+	}
+	p.printCacheStats(w)
+}
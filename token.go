@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// defaultGitHubHost is the host used when neither -github-host nor the git
+// remote name one
+const defaultGitHubHost = "github.com"
+
+// resolveToken looks up a GitHub token from the configured sources in order of
+// precedence and returns it together with a short description of its source.
+// A token given via -token always wins; GITHUB_TOKEN is consulted after the
+// explicit file/command options, and gh/git credential helpers are used last,
+// asking for a token of the configured host.
+func resolveToken(cfg *config) (string, string, error) {
+	if cfg.token != "" {
+		return cfg.token, "flag", nil
+	}
+
+	if cfg.tokenFile != "" {
+		token, err := readTokenFile(cfg.tokenFile)
+		if err != nil {
+			return "", "", err
+		}
+		return token, "token file", nil
+	}
+
+	if cfg.tokenCommand != "" {
+		token, err := runTokenCommand(cfg.tokenCommand)
+		if err != nil {
+			return "", "", err
+		}
+		return token, "token command", nil
+	}
+
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token, "GITHUB_TOKEN", nil
+	}
+
+	host := cfg.host
+	if host == "" {
+		host = defaultGitHubHost
+	}
+	if token, err := ghAuthToken(host); err == nil && token != "" {
+		return token, "gh auth token", nil
+	}
+
+	if token, err := gitCredentialToken(host); err == nil && token != "" {
+		return token, "git credential", nil
+	}
+
+	return "", "", nil
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is supplied by the user on purpose
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func runTokenCommand(command string) (string, error) {
+	cmd := execCommand("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run token command: %v", err)
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return "", fmt.Errorf("token command produced no output")
+	}
+	return token, nil
+}
+
+func ghAuthToken(host string) (string, error) {
+	cmd := execCommand("gh", "auth", "token", "--hostname", host)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitCredentialToken asks the configured git credential helpers for the
+// password stored for the given host, which is a token for GitHub.
+func gitCredentialToken(host string) (string, error) {
+	cmd := execCommand("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n\n", host))
+	// Never prompt for credentials interactively
+	cmd.Env = append(cmd.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if password, ok := strings.CutPrefix(scanner.Text(), "password="); ok {
+			return strings.TrimSpace(password), nil
+		}
+	}
+	return "", fmt.Errorf("no password returned by git credential helper")
+}
+
+// commandTokenSource runs the token command once and caches its output until
+// the token is rejected by GitHub.
+type commandTokenSource struct {
+	mu    sync.Mutex
+	fetch func() (string, error)
+	token string
+}
+
+func newCommandTokenSource(command, initial string) *commandTokenSource {
+	return &commandTokenSource{
+		fetch: func() (string, error) { return runTokenCommand(command) },
+		token: initial,
+	}
+}
+
+func (s *commandTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == "" {
+		token, err := s.fetch()
+		if err != nil {
+			return nil, err
+		}
+		s.token = token
+	}
+	return &oauth2.Token{AccessToken: s.token}, nil
+}
+
+// invalidate drops the cached token if it is still the one that was rejected,
+// so that concurrent requests failing with the same token only refresh once.
+func (s *commandTokenSource) invalidate(rejected string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == rejected {
+		s.token = ""
+	}
+}
+
+// refreshingTransport retries a request once with a freshly fetched token
+// when GitHub answers 401 Unauthorized.
+type refreshingTransport struct {
+	source *commandTokenSource
+	base   http.RoundTripper
+}
+
+func (t *refreshingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.roundTripWithToken(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	_ = resp.Body.Close()
+	t.source.invalidate(token.AccessToken)
+	token, err = t.source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %v", err)
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.roundTripWithToken(req, token)
+}
+
+func (t *refreshingTransport) roundTripWithToken(req *http.Request, token *oauth2.Token) (*http.Response, error) {
+	req2 := req.Clone(req.Context())
+	token.SetAuthHeader(req2)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req2)
+}
+
+// newAuthenticatedHTTPClient returns an HTTP client authenticating with the
+// configured token. Tokens obtained from -token-command are refreshed by
+// re-running the command when they are rejected.
+func newAuthenticatedHTTPClient(ctx context.Context, cfg *config) *http.Client {
+	if cfg.tokenCommand != "" && cfg.tokenSource == "token command" {
+		return &http.Client{
+			Transport: &refreshingTransport{source: newCommandTokenSource(cfg.tokenCommand, cfg.token)},
+		}
+	}
+
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: cfg.token},
+	)
+	return oauth2.NewClient(ctx, ts)
+}
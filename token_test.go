@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockCommands replaces execCommand with a helper process whose output is
+// chosen by the full command line or, failing that, by the name of the
+// executed command. Commands missing from outputs exit with a non-zero status.
+func mockCommands(t *testing.T, outputs map[string]string) {
+	t.Helper()
+	origExecCommand := execCommand
+	t.Cleanup(func() { execCommand = origExecCommand })
+
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestCommandHelper", "--", command}
+		cs = append(cs, args...)
+		//nolint:gosec // This is a test helper that only runs with specific test flags
+		cmd := exec.Command(os.Args[0], cs...)
+		output, ok := outputs[strings.Join(append([]string{command}, args...), " ")]
+		if !ok {
+			output, ok = outputs[command]
+		}
+		cmd.Env = []string{
+			"GO_WANT_HELPER_PROCESS=1",
+			"MOCK_COMMAND_OUTPUT=" + output,
+			fmt.Sprintf("MOCK_COMMAND_FAIL=%v", !ok),
+		}
+		return cmd
+	}
+}
+
+// TestCommandHelper is a helper for mocking arbitrary commands
+func TestCommandHelper(_ *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	if os.Getenv("MOCK_COMMAND_FAIL") == "true" {
+		os.Exit(1)
+	}
+	fmt.Print(os.Getenv("MOCK_COMMAND_OUTPUT"))
+	os.Exit(0)
+}
+
+func clearTokenEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{"GITHUB_TOKEN", "GITHUB_TOKEN_FILE", "GITHUB_TOKEN_COMMAND"} {
+		t.Setenv(env, "")
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+
+	testCases := []struct {
+		name           string
+		cfg            config
+		env            string
+		commands       map[string]string
+		expectedToken  string
+		expectedSource string
+	}{
+		{
+			name:           "flag wins over everything",
+			cfg:            config{token: "flag-token", tokenFile: tokenFile},
+			env:            "env-token",
+			expectedToken:  "flag-token",
+			expectedSource: "flag",
+		},
+		{
+			name:           "token file",
+			cfg:            config{tokenFile: tokenFile},
+			env:            "env-token",
+			expectedToken:  "file-token",
+			expectedSource: "token file",
+		},
+		{
+			name:           "token command",
+			cfg:            config{tokenCommand: "vault read token"},
+			commands:       map[string]string{"sh": "command-token\n"},
+			expectedToken:  "command-token",
+			expectedSource: "token command",
+		},
+		{
+			name:           "environment variable",
+			env:            "env-token",
+			commands:       map[string]string{"gh": "gh-token\n"},
+			expectedToken:  "env-token",
+			expectedSource: "GITHUB_TOKEN",
+		},
+		{
+			name:           "gh auth token fallback",
+			commands:       map[string]string{"gh": "gh-token\n"},
+			expectedToken:  "gh-token",
+			expectedSource: "gh auth token",
+		},
+		{
+			name:           "git credential fallback",
+			commands:       map[string]string{"git": "protocol=https\nhost=github.com\nusername=x-access-token\npassword=git-token\n"},
+			expectedToken:  "git-token",
+			expectedSource: "git credential",
+		},
+		{
+			name:           "gh auth token of the enterprise host",
+			cfg:            config{host: "ghe.example.com"},
+			commands:       map[string]string{"gh auth token --hostname ghe.example.com": "ghe-token\n", "gh auth token --hostname github.com": "github-token\n"},
+			expectedToken:  "ghe-token",
+			expectedSource: "gh auth token",
+		},
+		{
+			name:          "no token available",
+			commands:      map[string]string{},
+			expectedToken: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clearTokenEnv(t)
+			t.Setenv("GITHUB_TOKEN", tc.env)
+			mockCommands(t, tc.commands)
+
+			token, source, err := resolveToken(&tc.cfg)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if token != tc.expectedToken {
+				t.Errorf("Expected token to be '%s', got '%s'", tc.expectedToken, token)
+			}
+			if source != tc.expectedSource {
+				t.Errorf("Expected source to be '%s', got '%s'", tc.expectedSource, source)
+			}
+		})
+	}
+}
+
+func TestResolveTokenErrors(t *testing.T) {
+	clearTokenEnv(t)
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(emptyFile, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		cfg  config
+	}{
+		{name: "missing token file", cfg: config{tokenFile: filepath.Join(t.TempDir(), "missing")}},
+		{name: "empty token file", cfg: config{tokenFile: emptyFile}},
+		{name: "failing token command", cfg: config{tokenCommand: "false"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCommands(t, map[string]string{})
+			if _, _, err := resolveToken(&tc.cfg); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadConfigWithTokenFile(t *testing.T) {
+	clearTokenEnv(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("  file-token \n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := loadConfigWithFlags(flags, []string{"--token-file", tokenFile, "-owner", testOwner, "-repo", testRepo})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.token != "file-token" {
+		t.Errorf("Expected token to be 'file-token', got '%s'", cfg.token)
+	}
+	if cfg.tokenSource != "token file" {
+		t.Errorf("Expected tokenSource to be 'token file', got '%s'", cfg.tokenSource)
+	}
+}
+
+func TestLoadConfigWithoutToken(t *testing.T) {
+	clearTokenEnv(t)
+	mockCommands(t, map[string]string{})
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := loadConfigWithFlags(flags, []string{"-owner", testOwner, "-repo", testRepo}); err == nil {
+		t.Error("Expected error when no token is available, got nil")
+	}
+}
+
+func TestRefreshingTransport(t *testing.T) {
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer fresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	fetches := 0
+	source := &commandTokenSource{
+		token: "expired-token",
+		fetch: func() (string, error) {
+			fetches++
+			return "fresh-token", nil
+		},
+	}
+	client := &http.Client{Transport: &refreshingTransport{source: source}}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 after refresh, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "payload" {
+		t.Errorf("Expected request body to be replayed, got '%s'", body)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected token command to run once, ran %d times", fetches)
+	}
+	if len(authHeaders) != 2 || authHeaders[0] != "Bearer expired-token" {
+		t.Errorf("Unexpected Authorization headers: %v", authHeaders)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stages of the decision on a PR, in the order they are usually reached
+const (
+	stageLock    = "lock"
+	stagePolicy  = "policy"
+	stageChecks  = "checks"
+	stageRetry   = "retry"
+	stageUpdate  = "update"
+	stageApprove = "approve"
+	stageMerge   = "merge"
+)
+
+// decisionStep is one evaluation that contributed to the decision on a PR:
+// the rule that was evaluated, what it was evaluated against and its result
+type decisionStep struct {
+	Stage  string            `json:"stage"`
+	Rule   string            `json:"rule"`
+	Inputs map[string]string `json:"inputs,omitempty"`
+	Result string            `json:"result"`
+}
+
+// String formats the step as "[stage] rule: result (input=value, ...)"
+func (s decisionStep) String() string {
+	line := fmt.Sprintf("[%s] %s: %s", s.Stage, s.Rule, s.Result)
+	if len(s.Inputs) == 0 {
+		return line
+	}
+	keys := make([]string, 0, len(s.Inputs))
+	for key := range s.Inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	inputs := make([]string, len(keys))
+	for i, key := range keys {
+		inputs[i] = key + "=" + s.Inputs[key]
+	}
+	return line + " (" + strings.Join(inputs, ", ") + ")"
+}
+
+// record appends an evaluation to the decision trace of the PR
+func (r *prReport) record(stage, rule, result string, inputs map[string]string) {
+	r.trace = append(r.trace, decisionStep{Stage: stage, Rule: rule, Inputs: inputs, Result: result})
+}
+
+// formatTrace lists the steps of a decision trace, numbered and indented
+func formatTrace(steps []decisionStep) string {
+	var b strings.Builder
+	for i, step := range steps {
+		fmt.Fprintf(&b, "    %d. %s\n", i+1, step)
+	}
+	return b.String()
+}
+
+// traceValue formats a fact or setting for a decision trace
+func traceValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		entries := make([]string, len(keys))
+		for i, key := range keys {
+			entries[i] = key + ": " + v[key]
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case time.Duration:
+		return v.Round(time.Second).String()
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// checksInputs describes the checks of a commit for a decision trace
+func checksInputs(sha string, failedStatuses, pendingStatuses []string, total int) map[string]string {
+	return map[string]string{
+		"head":     sha,
+		"reported": strconv.Itoa(total),
+		"failed":   traceValue(failedStatuses),
+		"pending":  traceValue(pendingStatuses),
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestDecisionTrace(t *testing.T) {
+	pol, err := compilePolicy(&policyFile{Rules: []policyRuleConfig{
+		{Name: "docs", When: "files.all(f, f.startsWith('docs/'))", Action: "merge"},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transport := newPolicyTestServer(t, "Bump lib", []string{"docs/README.md"})
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg:    &config{owner: testOwner, repo: testRepo, skipPattern: "^WIP"},
+		ctx:    context.Background(),
+		policy: pol,
+	}
+	pr := &github.PullRequest{
+		Number: github.Ptr(1),
+		Title:  github.Ptr("Bump lib"),
+		Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+		Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+	}
+	if err := processor.processSinglePR(pr); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	checks := "(failed=[], head=test-sha, pending=[], reported=1)"
+	expected := []string{
+		`[policy] skip-pattern: no match (title="Bump lib")`,
+		"[policy] docs: matched, merge (files=[docs/README.md])",
+		"[checks] status-checks: passed " + checks,
+		"[checks] status-checks: passed " + checks,
+		"[approve] approve: not requested (head=test-sha, requested=false)",
+		"[merge] merge: merged (head=test-sha, method=merge)",
+	}
+	var steps []string
+	for _, step := range processor.report(pr).trace {
+		steps = append(steps, step.String())
+	}
+	if strings.Join(steps, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected trace:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(steps, "\n"))
+	}
+
+	data, err := json.Marshal(processor.report(pr).notifyView())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(string(data), `"trace":[{"stage":"policy","rule":"skip-pattern","inputs":{"title":"\"Bump lib\""},"result":"no match"}`) {
+		t.Errorf("Expected the JSON view to embed the trace, got %s", data)
+	}
+}
+
+func TestDecisionTrace_Freeze(t *testing.T) {
+	processor := &PRProcessor{
+		cfg:          &config{owner: testOwner, repo: testRepo},
+		ctx:          context.Background(),
+		activeFreeze: &freezeWindow{name: "release", action: policySkip},
+	}
+	pr := &github.PullRequest{Number: github.Ptr(1), Title: github.Ptr("Fix bug")}
+	decision, err := processor.evaluatePolicy(pr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decision.action != policySkip {
+		t.Errorf("Expected the freeze to skip the PR, got %+v", decision)
+	}
+
+	trace := processor.report(pr).trace
+	if len(trace) != 2 {
+		t.Fatalf("Expected the default rule and the freeze in the trace, got %v", trace)
+	}
+	if s := trace[0].String(); s != "[policy] default: no rule matched, merge" {
+		t.Errorf("Unexpected first step %q", s)
+	}
+	if s := trace[1].String(); !strings.HasPrefix(s, "[policy] freeze:release: merge downgraded to skip (window=release") {
+		t.Errorf("Unexpected freeze step %q", s)
+	}
+}
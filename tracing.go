@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/google/go-github/v71/github"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported trace exporters
+const (
+	traceExporterOTLP   = "otlp"   // OTLP over HTTP, configured with the standard OTEL_EXPORTER_OTLP_* variables
+	traceExporterStdout = "stdout" // Pretty-printed spans on stdout for local debugging
+)
+
+const (
+	tracerName  = "github.com/yutachaos/pr-status-checker"
+	serviceName = "pr-status-checker"
+)
+
+// tracer returns the tracer of the global tracer provider, which is a no-op
+// unless tracing is set up
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Span attribute keys
+const (
+	attrRepository = attribute.Key("github.repository")
+	attrPRNumber   = attribute.Key("github.pull_request.number")
+	attrHeadSHA    = attribute.Key("github.pull_request.head_sha")
+	attrBaseRef    = attribute.Key("github.pull_request.base_ref")
+)
+
+// setupTracing installs a global tracer provider exporting to the given
+// exporter. The returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case traceExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case traceExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q: must be %q or %q", exporter, traceExporterOTLP, traceExporterStdout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %v", exporter, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+type traceAttributesKey struct{}
+
+// withTraceAttributes returns a context whose GitHub call spans carry the
+// given attributes in addition to the ones already in ctx
+func withTraceAttributes(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	existing, _ := ctx.Value(traceAttributesKey{}).([]attribute.KeyValue)
+	combined := append(append([]attribute.KeyValue{}, existing...), attrs...)
+	return context.WithValue(ctx, traceAttributesKey{}, combined)
+}
+
+func traceAttributes(ctx context.Context) []attribute.KeyValue {
+	attrs, _ := ctx.Value(traceAttributesKey{}).([]attribute.KeyValue)
+	return attrs
+}
+
+// withContext returns a copy of the processor making its calls with ctx. The
+// copy shares the reports of the current run.
+func (p *PRProcessor) withContext(ctx context.Context) *PRProcessor {
+	clone := *p
+	clone.ctx = ctx
+	return &clone
+}
+
+// startSpan starts a span as a child of the processor's context and returns a
+// processor whose calls are made within it. The span carries the attributes
+// of the enclosing spans started this way.
+func (p *PRProcessor) startSpan(name string, attrs ...attribute.KeyValue) (*PRProcessor, trace.Span) {
+	ctx := withTraceAttributes(p.ctx, attrs...)
+	ctx, span := tracer().Start(ctx, name, trace.WithAttributes(traceAttributes(ctx)...))
+	return p.withContext(ctx), span
+}
+
+// startPRSpan starts a span for work on a single PR
+func (p *PRProcessor) startPRSpan(name string, pr *github.PullRequest) (*PRProcessor, trace.Span) {
+	return p.startSpan(name,
+		attrPRNumber.Int(pr.GetNumber()),
+		attrHeadSHA.String(pr.GetHead().GetSHA()),
+	)
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingTransport records a span for every GitHub API request
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	endpoint := apiEndpoint(req.URL.Path)
+
+	attrs := append(append([]attribute.KeyValue{}, traceAttributes(req.Context())...),
+		attribute.String("http.request.method", req.Method),
+		attribute.String("github.endpoint", endpoint),
+	)
+	ctx, span := tracer().Start(req.Context(), "GitHub "+req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		span.SetAttributes(attribute.Int("github.rate_limit.remaining", remaining))
+	}
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetupTracing_InvalidExporter(t *testing.T) {
+	if _, err := setupTracing(context.Background(), "jaeger"); err == nil {
+		t.Error("Expected an error for an unknown exporter")
+	}
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestProcessPullRequests_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, []*github.PullRequest{{
+			Number: github.Ptr(1),
+			Title:  github.Ptr("Test PR"),
+			Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha")},
+			Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr("failure"), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/test-sha/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: &tracingTransport{base: &handlerTransport{handler: mux}}}),
+		cfg:    &config{owner: testOwner, repo: testRepo},
+		ctx:    context.Background(),
+	}
+	if err := processor.ProcessPullRequests(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	run, ok := spans["ProcessPullRequests"]
+	if !ok {
+		t.Fatalf("Expected a ProcessPullRequests span, got %v", spans)
+	}
+	pr, ok := spans["processSinglePR"]
+	if !ok {
+		t.Fatal("Expected a processSinglePR span")
+	}
+	if pr.Parent.SpanID() != run.SpanContext.SpanID() {
+		t.Error("Expected processSinglePR to be a child of ProcessPullRequests")
+	}
+
+	status, ok := spans["GitHub GET /repos/{owner}/{repo}/commits/{sha}/status"]
+	if !ok {
+		t.Fatal("Expected a span for the status API call")
+	}
+	if status.Parent.SpanID() != pr.SpanContext.SpanID() {
+		t.Error("Expected the status API call to be a child of processSinglePR")
+	}
+	expected := map[attribute.Key]attribute.Value{
+		attrRepository: attribute.StringValue("test-owner/test-repo"),
+		attrPRNumber:   attribute.IntValue(1),
+		attrHeadSHA:    attribute.StringValue("test-sha"),
+	}
+	for key, want := range expected {
+		if got, ok := spanAttribute(status, key); !ok || got != want {
+			t.Errorf("Expected attribute %s=%v, got %v", key, want.Emit(), got.Emit())
+		}
+	}
+
+	list, ok := spans["GitHub GET /repos/{owner}/{repo}/pulls"]
+	if !ok {
+		t.Fatal("Expected a span for the pull request list API call")
+	}
+	if _, ok := spanAttribute(list, attrPRNumber); ok {
+		t.Error("Expected the list call not to carry a PR number")
+	}
+}
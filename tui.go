@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// ANSI escape sequences used by the terminal UI
+const (
+	ansiClear      = "\033[H\033[2J"
+	ansiAltScreen  = "\033[?1049h"
+	ansiMainScreen = "\033[?1049l"
+	ansiReverse    = "\033[7m"
+	ansiDim        = "\033[2m"
+	ansiReset      = "\033[0m"
+)
+
+const (
+	tuiTitleWidth   = 50
+	tuiKeyHelp      = "j/k move  enter/d details  a approve  m merge  u update branch  r re-run checks  s skip  f refresh  q quit"
+	tuiSkippedByYou = "skipped by you"
+)
+
+// tuiRow is a PR listed in the terminal UI with the decision the batch mode
+// would make for it
+type tuiRow struct {
+	pr      *github.PullRequest
+	report  *prReport
+	skipped bool // Skipped by the user for this session
+}
+
+// tui is an interactive terminal UI listing the open PRs and acting on them
+// with the actions of the batch mode
+type tui struct {
+	p   *PRProcessor
+	in  *bufio.Reader
+	out io.Writer
+
+	lineMode bool // Keys are confirmed with Enter, which is not a key of its own then
+
+	rows    []*tuiRow
+	cursor  int
+	details bool
+	message string
+}
+
+// runTUI runs the terminal UI on stdin and stdout until the user quits
+func runTUI(p *PRProcessor) error {
+	t := newTUI(p, os.Stdin, os.Stdout)
+	restore, err := rawTerminal()
+	if err != nil {
+		fmt.Printf("Could not switch the terminal to raw mode, confirm keys with Enter: %v\n", err)
+		t.lineMode = true
+	} else {
+		defer restore()
+	}
+	fmt.Print(ansiAltScreen)
+	defer fmt.Print(ansiMainScreen)
+
+	return t.run()
+}
+
+func newTUI(p *PRProcessor, in io.Reader, out io.Writer) *tui {
+	return &tui{p: p, in: bufio.NewReader(in), out: out}
+}
+
+// rawTerminal makes stdin deliver single keystrokes without echo and returns
+// a function restoring the previous terminal settings
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) (string, error) {
+		cmd := execCommand("stty", args...)
+		cmd.Stdin = os.Stdin
+		output, err := cmd.Output()
+		return strings.TrimSpace(string(output)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() { _, _ = stty(saved) }, nil
+}
+
+func (t *tui) run() error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	for {
+		t.render()
+		key, err := t.readKey()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if quit := t.handleKey(key); quit {
+			return nil
+		}
+	}
+}
+
+// readKey returns the next keystroke, mapping arrow keys onto j and k
+func (t *tui) readKey() (string, error) {
+	b, err := t.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if b != 0x1b {
+		return string(b), nil
+	}
+	if next, err := t.in.ReadByte(); err != nil || next != '[' {
+		return "esc", nil
+	}
+	arrow, err := t.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch arrow {
+	case 'A':
+		return "k", nil
+	case 'B':
+		return "j", nil
+	default:
+		return "esc", nil
+	}
+}
+
+// handleKey performs the action bound to key and reports whether to quit
+func (t *tui) handleKey(key string) bool {
+	row := t.selected()
+	switch key {
+	case "q":
+		return true
+	case "j":
+		if t.cursor < len(t.rows)-1 {
+			t.cursor++
+		}
+	case "k":
+		if t.cursor > 0 {
+			t.cursor--
+		}
+	case "\r", "\n":
+		if !t.lineMode {
+			t.details = !t.details
+		}
+	case "d":
+		t.details = !t.details
+	case "f":
+		if err := t.refresh(); err != nil {
+			t.message = "Error refreshing: " + err.Error()
+		} else {
+			t.message = "Refreshed"
+		}
+	case "s":
+		if row != nil {
+			row.skipped = true
+			t.message = fmt.Sprintf("#%d: %s", row.pr.GetNumber(), tuiSkippedByYou)
+			if t.cursor < len(t.rows)-1 {
+				t.cursor++
+			}
+		}
+	case "a", "m", "u", "r":
+		if row != nil {
+			t.act(row, key)
+		}
+	}
+	return false
+}
+
+func (t *tui) selected() *tuiRow {
+	if t.cursor < 0 || t.cursor >= len(t.rows) {
+		return nil
+	}
+	return t.rows[t.cursor]
+}
+
+// refresh lists the open PRs and works out what the batch mode would do with
+// each of them
+func (t *tui) refresh() error {
+	fmt.Fprintln(t.out, ansiClear+"Evaluating open PRs...")
+	prs, _, err := t.p.client.PullRequests.List(t.p.ctx, t.p.cfg.owner, t.p.cfg.repo, &github.PullRequestListOptions{
+		State: "open",
+	})
+	if err != nil {
+		return fmt.Errorf("error getting pull requests: %w", err)
+	}
+	t.p.activeFreeze = t.p.freeze.active(time.Now())
+
+	skipped := make(map[int]bool)
+	for _, row := range t.rows {
+		skipped[row.pr.GetNumber()] = row.skipped
+	}
+	t.rows = nil
+	for _, pr := range prs {
+		t.rows = append(t.rows, &tuiRow{pr: pr, report: t.p.planPR(pr), skipped: skipped[pr.GetNumber()]})
+	}
+	if t.cursor >= len(t.rows) {
+		t.cursor = len(t.rows) - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+	return nil
+}
+
+// tuiCommands maps the action keys to the subcommand they run
+var tuiCommands = map[string]string{
+	"a": commandApprove,
+	"m": commandMerge,
+	"u": commandUpdate,
+	"r": commandRerun,
+}
+
+// act runs the subcommand bound to key on the selected PR, so that the
+// policy, checks, budgets and everything a run does after deciding on a PR
+// apply, then re-evaluates the PR
+func (t *tui) act(row *tuiRow, key string) {
+	number := row.pr.GetNumber()
+	proc := t.p.withContext(t.p.ctx)
+	var out strings.Builder
+	err := proc.runPRCommand(&out, tuiCommands[key], number)
+
+	// Refusals are recorded in the report, other results are printed
+	r := proc.report(row.pr)
+	prefix := fmt.Sprintf("PR #%d: ", number)
+	switch {
+	case r.outcome != "":
+		t.message = fmt.Sprintf("#%d: %s, %s", number, r.outcome, r.reason)
+	case err != nil:
+		t.message = fmt.Sprintf("#%d: %s", number, strings.TrimPrefix(err.Error(), prefix))
+	default:
+		line, _, _ := strings.Cut(out.String(), "\n")
+		t.message = fmt.Sprintf("#%d: %s", number, strings.TrimPrefix(line, prefix))
+	}
+	if t.p.cfg.dryRun && !strings.HasSuffix(t.message, dryRunSuffix) {
+		t.message += dryRunSuffix
+	}
+
+	pr, _, err := t.p.client.PullRequests.Get(t.p.ctx, t.p.cfg.owner, t.p.cfg.repo, number)
+	if err != nil {
+		t.message += fmt.Sprintf(" (error refreshing PR: %v)", err)
+		return
+	}
+	row.pr = pr
+	row.report = t.p.planPR(pr)
+}
+
+// render draws the PR table, the details of the selected PR and the status
+// line
+func (t *tui) render() {
+	var b strings.Builder
+	b.WriteString(ansiClear)
+	fmt.Fprintf(&b, "%s/%s: %d open PRs\n", t.p.cfg.owner, t.p.cfg.repo, len(t.rows))
+	if w := t.p.activeFreeze; w != nil {
+		fmt.Fprintf(&b, "Merge freeze active: %s\n", w)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  %-6s %-*s %-14s %s\n", "PR", tuiTitleWidth, "Title", "Decision", "Reason")
+	for i, row := range t.rows {
+		line := fmt.Sprintf("  %-6s %-*s %-14s %s", fmt.Sprintf("#%d", row.pr.GetNumber()), tuiTitleWidth,
+			truncate(row.pr.GetTitle(), tuiTitleWidth), row.decision(), row.reason())
+		switch {
+		case i == t.cursor:
+			line = ansiReverse + ">" + line[1:] + ansiReset
+		case row.skipped:
+			line = ansiDim + line + ansiReset
+		}
+		b.WriteString(line + "\n")
+	}
+	if row := t.selected(); t.details && row != nil {
+		fmt.Fprintf(&b, "\n#%d %s\n%s", row.pr.GetNumber(), row.pr.GetTitle(), reportDetails(row.pr, row.report))
+	}
+	b.WriteString("\n" + tuiKeyHelp + "\n")
+	if t.message != "" {
+		b.WriteString(t.message + "\n")
+	}
+	fmt.Fprint(t.out, b.String())
+}
+
+func (row *tuiRow) decision() string {
+	if row.skipped {
+		return "skip"
+	}
+	return plannedDecision(row.report)
+}
+
+func (row *tuiRow) reason() string {
+	if row.skipped {
+		return tuiSkippedByYou
+	}
+	return plannedReason(row.report)
+}
+
+// truncate shortens s to at most width runes, marking cut text with an
+// ellipsis
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
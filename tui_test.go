@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestTUI(t *testing.T) {
+	prs := []*github.PullRequest{
+		{Number: github.Ptr(1), Title: github.Ptr("Green PR"), Head: &github.PullRequestBranch{SHA: github.Ptr("green-sha")}},
+		{Number: github.Ptr(2), Title: github.Ptr("Red PR"), Head: &github.PullRequestBranch{SHA: github.Ptr("red-sha")}},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, prs)
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/{number}", func(w http.ResponseWriter, r *http.Request) {
+		number, _ := strconv.Atoi(r.PathValue("number"))
+		writeJSON(t, w, prs[number-1])
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, r *http.Request) {
+		state := "success"
+		if r.PathValue("sha") == "red-sha" {
+			state = "failure"
+		}
+		writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+			{State: github.Ptr(state), Context: github.Ptr("test-check")},
+		}})
+	})
+	mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+	})
+	mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/{number}/merge", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+	})
+	transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+	processor := &PRProcessor{
+		client: github.NewClient(&http.Client{Transport: transport}),
+		cfg:    &config{owner: testOwner, repo: testRepo, autoRebase: false},
+		ctx:    context.Background(),
+	}
+
+	// Show details, move down with an arrow key, try to approve and merge the
+	// red PR, move back up and merge the green one
+	var out bytes.Buffer
+	ui := newTUI(processor, strings.NewReader("d\x1b[Bamkmq"), &out)
+	if err := ui.run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if decision := ui.rows[0].decision(); decision != "merge" {
+		t.Errorf("Expected the green PR to be merged, got %q", decision)
+	}
+	if decision := ui.rows[1].decision(); decision != "blocked" {
+		t.Errorf("Expected the red PR to be blocked, got %q", decision)
+	}
+	screen := out.String()
+	for _, expected := range []string{"test-owner/test-repo: 2 open PRs", "Failing checks: test-check", "#2: blocked, status checks not passed", "#2: blocked, " + reasonChecksFailed, "#1: merged, all status checks passed"} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("Expected the screen to contain %q", expected)
+		}
+	}
+
+	if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/1/merge"); len(calls) != 1 {
+		t.Errorf("Expected the green PR to be merged once, got %d merges", len(calls))
+	}
+	if calls := transport.find("PUT", "/repos/test-owner/test-repo/pulls/2/merge"); len(calls) != 0 {
+		t.Errorf("Expected the red PR not to be merged, got %d merges", len(calls))
+	}
+	if calls := transport.find("POST", "/repos/test-owner/test-repo/pulls/2/reviews"); len(calls) != 0 {
+		t.Errorf("Expected the red PR not to be approved, got %d reviews", len(calls))
+	}
+}
+
+func TestTUI_Keys(t *testing.T) {
+	const (
+		reviews = "POST /repos/test-owner/test-repo/pulls/1/reviews"
+		merge   = "PUT /repos/test-owner/test-repo/pulls/1/merge"
+		update  = "PUT /repos/test-owner/test-repo/pulls/1/update-branch"
+		rerun   = "POST /repos/test-owner/test-repo/actions/runs/10/rerun-failed-jobs"
+		comment = "POST /repos/test-owner/test-repo/issues/1/comments"
+	)
+	testCases := []struct {
+		name            string
+		key             string
+		skipPattern     string
+		behindBy        int
+		budget          int
+		existingComment string
+		statusComment   bool
+		expectedMessage string
+		expectedCalls   []string // "METHOD path" of the changes made
+	}{
+		{
+			name:            "a approves",
+			key:             "a",
+			expectedMessage: "#1: approved, approved on request",
+			expectedCalls:   []string{reviews},
+		},
+		{
+			name:            "m merges and finishes the PR",
+			key:             "m",
+			statusComment:   true,
+			expectedMessage: "#1: merged, all status checks passed",
+			expectedCalls:   []string{reviews, merge, comment},
+		},
+		{
+			name:            "m refuses PRs skipped by the policy",
+			key:             "m",
+			skipPattern:     "^Green",
+			expectedMessage: "#1: skipped, title matches skip pattern",
+		},
+		{
+			name:            "u updates without merging",
+			key:             "u",
+			behindBy:        2,
+			expectedMessage: "#1: updated, branch was behind the base branch",
+			expectedCalls:   []string{update},
+		},
+		{
+			name:            "u leaves up-to-date branches",
+			key:             "u",
+			expectedMessage: "#1: Branch is up to date with main",
+		},
+		{
+			name:            "r re-runs failed jobs within the budget",
+			key:             "r",
+			budget:          2,
+			expectedMessage: "#1: retried, attempt 1 of 2",
+			expectedCalls:   []string{rerun, comment},
+		},
+		{
+			name:            "r refuses once the budget is exhausted",
+			key:             "r",
+			budget:          2,
+			existingComment: "<!-- pr-status-checker:rerun attempts=2 -->\nRe-ran failed jobs",
+			expectedMessage: "#1: no jobs re-run, budget exhausted",
+		},
+		{
+			name:            "r refuses without a budget",
+			key:             "r",
+			expectedMessage: "#1: re-running failed jobs is disabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pr := &github.PullRequest{
+				Number: github.Ptr(1),
+				State:  github.Ptr("open"),
+				Title:  github.Ptr("Green PR"),
+				User:   &github.User{Login: github.Ptr("octocat")},
+				Head:   &github.PullRequestBranch{SHA: github.Ptr("test-sha"), Ref: github.Ptr("feature")},
+				Base:   &github.PullRequestBranch{SHA: github.Ptr("base-sha"), Ref: github.Ptr("main")},
+			}
+			var comments commentStore
+			if tc.existingComment != "" {
+				comments.comments = []*github.IssueComment{{ID: github.Ptr[int64](2), Body: github.Ptr(tc.existingComment), User: commentAuthor}}
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, []*github.PullRequest{pr})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/pulls/1", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, pr)
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/status", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.CombinedStatus{Statuses: []*github.RepoStatus{
+					{State: github.Ptr("success"), Context: github.Ptr("test-check")},
+				}})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/commits/{sha}/check-runs", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.ListCheckRunsResults{Total: github.Ptr(0)})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/compare/{spec}", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.CommitsComparison{BehindBy: github.Ptr(tc.behindBy)})
+			})
+			mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/update-branch", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.PullRequestBranchUpdateResponse{Message: github.Ptr("Updated.")})
+			})
+			mux.HandleFunc("POST /repos/test-owner/test-repo/pulls/1/reviews", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.PullRequestReview{ID: github.Ptr(int64(1))})
+			})
+			mux.HandleFunc("PUT /repos/test-owner/test-repo/pulls/1/merge", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.PullRequestMergeResult{Merged: github.Ptr(true)})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/actions/runs", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.WorkflowRuns{TotalCount: github.Ptr(1), WorkflowRuns: []*github.WorkflowRun{
+					{ID: github.Ptr[int64](10), Name: github.Ptr("CI"), Status: github.Ptr("completed"), Conclusion: github.Ptr("failure")},
+				}})
+			})
+			mux.HandleFunc("GET /repos/test-owner/test-repo/actions/runs/{id}/jobs", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(t, w, &github.Jobs{TotalCount: github.Ptr(1), Jobs: []*github.WorkflowJob{
+					{Name: github.Ptr("test"), Conclusion: github.Ptr("failure")},
+				}})
+			})
+			mux.HandleFunc("POST /repos/test-owner/test-repo/actions/runs/{id}/rerun-failed-jobs", func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			})
+			comments.register(t, mux)
+			transport := &recordingTransport{base: &handlerTransport{handler: mux}}
+
+			processor := &PRProcessor{
+				client: github.NewClient(&http.Client{Transport: transport}),
+				cfg: &config{
+					owner: testOwner, repo: testRepo, approve: true, autoRebase: true, updateStrategy: updateStrategyMerge,
+					skipPattern: tc.skipPattern, rerunFailedJobs: tc.budget, statusComment: tc.statusComment, botLogin: commentAuthor.GetLogin(),
+				},
+				ctx: context.Background(),
+			}
+			ui := newTUI(processor, strings.NewReader(tc.key+"q"), &bytes.Buffer{})
+			if err := ui.run(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if !strings.HasPrefix(ui.message, tc.expectedMessage) {
+				t.Errorf("Expected the message %q, got %q", tc.expectedMessage, ui.message)
+			}
+			var changes []string
+			for _, req := range transport.requests {
+				if req.method != http.MethodGet {
+					changes = append(changes, req.method+" "+req.path)
+				}
+			}
+			if strings.Join(changes, ", ") != strings.Join(tc.expectedCalls, ", ") {
+				t.Errorf("Expected changes %v, got %v", tc.expectedCalls, changes)
+			}
+		})
+	}
+}